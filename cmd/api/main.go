@@ -2,10 +2,16 @@ package main
 
 import (
 "context"
+"crypto/sha256"
+"crypto/subtle"
 "database/sql"
+"encoding/hex"
 "encoding/json"
+"errors"
+"fmt"
 "log"
 "net/http"
+"net/url"
 "os"
 "strconv"
 "strings"
@@ -18,26 +24,66 @@ _ "github.com/lib/pq"
 "github.com/rs/zerolog"
 zlog "github.com/rs/zerolog/log"
 
+"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/audit"
 "github.com/ibraheemcisse/ioc-labs-ecommerce/internal/auth"
+"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/clientip"
 "github.com/ibraheemcisse/ioc-labs-ecommerce/internal/handlers"
+"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/mailer"
+"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/metrics"
+"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/middleware"
+"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/orders"
+"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/services"
+"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/tracing"
+"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/money"
+"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/validator"
 )
 
 var (
-db          *sql.DB
-redisClient *redis.Client
-ctx         = context.Background()
+db             *sql.DB
+redisClient    *redis.Client
+paymentService *services.PaymentService
+refreshTokens  *auth.RefreshStore
+revoker        *auth.Revoker
+userTokens     *auth.UserTokens
+verification   auth.VerificationPolicy
+roles          *auth.RoleStore
+loginGuard     *auth.LoginGuard
+limiter        *middleware.RateLimiter
+twoFactor      *auth.TwoFactor
+auditLog       *audit.Log
+mail           mailer.Mailer
+ctx            = context.Background()
 )
 
 func main() {
 zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+// JSON logs by default so they can be shipped and queried; LOG_FORMAT=console
+// for readable output during development.
+if os.Getenv("LOG_FORMAT") == "console" {
 zlog.Logger = zlog.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+} else {
+zlog.Logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
+}
+// Code without a request logger in its context logs through the global one
+zerolog.DefaultContextLogger = &zlog.Logger
 
 if err := godotenv.Load(); err != nil {
 zlog.Warn().Msg("No .env file found")
 }
 
-var err error
-db, err = sql.Open("postgres", os.Getenv("DATABASE_URL"))
+shutdownTracing, err := tracing.Setup(ctx, "ioc-labs-api")
+if err != nil {
+log.Fatal("Failed to configure tracing:", err)
+}
+defer func() {
+shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+defer cancel()
+if err := shutdownTracing(shutdownCtx); err != nil {
+zlog.Error().Err(err).Msg("Failed to flush traces")
+}
+}()
+
+db, err = tracing.OpenDB("postgres", os.Getenv("DATABASE_URL"))
 if err != nil {
 log.Fatal("Failed to connect to database:", err)
 }
@@ -51,6 +97,35 @@ if err := db.Ping(); err != nil {
 log.Fatal("Database ping failed:", err)
 }
 zlog.Info().Msg("Connected to PostgreSQL")
+metrics.RegisterDB(db, "postgres")
+
+refreshTokens = auth.NewRefreshStore(db)
+
+jwtKeys, err := auth.LoadKeySetFromEnv()
+if err != nil {
+log.Fatal("Failed to load JWT keys:", err)
+}
+auth.SetKeySet(jwtKeys)
+
+hasher, err := auth.PasswordHasherFromEnv()
+if err != nil {
+log.Fatal("Failed to configure password hashing:", err)
+}
+auth.SetPasswordHasher(hasher)
+
+passwordPolicy, err := auth.PasswordPolicyFromEnv()
+if err != nil {
+log.Fatal("Failed to configure password policy:", err)
+}
+auth.SetPasswordPolicy(passwordPolicy)
+
+userTokens = auth.NewUserTokens(db)
+roles = auth.NewRoleStore(db)
+verification = auth.VerificationPolicyFromEnv()
+mail, err = mailer.NewFromEnv()
+if err != nil {
+log.Fatal("Failed to configure mailer:", err)
+}
 
 redisClient = redis.NewClient(&redis.Options{
 Addr:     os.Getenv("REDIS_URL"),
@@ -63,38 +138,117 @@ zlog.Warn().Err(err).Msg("Redis connection failed, continuing without cache")
 redisClient = nil
 } else {
 zlog.Info().Msg("Connected to Redis")
+redisClient.AddHook(tracing.RedisHook{})
+metrics.RegisterRedis(redisClient)
+}
+
+revoker = auth.NewRevoker(db, redisClient)
+loginGuard = auth.NewLoginGuard(redisClient, auth.DefaultLockoutPolicy)
+twoFactor = auth.NewTwoFactor(db)
+auditLog = audit.New(db)
+
+ipResolver, err := clientip.ResolverFromEnv()
+if err != nil {
+log.Fatal("Failed to configure trusted proxies:", err)
 }
+zlog.Info().Int("trusted_proxies", ipResolver.TrustedCount()).Str("header", ipResolver.Header()).Msg("Client IP resolution configured")
 
 r := mux.NewRouter()
-r.Use(corsMiddleware)
+r.Use(metrics.Middleware, middleware.RouteTemplate, tracing.Route, corsMiddleware)
+
+// Prometheus scrape endpoint, behind a bearer token everywhere but
+// development
+metricsToken := os.Getenv("METRICS_TOKEN")
+if metricsToken == "" && os.Getenv("ENVIRONMENT") != "development" {
+log.Fatal("METRICS_TOKEN must be set unless ENVIRONMENT=development")
+}
+r.Handle("/metrics", metricsHandler(metricsToken)).Methods("GET")
+
+// Public keys for services that verify our access tokens
+r.HandleFunc("/.well-known/jwks.json", handleJWKS).Methods("GET", "OPTIONS")
 
 api := r.PathPrefix("/api").Subrouter()
 
 // Initialize payment handler
-paymentHandler := handlers.NewPaymentHandler(db)
+paymentProvider, err := services.NewPaymentProviderFromEnv()
+if err != nil {
+log.Fatal("Failed to configure payment provider:", err)
+}
+paymentService = services.NewPaymentService(paymentProvider)
+paymentHandler := handlers.NewPaymentHandler(db, paymentService, verification)
+
+// Rate limits per route group, shared by all replicas through Redis
+limiter = middleware.NewRateLimiter(redisClient)
 
 // Public routes
 api.HandleFunc("/health", handleHealth).Methods("GET", "OPTIONS")
-api.HandleFunc("/products", handleListProducts).Methods("GET", "OPTIONS")
-api.HandleFunc("/products/{id:[0-9]+}", handleGetProduct).Methods("GET", "OPTIONS")
-api.HandleFunc("/products/search", handleSearchProducts).Methods("GET", "OPTIONS")
-api.HandleFunc("/auth/register", handleRegister).Methods("POST", "OPTIONS")
-api.HandleFunc("/auth/login", handleLogin).Methods("POST", "OPTIONS")
+
+catalog := api.PathPrefix("/products").Subrouter()
+// Integrations sending X-API-Key share one budget across their IPs; the
+// per-IP policy still applies, so rotating keys does not lift the IP cap
+catalog.Use(
+limiter.Limit(middleware.RateLimitPolicy{Name: "catalog", Limit: 300, Window: time.Minute, Key: middleware.KeyByIP}),
+limiter.Limit(middleware.RateLimitPolicy{Name: "catalog-apikey", Limit: 600, Window: time.Minute, Key: middleware.KeyByAPIKey}),
+)
+catalog.HandleFunc("", handleListProducts).Methods("GET", "OPTIONS")
+catalog.HandleFunc("/{id:[0-9]+}", handleGetProduct).Methods("GET", "OPTIONS")
+catalog.HandleFunc("/search", handleSearchProducts).Methods("GET", "OPTIONS")
+
+// Password and 2FA guessing is also throttled per account by loginGuard
+login := api.PathPrefix("/auth").Subrouter()
+login.Use(limiter.Limit(middleware.RateLimitPolicy{Name: "login", Limit: 10, Window: time.Minute, Key: middleware.KeyByIP}))
+login.HandleFunc("/login", handleLogin).Methods("POST", "OPTIONS")
+login.HandleFunc("/2fa/verify", handleVerifyTwoFactor).Methods("POST", "OPTIONS")
+
+public := api.PathPrefix("/auth").Subrouter()
+public.Use(limiter.Limit(middleware.RateLimitPolicy{Name: "auth", Limit: 30, Window: time.Minute, Key: middleware.KeyByIP}))
+public.HandleFunc("/register", handleRegister).Methods("POST", "OPTIONS")
+public.HandleFunc("/refresh", handleRefresh).Methods("POST", "OPTIONS")
+public.HandleFunc("/logout", handleLogout).Methods("POST", "OPTIONS")
+public.HandleFunc("/password/forgot", handleForgotPassword).Methods("POST", "OPTIONS")
+public.HandleFunc("/password/reset", handleResetPassword).Methods("POST", "OPTIONS")
+public.HandleFunc("/verify-email", handleVerifyEmail).Methods("POST", "OPTIONS")
 
 // Stripe webhook (public - no auth)
 api.HandleFunc("/webhook/stripe", paymentHandler.HandleStripeWebhook).Methods("POST")
 
 // Protected routes
 protected := api.PathPrefix("").Subrouter()
-protected.Use(authMiddleware)
+protected.Use(authMiddleware, limiter.Limit(middleware.RateLimitPolicy{Name: "user", Limit: 120, Window: time.Minute, Key: middleware.KeyByUser}))
+protected.HandleFunc("/auth/logout-all", handleLogoutAll).Methods("POST", "OPTIONS")
+protected.HandleFunc("/auth/verify-email/resend", handleResendVerification).Methods("POST", "OPTIONS")
+protected.HandleFunc("/auth/2fa/enroll", handleEnrollTwoFactor).Methods("POST", "OPTIONS")
+protected.HandleFunc("/auth/2fa/confirm", handleConfirmTwoFactor).Methods("POST", "OPTIONS")
+protected.HandleFunc("/auth/2fa/disable", handleDisableTwoFactor).Methods("POST", "OPTIONS")
 protected.HandleFunc("/cart", handleGetCart).Methods("GET", "OPTIONS")
 protected.HandleFunc("/cart", handleAddToCart).Methods("POST", "OPTIONS")
 protected.HandleFunc("/cart/clear", handleClearCart).Methods("DELETE", "OPTIONS")
 protected.HandleFunc("/orders", handleCreateOrder).Methods("POST", "OPTIONS")
 protected.HandleFunc("/orders", handleListOrders).Methods("GET", "OPTIONS")
 protected.HandleFunc("/orders/{id:[0-9]+}", handleGetOrder).Methods("GET", "OPTIONS")
+protected.HandleFunc("/orders/{id:[0-9]+}/cancel", handleCancelOrder).Methods("POST", "OPTIONS")
 protected.HandleFunc("/payment/create-intent", paymentHandler.CreatePaymentIntent).Methods("POST", "OPTIONS")
 
+// Local payment simulation, only with PAYMENT_PROVIDER=fake
+if _, ok := paymentProvider.(*services.FakeProvider); ok {
+zlog.Warn().Msg("Using fake payment provider")
+protected.HandleFunc("/payment/fake/{intent_id}/settle", paymentHandler.SimulatePayment).Methods("POST", "OPTIONS")
+}
+
+// Admin routes: the subrouter needs admin:access, each route its own permission
+admin := api.PathPrefix("/admin").Subrouter()
+admin.Use(authMiddleware, middleware.RequirePermission(auth.PermAdminAccess))
+admin.Handle("/orders/{id:[0-9]+}/refunds", requirePermission(auth.PermOrdersRefund, paymentHandler.CreateRefund)).Methods("POST", "OPTIONS")
+admin.Handle("/payment-events", requirePermission(auth.PermPaymentsRead, paymentHandler.ListPaymentEvents)).Methods("GET", "OPTIONS")
+admin.Handle("/payment-events/{event_id}/replay", requirePermission(auth.PermPaymentsManage, paymentHandler.ReplayPaymentEvent)).Methods("POST", "OPTIONS")
+admin.Handle("/payment-alerts", requirePermission(auth.PermPaymentsRead, paymentHandler.ListPaymentAlerts)).Methods("GET", "OPTIONS")
+admin.Handle("/payment-alerts/{id:[0-9]+}/resolve", requirePermission(auth.PermPaymentsManage, paymentHandler.ResolvePaymentAlert)).Methods("POST", "OPTIONS")
+admin.Handle("/users/{id:[0-9]+}/revoke-sessions", requirePermission(auth.PermUsersManage, handleAdminRevokeSessions)).Methods("POST", "OPTIONS")
+admin.Handle("/users/{id:[0-9]+}/unlock", requirePermission(auth.PermUsersManage, handleAdminUnlockAccount)).Methods("POST", "OPTIONS")
+admin.Handle("/roles", requirePermission(auth.PermUsersManage, handleListRoles)).Methods("GET", "OPTIONS")
+admin.Handle("/users/{id:[0-9]+}/roles", requirePermission(auth.PermUsersManage, handleGrantRole)).Methods("POST", "OPTIONS")
+admin.Handle("/users/{id:[0-9]+}/roles/{role}", requirePermission(auth.PermUsersManage, handleRevokeRole)).Methods("DELETE", "OPTIONS")
+
 // Static files
 r.PathPrefix("/").Handler(http.FileServer(http.Dir("./frontend")))
 
@@ -104,11 +258,29 @@ port = "8080"
 }
 
 zlog.Info().Msgf("Server starting on port %s", port)
-if err := http.ListenAndServe(":"+port, r); err != nil {
+// Client IP resolution, tracing and request logging wrap the router so
+// unmatched requests are covered too. Tracing comes before logging so log
+// lines carry the trace ID.
+handler := ipResolver.Middleware(tracing.Middleware(middleware.Logging(zlog.Logger)(r)))
+if err := http.ListenAndServe(":"+port, handler); err != nil {
 log.Fatal(err)
 }
 }
 
+// handleJWKS serves the public signing keys as a standard JWK set, without
+// the usual response envelope so off-the-shelf JWT libraries can read it.
+func handleJWKS(w http.ResponseWriter, r *http.Request) {
+keys, err := auth.CurrentKeySet()
+if err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Signing keys unavailable")
+return
+}
+
+w.Header().Set("Content-Type", "application/json")
+w.Header().Set("Cache-Control", "public, max-age=300")
+json.NewEncoder(w).Encode(keys.JWKS())
+}
+
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 jsonResponse(w, http.StatusOK, map[string]string{"status": "healthy"})
 }
@@ -125,10 +297,10 @@ perPage = 20
 offset := (page - 1) * perPage
 
 var total int
-db.QueryRow("SELECT COUNT(*) FROM products").Scan(&total)
+db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM products").Scan(&total)
 
-rows, _ := db.Query(
-"SELECT id, name, description, price, category, stock, image_url FROM products ORDER BY created_at DESC LIMIT $1 OFFSET $2",
+rows, _ := db.QueryContext(r.Context(),
+"SELECT id, name, description, price_cents, currency, category, stock, image_url FROM products ORDER BY created_at DESC LIMIT $1 OFFSET $2",
 perPage, offset,
 )
 defer rows.Close()
@@ -136,12 +308,12 @@ defer rows.Close()
 products := []map[string]interface{}{}
 for rows.Next() {
 var id, stock int
-var name, description, category, imageURL string
-var price float64
-rows.Scan(&id, &name, &description, &price, &category, &stock, &imageURL)
+var name, description, category, imageURL, currency string
+var priceCents int64
+rows.Scan(&id, &name, &description, &priceCents, &currency, &category, &stock, &imageURL)
 products = append(products, map[string]interface{}{
 "id": id, "name": name, "description": description,
-"price": price, "category": category, "stock": stock, "image_url": imageURL,
+"price": money.New(priceCents, currency), "category": category, "stock": stock, "image_url": imageURL,
 })
 }
 
@@ -159,13 +331,13 @@ func handleGetProduct(w http.ResponseWriter, r *http.Request) {
 vars := mux.Vars(r)
 id := vars["id"]
 
-var name, description, category, imageURL string
-var price float64
+var name, description, category, imageURL, currency string
+var priceCents int64
 var stock int
 
-err := db.QueryRow(
-"SELECT name, description, price, category, stock, image_url FROM products WHERE id = $1", id,
-).Scan(&name, &description, &price, &category, &stock, &imageURL)
+err := db.QueryRowContext(r.Context(),
+"SELECT name, description, price_cents, currency, category, stock, image_url FROM products WHERE id = $1", id,
+).Scan(&name, &description, &priceCents, &currency, &category, &stock, &imageURL)
 
 if err == sql.ErrNoRows {
 jsonError(w, http.StatusNotFound, "NOT_FOUND", "Product not found")
@@ -174,7 +346,7 @@ return
 
 jsonResponse(w, http.StatusOK, map[string]interface{}{
 "id": id, "name": name, "description": description,
-"price": price, "category": category, "stock": stock, "image_url": imageURL,
+"price": money.New(priceCents, currency), "category": category, "stock": stock, "image_url": imageURL,
 })
 }
 
@@ -182,8 +354,8 @@ func handleSearchProducts(w http.ResponseWriter, r *http.Request) {
 query := r.URL.Query().Get("q")
 searchTerm := "%" + strings.ToLower(query) + "%"
 
-rows, _ := db.Query(
-"SELECT id, name, description, price, category, stock, image_url FROM products WHERE LOWER(name) LIKE $1 OR LOWER(description) LIKE $1 LIMIT 50",
+rows, _ := db.QueryContext(r.Context(),
+"SELECT id, name, description, price_cents, currency, category, stock, image_url FROM products WHERE LOWER(name) LIKE $1 OR LOWER(description) LIKE $1 LIMIT 50",
 searchTerm,
 )
 defer rows.Close()
@@ -191,12 +363,12 @@ defer rows.Close()
 products := []map[string]interface{}{}
 for rows.Next() {
 var id, stock int
-var name, description, category, imageURL string
-var price float64
-rows.Scan(&id, &name, &description, &price, &category, &stock, &imageURL)
+var name, description, category, imageURL, currency string
+var priceCents int64
+rows.Scan(&id, &name, &description, &priceCents, &currency, &category, &stock, &imageURL)
 products = append(products, map[string]interface{}{
 "id": id, "name": name, "description": description,
-"price": price, "category": category, "stock": stock, "image_url": imageURL,
+"price": money.New(priceCents, currency), "category": category, "stock": stock, "image_url": imageURL,
 })
 }
 
@@ -228,20 +400,24 @@ jsonError(w, http.StatusBadRequest, "MISSING_FIELDS", "Email and password are re
 return
 }
 
-if err := auth.ValidatePasswordStrength(req.Password); err != nil {
-jsonError(w, http.StatusBadRequest, "WEAK_PASSWORD", err.Error())
+if errs := auth.ValidatePasswordStrength(req.Password, auth.PasswordContext{
+Email:     req.Email,
+FirstName: req.FirstName,
+LastName:  req.LastName,
+}); errs != nil {
+weakPasswordError(w, errs)
 return
 }
 
 hashedPassword, err := auth.HashPassword(req.Password)
 if err != nil {
-zlog.Error().Err(err).Msg("Failed to hash password")
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to hash password")
 jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to process registration")
 return
 }
 
 var userID int
-err = db.QueryRow(
+err = db.QueryRowContext(r.Context(),
 "INSERT INTO users (email, password_hash, first_name, last_name) VALUES ($1, $2, $3, $4) RETURNING id",
 req.Email, hashedPassword, req.FirstName, req.LastName,
 ).Scan(&userID)
@@ -250,28 +426,31 @@ if err != nil {
 if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") {
 jsonError(w, http.StatusConflict, "EMAIL_EXISTS", "Email already registered")
 } else {
-zlog.Error().Err(err).Msg("Database error during registration")
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Database error during registration")
 jsonError(w, http.StatusInternalServerError, "REGISTRATION_FAILED", "Failed to create user")
 }
 return
 }
 
-db.Exec("INSERT INTO carts (user_id) VALUES ($1)", userID)
+if _, err := db.ExecContext(r.Context(), "INSERT INTO carts (user_id) VALUES ($1)", userID); err == nil {
+metrics.CartCreated()
+}
+
+go sendEmailVerification(userID, req.Email)
 
-// Generate JWT token
-token, err := auth.GenerateToken(userID)
+// Generate access and refresh tokens
+tokens, err := issueTokens(r.Context(), userID)
 if err != nil {
-zlog.Error().Err(err).Msg("Failed to generate JWT token")
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to generate authentication tokens")
 jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to generate authentication token")
 return
 }
 
-zlog.Info().Int("user_id", userID).Str("email", req.Email).Msg("User registered successfully")
+zlog.Ctx(r.Context()).Info().Int("user_id", userID).Str("email", req.Email).Msg("User registered successfully")
 
-jsonResponse(w, http.StatusCreated, map[string]interface{}{
-"user_id": userID,
-"token":   token,
-})
+tokens["user_id"] = userID
+tokens["email_verified"] = false
+jsonResponse(w, http.StatusCreated, tokens)
 }
 
 func handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -292,272 +471,1450 @@ jsonError(w, http.StatusBadRequest, "MISSING_FIELDS", "Email and password are re
 return
 }
 
+ip := clientip.FromRequest(r)
+if err := loginGuard.Check(r.Context(), req.Email, ip); err != nil {
+var lockout *auth.LockoutError
+errors.As(err, &lockout)
+loginLockedError(w, lockout)
+return
+}
+
 var userID int
 var passwordHash string
-err := db.QueryRow(
-"SELECT id, password_hash FROM users WHERE email = $1",
+var emailVerified bool
+err := db.QueryRowContext(r.Context(),
+"SELECT id, password_hash, email_verified_at IS NOT NULL FROM users WHERE email = $1",
 req.Email,
-).Scan(&userID, &passwordHash)
+).Scan(&userID, &passwordHash, &emailVerified)
 
 if err == sql.ErrNoRows {
-jsonError(w, http.StatusUnauthorized, "INVALID_CREDENTIALS", "Invalid email or password")
+failedLogin(w, r, 0, req.Email, ip, http.StatusUnauthorized, "INVALID_CREDENTIALS", "Invalid email or password")
 return
 }
 
 if err != nil {
-zlog.Error().Err(err).Msg("Database error during login")
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Database error during login")
 jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Login failed")
 return
 }
 
-if err := auth.CheckPasswordHash(req.Password, passwordHash); err != nil {
-jsonError(w, http.StatusUnauthorized, "INVALID_CREDENTIALS", "Invalid email or password")
+needsRehash, err := auth.CheckPasswordHash(req.Password, passwordHash)
+if err != nil {
+failedLogin(w, r, userID, req.Email, ip, http.StatusUnauthorized, "INVALID_CREDENTIALS", "Invalid email or password")
 return
 }
+if needsRehash {
+upgradePasswordHash(r.Context(), userID, req.Password, passwordHash)
+}
 
-// Generate JWT token
-token, err := auth.GenerateToken(userID)
+// With two-factor authentication on, the password only earns a challenge
+// token for the second step. Earlier failures are kept until that step
+// succeeds; only this attempt is given back.
+hasTwoFactor, err := twoFactor.Enabled(r.Context(), userID)
 if err != nil {
-zlog.Error().Err(err).Msg("Failed to generate JWT token")
-jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to generate authentication token")
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to check two-factor status")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Login failed")
+return
+}
+if hasTwoFactor {
+loginGuard.Release(r.Context(), req.Email, ip)
+challenge, err := userTokens.Issue(r.Context(), userID, auth.PurposeTwoFactorChallenge, auth.TwoFactorChallengeTTL)
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to issue two-factor challenge")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Login failed")
 return
 }
-
-zlog.Info().Int("user_id", userID).Str("email", req.Email).Msg("User logged in successfully")
-
 jsonResponse(w, http.StatusOK, map[string]interface{}{
-"user_id": userID,
-"token":   token,
+"two_factor_required": true,
+"challenge_token":     challenge,
+"expires_in":          int(auth.TwoFactorChallengeTTL.Seconds()),
 })
+return
 }
 
-func handleGetCart(w http.ResponseWriter, r *http.Request) {
-userID := r.Context().Value("user_id").(int64)
+loginGuard.Succeed(r.Context(), req.Email, ip)
 
-var cartID int
-db.QueryRow("SELECT id FROM carts WHERE user_id = $1", userID).Scan(&cartID)
+// Generate access and refresh tokens
+tokens, err := issueTokens(r.Context(), userID)
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to generate authentication tokens")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to generate authentication token")
+return
+}
 
-rows, _ := db.Query(`
-SELECT ci.id, ci.product_id, ci.quantity, p.name, p.price, p.image_url
-FROM cart_items ci JOIN products p ON ci.product_id = p.id
-WHERE ci.cart_id = $1
-`, cartID)
-defer rows.Close()
+zlog.Ctx(r.Context()).Info().Int("user_id", userID).Str("email", req.Email).Msg("User logged in successfully")
 
-items := []map[string]interface{}{}
-var total float64
+tokens["user_id"] = userID
+tokens["email_verified"] = emailVerified
+jsonResponse(w, http.StatusOK, tokens)
+}
 
-for rows.Next() {
-var itemID, productID, quantity int
-var name, imageURL string
-var price float64
-rows.Scan(&itemID, &productID, &quantity, &name, &price, &imageURL)
-subtotal := price * float64(quantity)
-total += subtotal
-items = append(items, map[string]interface{}{
-"id": itemID, "product_id": productID, "quantity": quantity,
-"name": name, "price": price, "image_url": imageURL, "subtotal": subtotal,
-})
+// upgradePasswordHash re-hashes a password whose stored hash uses an
+// outdated algorithm or cost. The update is skipped if the hash changed
+// since it was read, and failures only get logged: the login still works.
+func upgradePasswordHash(ctx context.Context, userID int, password, oldHash string) {
+newHash, err := auth.HashPassword(password)
+if err != nil {
+zlog.Ctx(ctx).Error().Err(err).Int("user_id", userID).Msg("Failed to re-hash password")
+return
+}
+if _, err := db.ExecContext(ctx,
+"UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2 AND password_hash = $3",
+newHash, userID, oldHash,
+); err != nil {
+zlog.Ctx(ctx).Error().Err(err).Int("user_id", userID).Msg("Failed to save re-hashed password")
+return
+}
+zlog.Ctx(ctx).Info().Int("user_id", userID).Msg("Upgraded password hash")
 }
 
-jsonResponse(w, http.StatusOK, map[string]interface{}{
-"items": items,
-"total": total,
+// failedLogin counts a failed login against the account and the client IP.
+// Once the failures trigger a backoff the client is told how long to wait;
+// the moment the account locks, the lockout is written to the audit log.
+// Otherwise it answers status with code and message. userID is zero when no
+// account has the email.
+func failedLogin(w http.ResponseWriter, r *http.Request, userID int, email, ip string, status int, code, message string) {
+outcome := loginGuard.Fail(r.Context(), email, ip)
+
+if outcome.Locked {
+zlog.Ctx(r.Context()).Warn().Int("user_id", userID).Str("email", email).Str("ip", ip).Int("failures", outcome.Failures).Msg("Account locked after failed logins")
+recordAudit(r, audit.Event{
+Type:   audit.EventAccountLocked,
+UserID: userID,
+Actor:  audit.ActorSystem,
+IP:     ip,
+Details: map[string]interface{}{
+"email":              email,
+"failures":           outcome.Failures,
+"locked_for_seconds": int(outcome.RetryAfter.Seconds()),
+},
 })
 }
 
-func handleAddToCart(w http.ResponseWriter, r *http.Request) {
-userID := r.Context().Value("user_id").(int64)
+if outcome.RetryAfter > 0 {
+loginLockedError(w, &auth.LockoutError{Err: auth.ErrAccountLocked, RetryAfter: outcome.RetryAfter})
+return
+}
+jsonError(w, status, code, message)
+}
 
-var req struct {
-ProductID int `json:"product_id"`
-Quantity  int `json:"quantity"`
+// loginLockedError answers a login that is blocked by backoff or lockout.
+// The wait is sent both as a Retry-After header and as retry_after (in
+// seconds) in the error body.
+func loginLockedError(w http.ResponseWriter, lockout *auth.LockoutError) {
+retryAfter := int(lockout.RetryAfter.Seconds()) + 1
+code, message := "ACCOUNT_LOCKED", "Too many failed login attempts for this account, please try again later"
+if errors.Is(lockout, auth.ErrTooManyAttempts) {
+code, message = "RATE_LIMITED", "Too many failed login attempts, please try again later"
 }
-json.NewDecoder(r.Body).Decode(&req)
 
-var cartID int
-db.QueryRow("SELECT id FROM carts WHERE user_id = $1", userID).Scan(&cartID)
+w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+w.Header().Set("Content-Type", "application/json")
+w.WriteHeader(http.StatusTooManyRequests)
+json.NewEncoder(w).Encode(map[string]interface{}{
+"success": false,
+"error": map[string]interface{}{
+"code":        code,
+"message":     message,
+"retry_after": retryAfter,
+},
+})
+}
 
-var stock int
-db.QueryRow("SELECT stock FROM products WHERE id = $1", req.ProductID).Scan(&stock)
+// handleVerifyTwoFactor is the second login step: it exchanges the
+// challenge token from handleLogin and a TOTP or recovery code for real
+// tokens. A wrong code leaves the challenge usable and counts as a failed
+// login, so code guessing is throttled like password guessing.
+func handleVerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+var req struct {
+ChallengeToken string `json:"challenge_token"`
+Code           string `json:"code"`
+}
 
-if stock < req.Quantity {
-jsonError(w, http.StatusBadRequest, "INSUFFICIENT_STOCK", "Not enough stock")
+if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+jsonError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
+return
+}
+if req.ChallengeToken == "" || req.Code == "" {
+jsonError(w, http.StatusBadRequest, "MISSING_FIELDS", "challenge_token and code are required")
 return
 }
 
-db.Exec(`
-INSERT INTO cart_items (cart_id, product_id, quantity)
-VALUES ($1, $2, $3)
-ON CONFLICT (cart_id, product_id) DO UPDATE SET quantity = cart_items.quantity + $3
-`, cartID, req.ProductID, req.Quantity)
+tx, err := db.BeginTx(r.Context(), nil)
+if err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Login failed")
+return
+}
+defer tx.Rollback()
 
-jsonResponse(w, http.StatusOK, map[string]string{"message": "Added to cart"})
+userID, err := userTokens.Consume(r.Context(), tx, req.ChallengeToken, auth.PurposeTwoFactorChallenge)
+if errors.Is(err, auth.ErrUserTokenInvalid) {
+jsonError(w, http.StatusUnauthorized, "INVALID_CHALLENGE", "Login has expired, please sign in again")
+return
+}
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to redeem two-factor challenge")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Login failed")
+return
 }
 
-func handleClearCart(w http.ResponseWriter, r *http.Request) {
-userID := r.Context().Value("user_id").(int64)
+var email string
+var emailVerified bool
+if err := tx.QueryRowContext(r.Context(),
+"SELECT email, email_verified_at IS NOT NULL FROM users WHERE id = $1", userID,
+).Scan(&email, &emailVerified); err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Login failed")
+return
+}
 
-var cartID int
-db.QueryRow("SELECT id FROM carts WHERE user_id = $1", userID).Scan(&cartID)
-db.Exec("DELETE FROM cart_items WHERE cart_id = $1", cartID)
+ip := clientip.FromRequest(r)
+if err := loginGuard.Check(r.Context(), email, ip); err != nil {
+var lockout *auth.LockoutError
+errors.As(err, &lockout)
+loginLockedError(w, lockout)
+return
+}
 
-jsonResponse(w, http.StatusOK, map[string]string{"message": "Cart cleared"})
+err = twoFactor.Verify(r.Context(), tx, userID, req.Code)
+if errors.Is(err, auth.ErrInvalidTwoFactorCode) || errors.Is(err, auth.ErrTwoFactorNotEnabled) {
+tx.Rollback()
+failedLogin(w, r, userID, email, ip, http.StatusUnauthorized, "INVALID_CODE", "Invalid two-factor code")
+return
+}
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to verify two-factor code")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Login failed")
+return
 }
 
-func handleCreateOrder(w http.ResponseWriter, r *http.Request) {
-userID := r.Context().Value("user_id").(int64)
+if err := tx.Commit(); err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Login failed")
+return
+}
+loginGuard.Succeed(r.Context(), email, ip)
 
-var cartID int
-db.QueryRow("SELECT id FROM carts WHERE user_id = $1", userID).Scan(&cartID)
+tokens, err := issueTokens(r.Context(), userID)
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to generate authentication tokens")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to generate authentication token")
+return
+}
 
-rows, _ := db.Query(`
-SELECT ci.product_id, ci.quantity, p.name, p.price
-FROM cart_items ci JOIN products p ON ci.product_id = p.id
-WHERE ci.cart_id = $1
-`, cartID)
-defer rows.Close()
+zlog.Ctx(r.Context()).Info().Int("user_id", userID).Str("email", email).Msg("User logged in with two-factor authentication")
 
-type CartItem struct {
-ProductID int
-Quantity  int
-Name      string
-Price     float64
+tokens["user_id"] = userID
+tokens["email_verified"] = emailVerified
+jsonResponse(w, http.StatusOK, tokens)
 }
 
-var items []CartItem
-var total float64
+// handleEnrollTwoFactor starts two-factor enrollment and returns the secret
+// as an otpauth:// URI for the user's authenticator app.
+func handleEnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+userID := r.Context().Value("user_id").(int64)
 
-for rows.Next() {
-var item CartItem
-rows.Scan(&item.ProductID, &item.Quantity, &item.Name, &item.Price)
-total += item.Price * float64(item.Quantity)
-items = append(items, item)
+secret, err := twoFactor.BeginEnrollment(r.Context(), int(userID))
+if errors.Is(err, auth.ErrTwoFactorEnabled) {
+jsonError(w, http.StatusConflict, "TWO_FACTOR_ENABLED", "Two-factor authentication is already enabled")
+return
+}
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to start two-factor enrollment")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to start two-factor enrollment")
+return
 }
 
-if len(items) == 0 {
-jsonError(w, http.StatusBadRequest, "EMPTY_CART", "Cart is empty")
+var email string
+if err := db.QueryRowContext(r.Context(), "SELECT email FROM users WHERE id = $1", userID).Scan(&email); err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to start two-factor enrollment")
 return
 }
 
-var orderID int
-err := db.QueryRow(
-"INSERT INTO orders (user_id, total, status) VALUES ($1, $2, $3) RETURNING id",
-userID, total, "pending",
-).Scan(&orderID)
+jsonResponse(w, http.StatusOK, map[string]string{
+"secret":      secret,
+"otpauth_uri": auth.TOTPURI("IOC Labs", email, secret),
+})
+}
 
-if err != nil {
-jsonError(w, http.StatusInternalServerError, "ORDER_FAILED", "Failed to create order")
+// handleConfirmTwoFactor turns two-factor authentication on once the user
+// sends a code from their authenticator, and returns the recovery codes.
+func handleConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+userID := r.Context().Value("user_id").(int64)
+
+var req struct {
+Code string `json:"code"`
+}
+if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+jsonError(w, http.StatusBadRequest, "INVALID_REQUEST", "code is required")
 return
 }
 
-for _, item := range items {
-db.Exec(
-"INSERT INTO order_items (order_id, product_id, product_name, quantity, price, subtotal) VALUES ($1, $2, $3, $4, $5, $6)",
-orderID, item.ProductID, item.Name, item.Quantity, item.Price, float64(item.Quantity)*item.Price,
-)
-db.Exec("UPDATE products SET stock = stock - $1 WHERE id = $2", item.Quantity, item.ProductID)
+codes, err := twoFactor.ConfirmEnrollment(r.Context(), int(userID), req.Code)
+switch {
+case errors.Is(err, auth.ErrTwoFactorEnabled):
+jsonError(w, http.StatusConflict, "TWO_FACTOR_ENABLED", "Two-factor authentication is already enabled")
+return
+case errors.Is(err, auth.ErrTwoFactorNotEnrolled):
+jsonError(w, http.StatusBadRequest, "TWO_FACTOR_NOT_ENROLLED", "Start enrollment first")
+return
+case errors.Is(err, auth.ErrInvalidTwoFactorCode):
+jsonError(w, http.StatusBadRequest, "INVALID_CODE", "Invalid two-factor code")
+return
+case err != nil:
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to confirm two-factor enrollment")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to enable two-factor authentication")
+return
 }
 
-db.Exec("DELETE FROM cart_items WHERE cart_id = $1", cartID)
+recordAudit(r, audit.Event{Type: audit.EventTwoFactorEnabled, UserID: int(userID), Actor: audit.UserActor(userID)})
 
-jsonResponse(w, http.StatusCreated, map[string]interface{}{
-"id":     orderID,
-"total":  total,
-"status": "pending",
+jsonResponse(w, http.StatusOK, map[string]interface{}{
+"recovery_codes": codes,
+"message":        "Two-factor authentication enabled. Store these recovery codes somewhere safe; they will not be shown again.",
 })
 }
 
-func handleListOrders(w http.ResponseWriter, r *http.Request) {
+// handleDisableTwoFactor turns two-factor authentication off. It needs a
+// current TOTP or recovery code, not just a valid access token.
+func handleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
 userID := r.Context().Value("user_id").(int64)
 
-rows, _ := db.Query(
-"SELECT id, total, status, payment_status, created_at FROM orders WHERE user_id = $1 ORDER BY created_at DESC",
-userID,
-)
-defer rows.Close()
-
-orders := []map[string]interface{}{}
-for rows.Next() {
-var id int
-var total float64
-var status, paymentStatus string
-var createdAt time.Time
-rows.Scan(&id, &total, &status, &paymentStatus, &createdAt)
-orders = append(orders, map[string]interface{}{
-"id":             id,
-"total":          total,
-"status":         status,
-"payment_status": paymentStatus,
-"created_at":     createdAt,
-})
+var req struct {
+Code string `json:"code"`
 }
-
-jsonResponse(w, http.StatusOK, orders)
+if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+jsonError(w, http.StatusBadRequest, "INVALID_REQUEST", "code is required")
+return
 }
 
-func handleGetOrder(w http.ResponseWriter, r *http.Request) {
-userID := r.Context().Value("user_id").(int64)
-vars := mux.Vars(r)
-orderID := vars["id"]
+tx, err := db.BeginTx(r.Context(), nil)
+if err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to disable two-factor authentication")
+return
+}
+defer tx.Rollback()
 
-var total float64
-var status, paymentStatus string
-var createdAt time.Time
-var ownerID int64
+var email string
+if err := tx.QueryRowContext(r.Context(), "SELECT email FROM users WHERE id = $1", userID).Scan(&email); err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to disable two-factor authentication")
+return
+}
 
-err := db.QueryRow(
-"SELECT user_id, total, status, payment_status, created_at FROM orders WHERE id = $1",
-orderID,
-).Scan(&ownerID, &total, &status, &paymentStatus, &createdAt)
+// Wrong codes count against the account like failed logins, so a stolen
+// session cannot guess its way past the second factor
+ip := clientip.FromRequest(r)
+if err := loginGuard.Check(r.Context(), email, ip); err != nil {
+var lockout *auth.LockoutError
+errors.As(err, &lockout)
+loginLockedError(w, lockout)
+return
+}
 
-if err == sql.ErrNoRows {
-jsonError(w, http.StatusNotFound, "NOT_FOUND", "Order not found")
+err = twoFactor.Verify(r.Context(), tx, int(userID), req.Code)
+switch {
+case errors.Is(err, auth.ErrTwoFactorNotEnabled):
+loginGuard.Release(r.Context(), email, ip)
+jsonError(w, http.StatusBadRequest, "TWO_FACTOR_NOT_ENABLED", "Two-factor authentication is not enabled")
+return
+case errors.Is(err, auth.ErrInvalidTwoFactorCode):
+tx.Rollback()
+failedLogin(w, r, int(userID), email, ip, http.StatusBadRequest, "INVALID_CODE", "Invalid two-factor code")
+return
+case err != nil:
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to verify two-factor code")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to disable two-factor authentication")
 return
 }
 
-if ownerID != userID {
-jsonError(w, http.StatusForbidden, "FORBIDDEN", "Not your order")
+if err := twoFactor.Disable(r.Context(), tx, int(userID)); err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to disable two-factor authentication")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to disable two-factor authentication")
+return
+}
+if err := tx.Commit(); err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to disable two-factor authentication")
 return
 }
 
-rows, _ := db.Query(
-"SELECT product_name, quantity, price, subtotal FROM order_items WHERE order_id = $1",
-orderID,
-)
-defer rows.Close()
+loginGuard.Succeed(r.Context(), email, ip)
 
-items := []map[string]interface{}{}
-for rows.Next() {
-var name string
-var quantity int
-var price, subtotal float64
-rows.Scan(&name, &quantity, &price, &subtotal)
-items = append(items, map[string]interface{}{
-"name": name, "quantity": quantity, "price": price, "subtotal": subtotal,
+recordAudit(r, audit.Event{Type: audit.EventTwoFactorDisabled, UserID: int(userID), Actor: audit.UserActor(userID)})
+
+jsonResponse(w, http.StatusOK, map[string]string{
+"message": "Two-factor authentication disabled",
 })
 }
 
-jsonResponse(w, http.StatusOK, map[string]interface{}{
-"id":             orderID,
-"total":          total,
-"status":         status,
-"payment_status": paymentStatus,
-"items":          items,
-"created_at":     createdAt,
-})
+// recordAudit writes an audit event for a request, filling in the client IP.
+// Failures are logged rather than failing the request.
+func recordAudit(r *http.Request, event audit.Event) {
+if event.IP == "" {
+event.IP = clientip.FromRequest(r)
+}
+if err := auditLog.Record(r.Context(), event); err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Str("event", event.Type).Msg("Failed to record audit event")
+}
 }
 
-func authMiddleware(next http.Handler) http.Handler {
-return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-// Get Authorization header
-authHeader := r.Header.Get("Authorization")
-if authHeader == "" {
-jsonError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Missing authorization header")
-return
+// issueTokens starts a new session: a short-lived access token plus a
+// refresh token that begins a new rotation family.
+func issueTokens(ctx context.Context, userID int) (map[string]interface{}, error) {
+refreshToken, refreshExpiresAt, err := refreshTokens.Issue(ctx, userID)
+if err != nil {
+return nil, err
+}
+return tokenPair(ctx, userID, refreshToken, refreshExpiresAt)
 }
 
-// Extract token from "Bearer <token>" format
+func tokenPair(ctx context.Context, userID int, refreshToken string, refreshExpiresAt time.Time) (map[string]interface{}, error) {
+version, err := revoker.TokenVersion(ctx, userID)
+if err != nil {
+return nil, err
+}
+userRoles, permissions, err := roles.ForUser(ctx, userID)
+if err != nil {
+return nil, err
+}
+token, err := auth.IssueToken(auth.Subject{
+UserID:       userID,
+TokenVersion: version,
+Roles:        userRoles,
+Permissions:  permissions,
+})
+if err != nil {
+return nil, err
+}
+return map[string]interface{}{
+"token":              token,
+"token_type":         "Bearer",
+"expires_in":         int(auth.AccessTokenTTL.Seconds()),
+"refresh_token":      refreshToken,
+"refresh_expires_at": refreshExpiresAt,
+}, nil
+}
+
+// handleRefresh exchanges a refresh token for a new access token and a new
+// refresh token. The old refresh token cannot be used again; presenting it
+// a second time revokes the whole session.
+func handleRefresh(w http.ResponseWriter, r *http.Request) {
+var req struct {
+RefreshToken string `json:"refresh_token"`
+}
+
+if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+jsonError(w, http.StatusBadRequest, "INVALID_REQUEST", "refresh_token is required")
+return
+}
+
+userID, refreshToken, refreshExpiresAt, err := refreshTokens.Rotate(r.Context(), req.RefreshToken)
+switch {
+case errors.Is(err, auth.ErrRefreshTokenReused):
+zlog.Ctx(r.Context()).Warn().Int("user_id", userID).Msg("Refresh token reused, session revoked")
+jsonError(w, http.StatusUnauthorized, "REFRESH_TOKEN_REUSED", "Session has been revoked, please login again")
+return
+case errors.Is(err, auth.ErrRefreshTokenInvalid):
+jsonError(w, http.StatusUnauthorized, "INVALID_REFRESH_TOKEN", "Refresh token is invalid or expired")
+return
+case err != nil:
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to rotate refresh token")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to refresh session")
+return
+}
+
+tokens, err := tokenPair(r.Context(), userID, refreshToken, refreshExpiresAt)
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to generate JWT token")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to generate authentication token")
+return
+}
+
+tokens["user_id"] = userID
+jsonResponse(w, http.StatusOK, tokens)
+}
+
+// handleLogout revokes the session a refresh token belongs to. If the
+// request also carries its access token, that token is denylisted too.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+var req struct {
+RefreshToken string `json:"refresh_token"`
+}
+
+if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+jsonError(w, http.StatusBadRequest, "INVALID_REQUEST", "refresh_token is required")
+return
+}
+
+if err := refreshTokens.Revoke(r.Context(), req.RefreshToken); err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to revoke refresh token")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to logout")
+return
+}
+
+if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" {
+if claims, err := auth.ValidateToken(token); err == nil {
+if err := revoker.RevokeToken(r.Context(), claims); err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to revoke access token")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to logout")
+return
+}
+}
+}
+
+jsonResponse(w, http.StatusOK, map[string]string{"message": "Logged out"})
+}
+
+// handleLogoutAll ends every session the user has, including the one making
+// the request: all refresh tokens are revoked and the token version bump
+// rejects every access token issued so far.
+func handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+userID := r.Context().Value("user_id").(int64)
+
+if err := revokeAllSessions(r.Context(), int(userID)); err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Int64("user_id", userID).Msg("Failed to revoke sessions")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to logout")
+return
+}
+
+zlog.Ctx(r.Context()).Info().Int64("user_id", userID).Msg("User logged out everywhere")
+jsonResponse(w, http.StatusOK, map[string]string{"message": "Logged out of all sessions"})
+}
+
+// handleAdminRevokeSessions ends every session of another user, e.g. after
+// an account compromise.
+func handleAdminRevokeSessions(w http.ResponseWriter, r *http.Request) {
+adminID := r.Context().Value("user_id").(int64)
+targetID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+var exists bool
+if err := db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", targetID).Scan(&exists); err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to revoke sessions")
+return
+}
+if !exists {
+jsonError(w, http.StatusNotFound, "NOT_FOUND", "User not found")
+return
+}
+
+if err := revokeAllSessions(r.Context(), targetID); err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Int("user_id", targetID).Msg("Failed to revoke sessions")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to revoke sessions")
+return
+}
+
+zlog.Ctx(r.Context()).Info().Int64("admin_id", adminID).Int("user_id", targetID).Msg("Admin revoked user sessions")
+jsonResponse(w, http.StatusOK, map[string]interface{}{
+"user_id": targetID,
+"message": "All sessions revoked",
+})
+}
+
+// handleAdminUnlockAccount clears failed logins and any lockout for a user.
+func handleAdminUnlockAccount(w http.ResponseWriter, r *http.Request) {
+adminID := r.Context().Value("user_id").(int64)
+targetID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+var email string
+err := db.QueryRowContext(r.Context(), "SELECT email FROM users WHERE id = $1", targetID).Scan(&email)
+if err == sql.ErrNoRows {
+jsonError(w, http.StatusNotFound, "NOT_FOUND", "User not found")
+return
+}
+if err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to unlock account")
+return
+}
+
+wasLocked := loginGuard.Unlock(r.Context(), email)
+
+recordAudit(r, audit.Event{
+Type:    audit.EventAccountUnlocked,
+UserID:  targetID,
+Actor:   audit.AdminActor(adminID),
+Details: map[string]interface{}{"email": email, "was_locked": wasLocked},
+})
+
+zlog.Ctx(r.Context()).Info().Int64("admin_id", adminID).Int("user_id", targetID).Bool("was_locked", wasLocked).Msg("Admin unlocked account")
+jsonResponse(w, http.StatusOK, map[string]interface{}{
+"user_id":    targetID,
+"was_locked": wasLocked,
+"message":    "Account unlocked",
+})
+}
+
+// Reset emails per address, however many IPs the requests come from
+const (
+resetEmailLimit  = 3
+resetEmailWindow = time.Hour
+)
+
+// handleForgotPassword mails a reset link if the email belongs to an
+// account. The response is the same either way, and the mail is sent in the
+// background so response time does not reveal whether the account exists.
+// Requests past the per-address limit get the same response but no mail.
+func handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+var req struct {
+Email string `json:"email"`
+}
+
+if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+jsonError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
+return
+}
+
+req.Email = strings.TrimSpace(strings.ToLower(req.Email))
+if req.Email == "" {
+jsonError(w, http.StatusBadRequest, "MISSING_FIELDS", "Email is required")
+return
+}
+
+// Counted for unknown addresses too, so throttling reveals nothing; only a
+// hash of the address is stored
+sum := sha256.Sum256([]byte(req.Email))
+emailHash := hex.EncodeToString(sum[:8])
+if !limiter.Allow(r.Context(), "ratelimit:password_reset:"+emailHash, resetEmailLimit, resetEmailWindow).Allowed {
+zlog.Ctx(r.Context()).Warn().Str("email_hash", emailHash).Msg("Password reset requests for address throttled")
+jsonResponse(w, http.StatusAccepted, map[string]string{
+"message": "If an account exists for that email, a password reset link has been sent",
+})
+return
+}
+
+var userID int
+err := db.QueryRowContext(r.Context(), "SELECT id FROM users WHERE email = $1", req.Email).Scan(&userID)
+if err != nil && err != sql.ErrNoRows {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Database error during password reset request")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to process request")
+return
+}
+
+if err == nil {
+go sendPasswordReset(userID, req.Email)
+}
+
+jsonResponse(w, http.StatusAccepted, map[string]string{
+"message": "If an account exists for that email, a password reset link has been sent",
+})
+}
+
+func sendPasswordReset(userID int, email string) {
+bg := context.Background()
+
+token, err := userTokens.Issue(bg, userID, auth.PurposePasswordReset, auth.PasswordResetTTL)
+if err != nil {
+zlog.Error().Err(err).Int("user_id", userID).Msg("Failed to issue password reset token")
+return
+}
+
+link := appURL("/reset-password?token=" + url.QueryEscape(token))
+err = mail.Send(bg, mailer.Message{
+To:      email,
+Subject: "Reset your IOC Labs password",
+Text: "Someone asked to reset the password for your IOC Labs account.\n\n" +
+"Use this link within the next hour to choose a new password:\n" + link + "\n\n" +
+"If you did not ask for this, you can ignore this email; your password has not changed.",
+})
+if err != nil {
+zlog.Error().Err(err).Int("user_id", userID).Msg("Failed to send password reset email")
+return
+}
+
+zlog.Info().Int("user_id", userID).Msg("Password reset email sent")
+}
+
+// handleResetPassword sets a new password using a reset token. The token is
+// single-use, and every existing session is revoked afterwards.
+func handleResetPassword(w http.ResponseWriter, r *http.Request) {
+var req struct {
+Token    string `json:"token"`
+Password string `json:"password"`
+}
+
+if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+jsonError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
+return
+}
+
+if req.Token == "" || req.Password == "" {
+jsonError(w, http.StatusBadRequest, "MISSING_FIELDS", "Token and password are required")
+return
+}
+
+tx, err := db.BeginTx(r.Context(), nil)
+if err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to reset password")
+return
+}
+defer tx.Rollback()
+
+userID, err := userTokens.Consume(r.Context(), tx, req.Token, auth.PurposePasswordReset)
+if errors.Is(err, auth.ErrUserTokenInvalid) {
+jsonError(w, http.StatusBadRequest, "INVALID_TOKEN", "Reset link is invalid or has expired")
+return
+}
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to redeem password reset token")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to reset password")
+return
+}
+
+// The policy needs the account's details, so it is checked once the token
+// is known to be good. Returning here rolls back, leaving the link usable.
+var user auth.PasswordContext
+var firstName, lastName sql.NullString
+if err := tx.QueryRowContext(r.Context(),
+"SELECT email, first_name, last_name FROM users WHERE id = $1", userID,
+).Scan(&user.Email, &firstName, &lastName); err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to reset password")
+return
+}
+user.FirstName, user.LastName = firstName.String, lastName.String
+
+if errs := auth.ValidatePasswordStrength(req.Password, user); errs != nil {
+weakPasswordError(w, errs)
+return
+}
+
+hashedPassword, err := auth.HashPassword(req.Password)
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to hash password")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to reset password")
+return
+}
+
+if _, err := tx.ExecContext(r.Context(),
+"UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2",
+hashedPassword, userID,
+); err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to update password")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to reset password")
+return
+}
+
+if err := tx.Commit(); err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to reset password")
+return
+}
+
+if err := revokeAllSessions(r.Context(), userID); err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Int("user_id", userID).Msg("Password reset but sessions could not be revoked")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Password was reset but existing sessions could not be signed out")
+return
+}
+
+zlog.Ctx(r.Context()).Info().Int("user_id", userID).Msg("Password reset")
+jsonResponse(w, http.StatusOK, map[string]string{"message": "Password has been reset, please login"})
+}
+
+// Resend throttling for verification emails
+const (
+verificationResendInterval = time.Minute
+verificationResendPerHour  = 5
+)
+
+func sendEmailVerification(userID int, email string) {
+bg := context.Background()
+
+token, err := userTokens.Issue(bg, userID, auth.PurposeEmailVerification, auth.EmailVerificationTTL)
+if err != nil {
+zlog.Error().Err(err).Int("user_id", userID).Msg("Failed to issue email verification token")
+return
+}
+
+link := appURL("/verify-email?token=" + url.QueryEscape(token))
+err = mail.Send(bg, mailer.Message{
+To:      email,
+Subject: "Confirm your IOC Labs email address",
+Text: "Welcome to IOC Labs!\n\n" +
+"Please confirm your email address within the next 48 hours:\n" + link + "\n\n" +
+"If you did not create an account, you can ignore this email.",
+})
+if err != nil {
+zlog.Error().Err(err).Int("user_id", userID).Msg("Failed to send verification email")
+return
+}
+
+zlog.Info().Int("user_id", userID).Msg("Verification email sent")
+}
+
+// handleVerifyEmail marks the account's email address as verified using
+// the token from the verification email.
+func handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+var req struct {
+Token string `json:"token"`
+}
+
+if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+jsonError(w, http.StatusBadRequest, "INVALID_REQUEST", "token is required")
+return
+}
+
+tx, err := db.BeginTx(r.Context(), nil)
+if err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to verify email")
+return
+}
+defer tx.Rollback()
+
+userID, err := userTokens.Consume(r.Context(), tx, req.Token, auth.PurposeEmailVerification)
+if errors.Is(err, auth.ErrUserTokenInvalid) {
+jsonError(w, http.StatusBadRequest, "INVALID_TOKEN", "Verification link is invalid or has expired")
+return
+}
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to redeem verification token")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to verify email")
+return
+}
+
+if _, err := tx.ExecContext(r.Context(),
+"UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW() WHERE id = $1",
+userID,
+); err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to mark email verified")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to verify email")
+return
+}
+
+if err := tx.Commit(); err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to verify email")
+return
+}
+
+zlog.Ctx(r.Context()).Info().Int("user_id", userID).Msg("Email verified")
+jsonResponse(w, http.StatusOK, map[string]interface{}{
+"user_id":        userID,
+"email_verified": true,
+})
+}
+
+// handleResendVerification mails a new verification link to the logged-in
+// user. Requests are limited to one a minute and five an hour.
+func handleResendVerification(w http.ResponseWriter, r *http.Request) {
+userID := r.Context().Value("user_id").(int64)
+
+var email string
+var verified bool
+err := db.QueryRowContext(r.Context(),
+"SELECT email, email_verified_at IS NOT NULL FROM users WHERE id = $1", userID,
+).Scan(&email, &verified)
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to load user for verification resend")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to resend verification email")
+return
+}
+
+if verified {
+jsonError(w, http.StatusConflict, "ALREADY_VERIFIED", "Email address is already verified")
+return
+}
+
+now := time.Now()
+count, latest, err := userTokens.RecentIssues(r.Context(), int(userID), auth.PurposeEmailVerification, now.Add(-time.Hour))
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to check verification resend throttle")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to resend verification email")
+return
+}
+
+var retryAfter time.Duration
+if wait := latest.Add(verificationResendInterval).Sub(now); count > 0 && wait > 0 {
+retryAfter = wait
+}
+if count >= verificationResendPerHour {
+retryAfter = time.Hour
+}
+if retryAfter > 0 {
+w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+jsonError(w, http.StatusTooManyRequests, "RATE_LIMITED", "Too many verification emails requested, please try again later")
+return
+}
+
+go sendEmailVerification(int(userID), email)
+
+jsonResponse(w, http.StatusAccepted, map[string]string{
+"message": "Verification email sent",
+})
+}
+
+// appURL returns an absolute link into the web app, based on APP_BASE_URL.
+func appURL(path string) string {
+base := strings.TrimRight(os.Getenv("APP_BASE_URL"), "/")
+if base == "" {
+base = "http://localhost:8080"
+}
+return base + path
+}
+
+// handleListRoles lists the roles that can be assigned.
+func handleListRoles(w http.ResponseWriter, r *http.Request) {
+list, err := roles.Roles(r.Context())
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to list roles")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to list roles")
+return
+}
+jsonResponse(w, http.StatusOK, list)
+}
+
+// handleGrantRole gives a user a role.
+func handleGrantRole(w http.ResponseWriter, r *http.Request) {
+adminID := r.Context().Value("user_id").(int64)
+targetID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+var req struct {
+Role string `json:"role"`
+}
+if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Role == "" {
+jsonError(w, http.StatusBadRequest, "INVALID_REQUEST", "role is required")
+return
+}
+
+changeRole(w, r, targetID, req.Role, "granted", func() error {
+return roles.Grant(r.Context(), targetID, req.Role, audit.AdminActor(adminID))
+})
+}
+
+// handleRevokeRole takes a role away from a user.
+func handleRevokeRole(w http.ResponseWriter, r *http.Request) {
+targetID, _ := strconv.Atoi(mux.Vars(r)["id"])
+role := mux.Vars(r)["role"]
+
+changeRole(w, r, targetID, role, "revoked", func() error {
+return roles.Revoke(r.Context(), targetID, role)
+})
+}
+
+// changeRole applies a role change and bumps the user's token version, so
+// their current access token is rejected and the next refresh picks up the
+// new permissions.
+func changeRole(w http.ResponseWriter, r *http.Request, userID int, role, action string, apply func() error) {
+adminID := r.Context().Value("user_id").(int64)
+
+err := apply()
+switch {
+case errors.Is(err, auth.ErrUnknownUser):
+jsonError(w, http.StatusNotFound, "NOT_FOUND", "User not found")
+return
+case errors.Is(err, auth.ErrUnknownRole):
+jsonError(w, http.StatusBadRequest, "UNKNOWN_ROLE", err.Error())
+return
+case errors.Is(err, auth.ErrLastAdmin):
+jsonError(w, http.StatusConflict, "LAST_ADMIN", "Cannot remove the admin role from the last admin")
+return
+case err != nil:
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to change role")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to change role")
+return
+}
+
+eventType := audit.EventRoleGranted
+if action == "revoked" {
+eventType = audit.EventRoleRevoked
+}
+recordAudit(r, audit.Event{
+Type:    eventType,
+UserID:  userID,
+Actor:   audit.AdminActor(adminID),
+Details: map[string]interface{}{"role": role},
+})
+
+// Until the token version is bumped the user's access tokens still carry
+// the old permissions, so a failure here must not look like success
+if _, err := revoker.RevokeAll(r.Context(), userID); err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Int("user_id", userID).Msg("Failed to expire tokens after role change")
+jsonError(w, http.StatusInternalServerError, "TOKEN_REVOCATION_FAILED", "Role "+action+", but existing sessions could not be expired; retry or revoke sessions")
+return
+}
+
+userRoles, permissions, err := roles.ForUser(r.Context(), userID)
+if err != nil {
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to load roles")
+return
+}
+
+zlog.Ctx(r.Context()).Info().Int64("admin_id", adminID).Int("user_id", userID).Str("role", role).Msg("Role " + action)
+jsonResponse(w, http.StatusOK, map[string]interface{}{
+"user_id":     userID,
+"roles":       userRoles,
+"permissions": permissions,
+})
+}
+
+func revokeAllSessions(ctx context.Context, userID int) error {
+if err := refreshTokens.RevokeUser(ctx, userID); err != nil {
+return err
+}
+_, err := revoker.RevokeAll(ctx, userID)
+return err
+}
+
+func handleGetCart(w http.ResponseWriter, r *http.Request) {
+userID := r.Context().Value("user_id").(int64)
+
+var cartID int
+db.QueryRowContext(r.Context(), "SELECT id FROM carts WHERE user_id = $1", userID).Scan(&cartID)
+
+rows, _ := db.QueryContext(r.Context(), `
+SELECT ci.id, ci.product_id, ci.quantity, p.name, p.price_cents, p.currency, p.image_url
+FROM cart_items ci JOIN products p ON ci.product_id = p.id
+WHERE ci.cart_id = $1
+`, cartID)
+defer rows.Close()
+
+items := []map[string]interface{}{}
+total := money.Zero(money.DefaultCurrency)
+
+for rows.Next() {
+var itemID, productID, quantity int
+var name, imageURL, currency string
+var priceCents int64
+rows.Scan(&itemID, &productID, &quantity, &name, &priceCents, &currency, &imageURL)
+price := money.New(priceCents, currency)
+subtotal := price.Mul(quantity)
+if len(items) == 0 {
+total = money.Zero(subtotal.Currency)
+}
+var err error
+if total, err = total.Add(subtotal); err != nil {
+jsonError(w, http.StatusConflict, "MIXED_CURRENCY", "Cart contains items priced in different currencies")
+return
+}
+items = append(items, map[string]interface{}{
+"id": itemID, "product_id": productID, "quantity": quantity,
+"name": name, "price": price, "image_url": imageURL, "subtotal": subtotal,
+})
+}
+
+jsonResponse(w, http.StatusOK, map[string]interface{}{
+"items": items,
+"total": total,
+})
+}
+
+func handleAddToCart(w http.ResponseWriter, r *http.Request) {
+userID := r.Context().Value("user_id").(int64)
+
+var req struct {
+ProductID int `json:"product_id"`
+Quantity  int `json:"quantity"`
+}
+json.NewDecoder(r.Body).Decode(&req)
+
+var cartID int
+db.QueryRowContext(r.Context(), "SELECT id FROM carts WHERE user_id = $1", userID).Scan(&cartID)
+
+var stock int
+db.QueryRowContext(r.Context(), "SELECT stock FROM products WHERE id = $1", req.ProductID).Scan(&stock)
+
+if stock < req.Quantity {
+jsonError(w, http.StatusBadRequest, "INSUFFICIENT_STOCK", "Not enough stock")
+return
+}
+
+db.ExecContext(r.Context(), `
+INSERT INTO cart_items (cart_id, product_id, quantity)
+VALUES ($1, $2, $3)
+ON CONFLICT (cart_id, product_id) DO UPDATE SET quantity = cart_items.quantity + $3
+`, cartID, req.ProductID, req.Quantity)
+
+jsonResponse(w, http.StatusOK, map[string]string{"message": "Added to cart"})
+}
+
+func handleClearCart(w http.ResponseWriter, r *http.Request) {
+userID := r.Context().Value("user_id").(int64)
+
+var cartID int
+db.QueryRowContext(r.Context(), "SELECT id FROM carts WHERE user_id = $1", userID).Scan(&cartID)
+db.ExecContext(r.Context(), "DELETE FROM cart_items WHERE cart_id = $1", cartID)
+
+jsonResponse(w, http.StatusOK, map[string]string{"message": "Cart cleared"})
+}
+
+// insufficientStockError reports a cart line that cannot be filled from the
+// product's current stock.
+type insufficientStockError struct {
+ProductID int
+Name      string
+Requested int
+Available int
+}
+
+func (e *insufficientStockError) Error() string {
+return fmt.Sprintf("Not enough stock for %s (requested %d, available %d)", e.Name, e.Requested, e.Available)
+}
+
+func handleCreateOrder(w http.ResponseWriter, r *http.Request) {
+userID := r.Context().Value("user_id").(int64)
+
+if err := verification.CheckCanOrder(r.Context(), db, userID); err != nil {
+if errors.Is(err, auth.ErrEmailNotVerified) {
+jsonError(w, http.StatusForbidden, "EMAIL_NOT_VERIFIED", "Please verify your email address before placing an order")
+return
+}
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to check email verification")
+jsonError(w, http.StatusInternalServerError, "ORDER_FAILED", "Failed to create order")
+return
+}
+
+tx, err := db.BeginTx(r.Context(), nil)
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to begin checkout transaction")
+jsonError(w, http.StatusInternalServerError, "ORDER_FAILED", "Failed to create order")
+return
+}
+defer tx.Rollback()
+
+orderID, total, err := checkoutCart(r.Context(), tx, userID)
+if err != nil {
+var stockErr *insufficientStockError
+switch {
+case errors.As(err, &stockErr):
+jsonError(w, http.StatusConflict, "INSUFFICIENT_STOCK", stockErr.Error())
+case errors.Is(err, errEmptyCart):
+jsonError(w, http.StatusBadRequest, "EMPTY_CART", "Cart is empty")
+case errors.Is(err, money.ErrCurrencyMismatch):
+jsonError(w, http.StatusConflict, "MIXED_CURRENCY", "Cart contains items priced in different currencies")
+default:
+zlog.Ctx(r.Context()).Error().Err(err).Int64("user_id", userID).Msg("Checkout failed")
+jsonError(w, http.StatusInternalServerError, "ORDER_FAILED", "Failed to create order")
+}
+return
+}
+
+if err := tx.Commit(); err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Int64("user_id", userID).Msg("Failed to commit checkout transaction")
+jsonError(w, http.StatusInternalServerError, "ORDER_FAILED", "Failed to create order")
+return
+}
+metrics.OrderCreated()
+
+jsonResponse(w, http.StatusCreated, map[string]interface{}{
+"id":     orderID,
+"total":  total,
+"status": orders.StatusPending,
+})
+}
+
+var errEmptyCart = errors.New("cart is empty")
+
+// checkoutCart turns the user's cart into an order inside tx. Product rows are
+// locked in id order so concurrent checkouts serialize on the same products
+// instead of deadlocking, and stock is re-checked under the lock.
+func checkoutCart(ctx context.Context, tx *sql.Tx, userID int64) (int, money.Money, error) {
+var cartID int
+err := tx.QueryRowContext(ctx, "SELECT id FROM carts WHERE user_id = $1 FOR UPDATE", userID).Scan(&cartID)
+if err == sql.ErrNoRows {
+return 0, money.Money{}, errEmptyCart
+}
+if err != nil {
+return 0, money.Money{}, fmt.Errorf("load cart: %w", err)
+}
+
+rows, err := tx.QueryContext(ctx, `
+SELECT ci.product_id, ci.quantity, p.name, p.price_cents, p.currency, p.stock
+FROM cart_items ci JOIN products p ON ci.product_id = p.id
+WHERE ci.cart_id = $1
+ORDER BY p.id
+FOR UPDATE OF p
+`, cartID)
+if err != nil {
+return 0, money.Money{}, fmt.Errorf("lock cart products: %w", err)
+}
+
+type CartItem struct {
+ProductID int
+Quantity  int
+Name      string
+Price     money.Money
+Stock     int
+}
+
+var items []CartItem
+var total money.Money
+
+for rows.Next() {
+var item CartItem
+var priceCents int64
+var currency string
+if err := rows.Scan(&item.ProductID, &item.Quantity, &item.Name, &priceCents, &currency, &item.Stock); err != nil {
+rows.Close()
+return 0, money.Money{}, fmt.Errorf("scan cart item: %w", err)
+}
+item.Price = money.New(priceCents, currency)
+if len(items) == 0 {
+total = money.Zero(item.Price.Currency)
+}
+if total, err = total.Add(item.Price.Mul(item.Quantity)); err != nil {
+rows.Close()
+return 0, money.Money{}, err
+}
+items = append(items, item)
+}
+rows.Close()
+if err := rows.Err(); err != nil {
+return 0, money.Money{}, fmt.Errorf("read cart items: %w", err)
+}
+
+if len(items) == 0 {
+return 0, money.Money{}, errEmptyCart
+}
+
+for _, item := range items {
+if item.Stock < item.Quantity {
+return 0, money.Money{}, &insufficientStockError{
+ProductID: item.ProductID,
+Name:      item.Name,
+Requested: item.Quantity,
+Available: item.Stock,
+}
+}
+}
+
+var orderID int
+err = tx.QueryRowContext(ctx,
+"INSERT INTO orders (user_id, total_cents, currency, status) VALUES ($1, $2, $3, $4) RETURNING id",
+userID, total.Amount, total.Currency, orders.StatusPending,
+).Scan(&orderID)
+if err != nil {
+return 0, money.Money{}, fmt.Errorf("insert order: %w", err)
+}
+
+if err := orders.RecordCreated(ctx, tx, int64(orderID), audit.UserActor(userID)); err != nil {
+return 0, money.Money{}, err
+}
+
+for _, item := range items {
+if _, err := tx.ExecContext(ctx,
+"INSERT INTO order_items (order_id, product_id, product_name, quantity, price_cents, subtotal_cents) VALUES ($1, $2, $3, $4, $5, $6)",
+orderID, item.ProductID, item.Name, item.Quantity, item.Price.Amount, item.Price.Mul(item.Quantity).Amount,
+); err != nil {
+return 0, money.Money{}, fmt.Errorf("insert order item for product %d: %w", item.ProductID, err)
+}
+
+if _, err := tx.ExecContext(ctx,
+"UPDATE products SET stock = stock - $1 WHERE id = $2",
+item.Quantity, item.ProductID,
+); err != nil {
+return 0, money.Money{}, fmt.Errorf("decrement stock for product %d: %w", item.ProductID, err)
+}
+}
+
+if _, err := tx.ExecContext(ctx, "DELETE FROM cart_items WHERE cart_id = $1", cartID); err != nil {
+return 0, money.Money{}, fmt.Errorf("clear cart: %w", err)
+}
+
+return orderID, total, nil
+}
+
+func handleListOrders(w http.ResponseWriter, r *http.Request) {
+userID := r.Context().Value("user_id").(int64)
+
+rows, _ := db.QueryContext(r.Context(),
+"SELECT id, total_cents, currency, status, payment_status, created_at FROM orders WHERE user_id = $1 ORDER BY created_at DESC",
+userID,
+)
+defer rows.Close()
+
+list := []map[string]interface{}{}
+for rows.Next() {
+var id int
+var totalCents int64
+var currency, status, paymentStatus string
+var createdAt time.Time
+rows.Scan(&id, &totalCents, &currency, &status, &paymentStatus, &createdAt)
+list = append(list, map[string]interface{}{
+"id":             id,
+"total":          money.New(totalCents, currency),
+"status":         status,
+"payment_status": paymentStatus,
+"created_at":     createdAt,
+})
+}
+
+jsonResponse(w, http.StatusOK, list)
+}
+
+func handleGetOrder(w http.ResponseWriter, r *http.Request) {
+userID := r.Context().Value("user_id").(int64)
+vars := mux.Vars(r)
+orderID := vars["id"]
+
+var totalCents int64
+var currency, status, paymentStatus string
+var createdAt time.Time
+var ownerID int64
+
+err := db.QueryRowContext(r.Context(),
+"SELECT user_id, total_cents, currency, status, payment_status, created_at FROM orders WHERE id = $1",
+orderID,
+).Scan(&ownerID, &totalCents, &currency, &status, &paymentStatus, &createdAt)
+
+if err == sql.ErrNoRows {
+jsonError(w, http.StatusNotFound, "NOT_FOUND", "Order not found")
+return
+}
+
+if ownerID != userID {
+jsonError(w, http.StatusForbidden, "FORBIDDEN", "Not your order")
+return
+}
+
+rows, _ := db.QueryContext(r.Context(),
+"SELECT id, product_name, quantity, price_cents, subtotal_cents FROM order_items WHERE order_id = $1 ORDER BY id",
+orderID,
+)
+defer rows.Close()
+
+items := []map[string]interface{}{}
+for rows.Next() {
+var itemID int
+var name string
+var quantity int
+var priceCents, subtotalCents int64
+rows.Scan(&itemID, &name, &quantity, &priceCents, &subtotalCents)
+items = append(items, map[string]interface{}{
+"id": itemID, "name": name, "quantity": quantity,
+"price": money.New(priceCents, currency), "subtotal": money.New(subtotalCents, currency),
+})
+}
+
+id, _ := strconv.ParseInt(orderID, 10, 64)
+history, err := orders.History(r.Context(), db, id)
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Str("order_id", orderID).Msg("Failed to load order status history")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to load order")
+return
+}
+
+jsonResponse(w, http.StatusOK, map[string]interface{}{
+"id":             orderID,
+"total":          money.New(totalCents, currency),
+"status":         status,
+"payment_status": paymentStatus,
+"items":          items,
+"history":        history,
+"created_at":     createdAt,
+})
+}
+
+func handleCancelOrder(w http.ResponseWriter, r *http.Request) {
+userID := r.Context().Value("user_id").(int64)
+orderID, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+
+var req struct {
+Reason string `json:"reason"`
+}
+if r.ContentLength > 0 {
+if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+jsonError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
+return
+}
+}
+
+var ownerID int64
+var status string
+var paymentIntentID sql.NullString
+err := db.QueryRowContext(r.Context(),
+"SELECT user_id, status, stripe_payment_intent_id FROM orders WHERE id = $1",
+orderID,
+).Scan(&ownerID, &status, &paymentIntentID)
+
+if err == sql.ErrNoRows {
+jsonError(w, http.StatusNotFound, "NOT_FOUND", "Order not found")
+return
+}
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Int64("order_id", orderID).Msg("Failed to load order for cancellation")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to cancel order")
+return
+}
+
+if ownerID != userID {
+jsonError(w, http.StatusForbidden, "FORBIDDEN", "Not your order")
+return
+}
+
+if !orders.CanTransition(orders.Status(status), orders.StatusCanceled) {
+jsonError(w, http.StatusConflict, "ORDER_NOT_CANCELABLE", fmt.Sprintf("Order is %s and can no longer be canceled", status))
+return
+}
+
+reason := strings.TrimSpace(req.Reason)
+if reason == "" {
+reason = "canceled by customer"
+}
+
+tx, err := db.BeginTx(r.Context(), nil)
+if err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Failed to begin cancellation transaction")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to cancel order")
+return
+}
+defer tx.Rollback()
+
+if err := orders.Cancel(r.Context(), tx, orderID, audit.UserActor(userID), reason); err != nil {
+if errors.Is(err, orders.ErrInvalidTransition) {
+jsonError(w, http.StatusConflict, "ORDER_NOT_CANCELABLE", "Order can no longer be canceled")
+return
+}
+zlog.Ctx(r.Context()).Error().Err(err).Int64("order_id", orderID).Msg("Failed to cancel order")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to cancel order")
+return
+}
+
+var canceledAt time.Time
+// Read the intent again under the row lock, in case checkout replaced it
+if err := tx.QueryRowContext(r.Context(),
+"SELECT canceled_at, stripe_payment_intent_id FROM orders WHERE id = $1", orderID,
+).Scan(&canceledAt, &paymentIntentID); err != nil {
+zlog.Ctx(r.Context()).Error().Err(err).Int64("order_id", orderID).Msg("Failed to read cancellation time")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to cancel order")
+return
+}
+
+// The intent is canceled only once the order is, and while its row is
+// still locked, so nothing is canceled at the provider for an order that
+// stays open. If the customer has paid in the meantime, the order is
+// rolled back and stays open.
+if paymentIntentID.Valid && paymentIntentID.String != "" {
+if _, err := paymentService.CancelPaymentIntent(r.Context(), paymentIntentID.String); err != nil {
+if errors.Is(err, services.ErrPaymentAlreadySucceeded) {
+jsonError(w, http.StatusConflict, "ORDER_NOT_CANCELABLE", "Order has already been paid")
+return
+}
+zlog.Ctx(r.Context()).Error().Err(err).Int64("order_id", orderID).Msg("Failed to cancel payment intent")
+jsonError(w, http.StatusBadGateway, "PAYMENT_CANCEL_FAILED", "Failed to cancel payment")
+return
+}
+}
+
+if err := tx.Commit(); err != nil {
+// The intent is already canceled. Its payment_intent.canceled webhook
+// cancels the order, and until then checkout replaces the dead intent
+zlog.Ctx(r.Context()).Error().Err(err).Int64("order_id", orderID).Str("payment_intent", paymentIntentID.String).Msg("Failed to commit cancellation after canceling payment intent")
+jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to cancel order")
+return
+}
+
+zlog.Ctx(r.Context()).Info().Int64("order_id", orderID).Int64("user_id", userID).Msg("Order canceled by customer")
+
+jsonResponse(w, http.StatusOK, map[string]interface{}{
+"id":          orderID,
+"status":      orders.StatusCanceled,
+"canceled_at": canceledAt,
+"canceled_by": audit.UserActor(userID),
+})
+}
+
+func authMiddleware(next http.Handler) http.Handler {
+return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// Get Authorization header
+authHeader := r.Header.Get("Authorization")
+if authHeader == "" {
+jsonError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Missing authorization header")
+return
+}
+
+// Extract token from "Bearer <token>" format
 token := strings.TrimPrefix(authHeader, "Bearer ")
 if token == authHeader {
 // "Bearer " prefix was not found
@@ -585,7 +1942,7 @@ errorCode = "UNAUTHORIZED"
 errorMessage = "Authentication failed"
 }
 
-zlog.Warn().
+zlog.Ctx(r.Context()).Warn().
 Err(err).
 Str("error_code", errorCode).
 Msg("Token validation failed")
@@ -594,18 +1951,53 @@ jsonError(w, http.StatusUnauthorized, errorCode, errorMessage)
 return
 }
 
-// Add user ID to context
+// Reject tokens that were revoked before they expired
+if err := revoker.Check(r.Context(), claims); err != nil {
+if errors.Is(err, auth.ErrTokenRevoked) {
+jsonError(w, http.StatusUnauthorized, "TOKEN_REVOKED", "Token has been revoked, please login again")
+return
+}
+zlog.Ctx(r.Context()).Error().Err(err).Msg("Token revocation check failed")
+jsonError(w, http.StatusServiceUnavailable, "AUTH_UNAVAILABLE", "Unable to verify session")
+return
+}
+
+// Add user ID and claims to context
 ctx := context.WithValue(r.Context(), "user_id", int64(claims.UserID))
+ctx = auth.WithClaims(ctx, claims)
+ctx = middleware.WithUserID(ctx, claims.UserID)
 next.ServeHTTP(w, r.WithContext(ctx))
 })
 }
 
+// metricsHandler serves the Prometheus metrics. With a token configured,
+// scrapers must send it as a bearer token.
+func metricsHandler(token string) http.Handler {
+handler := metrics.Handler()
+if token == "" {
+return handler
+}
+return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+jsonError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Invalid metrics token")
+return
+}
+handler.ServeHTTP(w, r)
+})
+}
+
+// requirePermission wraps a single admin handler in a permission check.
+func requirePermission(permission string, handler http.HandlerFunc) http.Handler {
+return middleware.RequirePermission(permission)(handler)
+}
 
 func corsMiddleware(next http.Handler) http.Handler {
 return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 w.Header().Set("Access-Control-Allow-Origin", "*")
 w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key, X-Request-ID, traceparent, tracestate")
+w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 
 if r.Method == "OPTIONS" {
 w.WriteHeader(http.StatusOK)
@@ -625,6 +2017,26 @@ json.NewEncoder(w).Encode(map[string]interface{}{
 })
 }
 
+// weakPasswordError rejects a password that breaks the password policy.
+// details lists every broken rule so the client can show them all.
+func weakPasswordError(w http.ResponseWriter, errs validator.ValidationErrors) {
+problems := make([]string, len(errs))
+for i, e := range errs {
+problems[i] = e.Message
+}
+
+w.Header().Set("Content-Type", "application/json")
+w.WriteHeader(http.StatusBadRequest)
+json.NewEncoder(w).Encode(map[string]interface{}{
+"success": false,
+"error": map[string]interface{}{
+"code":    "WEAK_PASSWORD",
+"message": "Password " + strings.Join(problems, ", "),
+"details": errs,
+},
+})
+}
+
 func jsonError(w http.ResponseWriter, status int, code, message string) {
 w.Header().Set("Content-Type", "application/json")
 w.WriteHeader(status)
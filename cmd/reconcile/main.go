@@ -0,0 +1,99 @@
+// Command reconcile compares orders with the payment provider, repairs
+// transitions missed because a webhook never arrived, and writes a report of
+// the remaining discrepancies. It is meant to run nightly, e.g. from cron:
+//
+//	go run ./cmd/reconcile -since 48h -format csv -out /var/reports/reconcile.csv
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/reconcile"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/services"
+)
+
+func main() {
+	since := flag.Duration("since", 48*time.Hour, "check orders touched within this window (0 checks every order)")
+	dryRun := flag.Bool("dry-run", false, "report discrepancies without repairing them")
+	format := flag.String("format", "json", "report format: json or csv")
+	out := flag.String("out", "", "write the report to this file instead of stdout")
+	flag.Parse()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	zlog.Logger = zlog.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	if *format != "json" && *format != "csv" {
+		log.Fatalf("Unknown report format %q", *format)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		zlog.Warn().Msg("No .env file found")
+	}
+
+	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		log.Fatal("Database ping failed:", err)
+	}
+
+	provider, err := services.NewPaymentProviderFromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure payment provider:", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	opts := reconcile.Options{DryRun: *dryRun}
+	if *since > 0 {
+		opts.Since = time.Now().Add(-*since)
+	}
+
+	r := reconcile.New(reconcile.NewSQLStore(db), services.NewPaymentService(provider))
+	report, err := r.Run(ctx, opts)
+	if err != nil {
+		log.Fatal("Reconciliation failed:", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal("Failed to create report file:", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "csv" {
+		err = report.WriteCSV(w)
+	} else {
+		err = report.WriteJSON(w)
+	}
+	if err != nil {
+		log.Fatal("Failed to write report:", err)
+	}
+
+	zlog.Info().
+		Int("checked", report.Checked).
+		Int("discrepancies", len(report.Discrepancies)).
+		Int("fixed", report.Fixed).
+		Bool("dry_run", report.DryRun).
+		Msg("Reconciliation complete")
+}
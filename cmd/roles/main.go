@@ -0,0 +1,131 @@
+// Command roles assigns and removes user roles. It is how the first admin is
+// created; after that, admins can also use the /api/admin role endpoints.
+//
+//	go run ./cmd/roles -email ops@example.com grant admin
+//	go run ./cmd/roles -user 42 revoke support
+//	go run ./cmd/roles -email ops@example.com list
+//	go run ./cmd/roles roles
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/audit"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/auth"
+)
+
+func main() {
+	email := flag.String("email", "", "user to change, by email")
+	userID := flag.Int("user", 0, "user to change, by ID")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: roles [-email addr | -user id] grant|revoke <role>")
+		fmt.Fprintln(os.Stderr, "       roles [-email addr | -user id] list")
+		fmt.Fprintln(os.Stderr, "       roles roles")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		log.Fatal("Database ping failed:", err)
+	}
+
+	ctx := context.Background()
+	store := auth.NewRoleStore(db)
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if args[0] == "roles" {
+		list, err := store.Roles(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, role := range list {
+			fmt.Printf("%-12s %s\n", role.Name, strings.Join(role.Permissions, ","))
+		}
+		return
+	}
+
+	id, err := resolveUser(ctx, db, *email, *userID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+	case (args[0] == "grant" || args[0] == "revoke") && len(args) == 2:
+		if args[0] == "grant" {
+			err = store.Grant(ctx, id, args[1], audit.ActorCLI)
+		} else {
+			err = store.Revoke(ctx, id, args[1])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Expire the user's current tokens so the next refresh carries the
+		// new permissions.
+		if _, err := auth.NewRevoker(db, redisFromEnv(ctx)).RevokeAll(ctx, id); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	roles, permissions, err := store.ForUser(ctx, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("user %d\n  roles:       %s\n  permissions: %s\n", id, strings.Join(roles, ","), strings.Join(permissions, ","))
+}
+
+// resolveUser finds the user named by exactly one of email and id.
+func resolveUser(ctx context.Context, db *sql.DB, email string, id int) (int, error) {
+	if (email == "") == (id == 0) {
+		return 0, errors.New("exactly one of -email or -user is required")
+	}
+	if id != 0 {
+		return id, nil
+	}
+
+	err := db.QueryRowContext(ctx, "SELECT id FROM users WHERE email = $1", strings.ToLower(email)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no user with email %s", email)
+	}
+	return id, err
+}
+
+// redisFromEnv connects to the API's Redis so its cached token version is
+// updated too. Without Redis the change still lands in the database.
+func redisFromEnv(ctx context.Context) *redis.Client {
+	client := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_URL")})
+	if err := client.Ping(ctx).Err(); err != nil {
+		log.Println("Redis unavailable, cached token versions expire on their own:", err)
+		return nil
+	}
+	return client
+}
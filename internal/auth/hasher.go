@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+var ErrUnsupportedHash = errors.New("unsupported password hash format")
+
+// HasherConfig selects the algorithm new password hashes use and its
+// parameters. Hashes made with any supported algorithm can still be
+// verified; they are reported as outdated so they can be upgraded.
+type HasherConfig struct {
+	Algorithm string
+
+	BcryptCost int
+
+	// Argon2Memory is in KiB
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	Argon2SaltLength  uint32
+	Argon2KeyLength   uint32
+}
+
+// DefaultHasherConfig keeps bcrypt at its default cost, which is what every
+// existing hash was made with. The argon2id parameters follow the OWASP
+// recommendation and only apply once the algorithm is switched.
+var DefaultHasherConfig = HasherConfig{
+	Algorithm:         AlgorithmBcrypt,
+	BcryptCost:        bcrypt.DefaultCost,
+	Argon2Memory:      64 * 1024,
+	Argon2Iterations:  3,
+	Argon2Parallelism: 2,
+	Argon2SaltLength:  16,
+	Argon2KeyLength:   32,
+}
+
+// PasswordHasher hashes and verifies passwords. bcrypt hashes use their
+// standard $2a$ encoding; argon2id hashes use the PHC string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type PasswordHasher struct {
+	cfg HasherConfig
+}
+
+// NewPasswordHasher checks cfg and returns a PasswordHasher.
+func NewPasswordHasher(cfg HasherConfig) (*PasswordHasher, error) {
+	switch cfg.Algorithm {
+	case AlgorithmBcrypt:
+		if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case AlgorithmArgon2id:
+		if cfg.Argon2Memory < 8*uint32(cfg.Argon2Parallelism) || cfg.Argon2Iterations < 1 || cfg.Argon2Parallelism < 1 {
+			return nil, errors.New("argon2id needs at least 1 iteration, 1 thread and 8 KiB of memory per thread")
+		}
+		if cfg.Argon2SaltLength < 8 || cfg.Argon2KeyLength < 16 {
+			return nil, errors.New("argon2id needs a salt of at least 8 bytes and a key of at least 16 bytes")
+		}
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", cfg.Algorithm)
+	}
+	return &PasswordHasher{cfg: cfg}, nil
+}
+
+// PasswordHasherFromEnv builds a PasswordHasher from DefaultHasherConfig,
+// overridden by PASSWORD_HASH_ALGORITHM, PASSWORD_BCRYPT_COST,
+// PASSWORD_ARGON2_MEMORY_KIB, PASSWORD_ARGON2_ITERATIONS and
+// PASSWORD_ARGON2_PARALLELISM.
+func PasswordHasherFromEnv() (*PasswordHasher, error) {
+	cfg := DefaultHasherConfig
+	if v := os.Getenv("PASSWORD_HASH_ALGORITHM"); v != "" {
+		cfg.Algorithm = strings.ToLower(v)
+	}
+
+	ints := []struct {
+		name string
+		set  func(uint64)
+		bits int
+	}{
+		{"PASSWORD_BCRYPT_COST", func(n uint64) { cfg.BcryptCost = int(n) }, 8},
+		{"PASSWORD_ARGON2_MEMORY_KIB", func(n uint64) { cfg.Argon2Memory = uint32(n) }, 32},
+		{"PASSWORD_ARGON2_ITERATIONS", func(n uint64) { cfg.Argon2Iterations = uint32(n) }, 32},
+		{"PASSWORD_ARGON2_PARALLELISM", func(n uint64) { cfg.Argon2Parallelism = uint8(n) }, 8},
+	}
+	for _, v := range ints {
+		raw := os.Getenv(v.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(raw, 10, v.bits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", v.name, err)
+		}
+		v.set(n)
+	}
+
+	return NewPasswordHasher(cfg)
+}
+
+// Hash hashes a password with the configured algorithm.
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("password cannot be empty")
+	}
+
+	if h.cfg.Algorithm == AlgorithmBcrypt {
+		b, err := bcrypt.GenerateFromPassword([]byte(password), h.cfg.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+
+	salt := make([]byte, h.cfg.Argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	p := argon2Params{
+		memory:      h.cfg.Argon2Memory,
+		iterations:  h.cfg.Argon2Iterations,
+		parallelism: h.cfg.Argon2Parallelism,
+	}
+	key := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, h.cfg.Argon2KeyLength)
+	return p.encode(salt, key), nil
+}
+
+// Verify checks a password against a hash made with any supported
+// algorithm. It returns ErrInvalidPassword if the password does not match.
+// needsRehash is true when the password matched but the hash was made with
+// a different algorithm or weaker parameters than are now configured.
+func (h *PasswordHasher) Verify(password, encoded string) (needsRehash bool, err error) {
+	if strings.HasPrefix(encoded, "$argon2id$") {
+		return h.verifyArgon2id(password, encoded)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, ErrInvalidPassword
+		}
+		return false, err
+	}
+
+	if h.cfg.Algorithm != AlgorithmBcrypt {
+		return true, nil
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, err
+	}
+	return cost != h.cfg.BcryptCost, nil
+}
+
+func (h *PasswordHasher) verifyArgon2id(password, encoded string) (bool, error) {
+	p, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	got := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(got, key) != 1 {
+		return false, ErrInvalidPassword
+	}
+
+	cfg := h.cfg
+	outdated := cfg.Algorithm != AlgorithmArgon2id ||
+		p.memory != cfg.Argon2Memory ||
+		p.iterations != cfg.Argon2Iterations ||
+		p.parallelism != cfg.Argon2Parallelism ||
+		uint32(len(salt)) != cfg.Argon2SaltLength ||
+		uint32(len(key)) != cfg.Argon2KeyLength
+	return outdated, nil
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+var phcEncoding = base64.RawStdEncoding
+
+func (p argon2Params) encode(salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.iterations, p.parallelism,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key))
+}
+
+func decodeArgon2id(encoded string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return p, nil, nil, ErrUnsupportedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("%w: argon2 version %q", ErrUnsupportedHash, parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return p, nil, nil, fmt.Errorf("%w: argon2 parameters %q", ErrUnsupportedHash, parts[3])
+	}
+	if p.iterations < 1 || p.parallelism < 1 {
+		return p, nil, nil, fmt.Errorf("%w: argon2 parameters %q", ErrUnsupportedHash, parts[3])
+	}
+
+	salt, err := phcEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("%w: bad salt", ErrUnsupportedHash)
+	}
+	key, err := phcEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, fmt.Errorf("%w: bad hash", ErrUnsupportedHash)
+	}
+	return p, salt, key, nil
+}
+
+var (
+	passwordHasherMu sync.RWMutex
+	passwordHasher   *PasswordHasher
+)
+
+// SetPasswordHasher installs the hasher used by HashPassword and
+// CheckPasswordHash. Until one is set, both use DefaultHasherConfig.
+func SetPasswordHasher(h *PasswordHasher) {
+	passwordHasherMu.Lock()
+	defer passwordHasherMu.Unlock()
+	passwordHasher = h
+}
+
+// CurrentPasswordHasher returns the installed hasher, or one built from
+// DefaultHasherConfig if none has been installed.
+func CurrentPasswordHasher() *PasswordHasher {
+	passwordHasherMu.RLock()
+	h := passwordHasher
+	passwordHasherMu.RUnlock()
+	if h != nil {
+		return h
+	}
+	return &PasswordHasher{cfg: DefaultHasherConfig}
+}
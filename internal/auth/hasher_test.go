@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastArgon2 keeps the tests quick; production parameters are much higher.
+var fastArgon2 = HasherConfig{
+	Algorithm:         AlgorithmArgon2id,
+	Argon2Memory:      64,
+	Argon2Iterations:  1,
+	Argon2Parallelism: 1,
+	Argon2SaltLength:  16,
+	Argon2KeyLength:   32,
+}
+
+func mustHasher(t *testing.T, cfg HasherConfig) *PasswordHasher {
+	t.Helper()
+	h, err := NewPasswordHasher(cfg)
+	if err != nil {
+		t.Fatalf("NewPasswordHasher failed: %v", err)
+	}
+	return h
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	h := mustHasher(t, fastArgon2)
+
+	hash, err := h.Hash("MySecureP@ssw0rd")
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("unexpected PHC string: %s", hash)
+	}
+
+	needsRehash, err := h.Verify("MySecureP@ssw0rd", hash)
+	if err != nil || needsRehash {
+		t.Errorf("Verify = %v, %v; want false, nil", needsRehash, err)
+	}
+
+	if _, err := h.Verify("WrongPassword123", hash); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("expected ErrInvalidPassword, got %v", err)
+	}
+
+	other, _ := h.Hash("MySecureP@ssw0rd")
+	if other == hash {
+		t.Error("expected a fresh salt for every hash")
+	}
+}
+
+func TestVerifyReportsOutdatedHashes(t *testing.T) {
+	bcrypt4 := mustHasher(t, HasherConfig{Algorithm: AlgorithmBcrypt, BcryptCost: 4})
+	bcrypt5 := mustHasher(t, HasherConfig{Algorithm: AlgorithmBcrypt, BcryptCost: 5})
+	argon := mustHasher(t, fastArgon2)
+
+	stronger := fastArgon2
+	stronger.Argon2Iterations = 2
+	argonStronger := mustHasher(t, stronger)
+
+	bcryptHash, _ := bcrypt4.Hash("MySecureP@ssw0rd")
+	argonHash, _ := argon.Hash("MySecureP@ssw0rd")
+
+	tests := []struct {
+		name   string
+		hasher *PasswordHasher
+		hash   string
+		want   bool
+	}{
+		{name: "same bcrypt cost", hasher: bcrypt4, hash: bcryptHash, want: false},
+		{name: "bcrypt cost raised", hasher: bcrypt5, hash: bcryptHash, want: true},
+		{name: "bcrypt to argon2id", hasher: argon, hash: bcryptHash, want: true},
+		{name: "same argon2id parameters", hasher: argon, hash: argonHash, want: false},
+		{name: "argon2id parameters raised", hasher: argonStronger, hash: argonHash, want: true},
+		{name: "argon2id back to bcrypt", hasher: bcrypt4, hash: argonHash, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.hasher.Verify("MySecureP@ssw0rd", tt.hash)
+			if err != nil {
+				t.Fatalf("Verify failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("needsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsMalformedArgon2id(t *testing.T) {
+	h := mustHasher(t, fastArgon2)
+
+	for _, hash := range []string{
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$aGFzaA",
+	} {
+		if _, err := h.Verify("password", hash); !errors.Is(err, ErrUnsupportedHash) {
+			t.Errorf("Verify(%q) = %v, want ErrUnsupportedHash", hash, err)
+		}
+	}
+}
+
+func TestNewPasswordHasherValidatesConfig(t *testing.T) {
+	bad := []HasherConfig{
+		{Algorithm: "md5"},
+		{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MaxCost + 1},
+		{Algorithm: AlgorithmArgon2id, Argon2Memory: 64, Argon2Iterations: 0, Argon2Parallelism: 1, Argon2SaltLength: 16, Argon2KeyLength: 32},
+		{Algorithm: AlgorithmArgon2id, Argon2Memory: 64, Argon2Iterations: 1, Argon2Parallelism: 1, Argon2SaltLength: 4, Argon2KeyLength: 32},
+	}
+	for _, cfg := range bad {
+		if _, err := NewPasswordHasher(cfg); err == nil {
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestPasswordHasherFromEnv(t *testing.T) {
+	t.Setenv("PASSWORD_HASH_ALGORITHM", "ARGON2ID")
+	t.Setenv("PASSWORD_ARGON2_MEMORY_KIB", "128")
+	t.Setenv("PASSWORD_ARGON2_ITERATIONS", "2")
+
+	h, err := PasswordHasherFromEnv()
+	if err != nil {
+		t.Fatalf("PasswordHasherFromEnv failed: %v", err)
+	}
+	if h.cfg.Algorithm != AlgorithmArgon2id || h.cfg.Argon2Memory != 128 || h.cfg.Argon2Iterations != 2 {
+		t.Errorf("unexpected config: %+v", h.cfg)
+	}
+
+	t.Setenv("PASSWORD_BCRYPT_COST", "lots")
+	if _, err := PasswordHasherFromEnv(); err == nil {
+		t.Error("expected invalid cost to be rejected")
+	}
+}
@@ -18,13 +18,42 @@ ErrNoJWTSecret       = errors.New("JWT_SECRET environment variable not set")
 ErrInvalidSignMethod = errors.New("invalid signing method")
 )
 
+// AccessTokenTTL is the lifetime of an access token. Clients keep a
+// session going by exchanging their refresh token (see RefreshStore).
+const AccessTokenTTL = 15 * time.Minute
+
 // Claims represents the JWT claims structure
+// TokenVersion must match the user's current version for the token to be
+// accepted; bumping the version logs the user out everywhere. The token's
+// unique ID (jti) is used to revoke a single token. Roles and Permissions
+// are copied from the database when the token is issued.
 type Claims struct {
-UserID int `json:"user_id"`
+UserID       int      `json:"user_id"`
+TokenVersion int      `json:"ver"`
+Roles        []string `json:"roles,omitempty"`
+Permissions  []string `json:"perms,omitempty"`
 jwt.RegisteredClaims
 }
 
-// getJWTSecret retrieves the JWT secret from environment
+// HasPermission reports whether the token grants a permission
+func (c *Claims) HasPermission(permission string) bool {
+for _, p := range c.Permissions {
+if p == permission {
+return true
+}
+}
+return false
+}
+
+// Subject describes the user an access token is issued to
+type Subject struct {
+UserID       int
+TokenVersion int
+Roles        []string
+Permissions  []string
+}
+
+// getJWTSecret retrieves the legacy HS256 secret from environment
 func getJWTSecret() ([]byte, error) {
 secret := os.Getenv("JWT_SECRET")
 if secret == "" {
@@ -33,19 +62,36 @@ return nil, ErrNoJWTSecret
 return []byte(secret), nil
 }
 
-// GenerateToken creates a new JWT token for a user
-// The token expires after 24 hours
-func GenerateToken(userID int) (string, error) {
-secret, err := getJWTSecret()
+// GenerateToken creates a new JWT access token for a user at their current
+// token version, with no roles. The token expires after AccessTokenTTL
+func GenerateToken(userID, tokenVersion int) (string, error) {
+return IssueToken(Subject{UserID: userID, TokenVersion: tokenVersion})
+}
+
+// IssueToken creates a new JWT access token carrying the subject's roles
+// and permissions. The token expires after AccessTokenTTL
+func IssueToken(subject Subject) (string, error) {
+userID := subject.UserID
+
+keys, err := CurrentKeySet()
+if err != nil {
+return "", err
+}
+
+jti, err := randomToken(16)
 if err != nil {
 return "", err
 }
 
 // Create claims with user ID and standard claims
 claims := Claims{
-UserID: userID,
+UserID:       userID,
+TokenVersion: subject.TokenVersion,
+Roles:        subject.Roles,
+Permissions:  subject.Permissions,
 RegisteredClaims: jwt.RegisteredClaims{
-ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+ID:        jti,
+ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL)),
 IssuedAt:  jwt.NewNumericDate(time.Now()),
 NotBefore: jwt.NewNumericDate(time.Now()),
 Issuer:    "ioc-labs-ecommerce",
@@ -53,11 +99,8 @@ Subject:   fmt.Sprintf("user-%d", userID),
 },
 }
 
-// Create token with claims
-token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-// Sign the token with secret
-tokenString, err := token.SignedString(secret)
+// Sign the token with the active key
+tokenString, err := keys.Sign(claims)
 if err != nil {
 return "", fmt.Errorf("failed to sign token: %w", err)
 }
@@ -67,19 +110,13 @@ return tokenString, nil
 
 // ValidateToken validates a JWT token and returns the claims if valid
 func ValidateToken(tokenString string) (*Claims, error) {
-secret, err := getJWTSecret()
+keys, err := CurrentKeySet()
 if err != nil {
 return nil, err
 }
 
-// Parse the token
-token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-// Verify signing method
-if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-return nil, fmt.Errorf("%w: %v", ErrInvalidSignMethod, token.Header["alg"])
-}
-return secret, nil
-})
+// Parse the token, verifying it with the key named by its kid header
+token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keys.keyfunc, jwt.WithValidMethods(keys.validMethods()))
 
 if err != nil {
 // Check for specific error types
@@ -101,19 +138,6 @@ return nil, ErrInvalidToken
 return claims, nil
 }
 
-// RefreshToken generates a new token from an existing valid token
-// This extends the expiration time while maintaining the same user ID
-func RefreshToken(oldTokenString string) (string, error) {
-// Validate the old token
-claims, err := ValidateToken(oldTokenString)
-if err != nil {
-return "", fmt.Errorf("cannot refresh invalid token: %w", err)
-}
-
-// Generate new token with same user ID
-return GenerateToken(claims.UserID)
-}
-
 // GetUserIDFromToken extracts just the user ID from a token without full validation
 // This is useful for logging/debugging but should NOT be used for authentication
 func GetUserIDFromToken(tokenString string) (int, error) {
@@ -37,7 +37,7 @@ shouldError: false, // Technically valid, though unusual
 
 for _, tt := range tests {
 t.Run(tt.name, func(t *testing.T) {
-token, err := GenerateToken(tt.userID)
+token, err := GenerateToken(tt.userID, 0)
 
 if tt.shouldError && err == nil {
 t.Error("expected error but got none")
@@ -63,7 +63,7 @@ func TestGenerateTokenWithoutSecret(t *testing.T) {
 // Ensure JWT_SECRET is not set
 os.Unsetenv("JWT_SECRET")
 
-_, err := GenerateToken(1)
+_, err := GenerateToken(1, 0)
 if err != ErrNoJWTSecret {
 t.Errorf("expected ErrNoJWTSecret, got %v", err)
 }
@@ -74,7 +74,7 @@ os.Setenv("JWT_SECRET", "test-secret-key-min-32-characters-long")
 defer os.Unsetenv("JWT_SECRET")
 
 // Generate a valid token
-validToken, err := GenerateToken(123)
+validToken, err := GenerateToken(123, 0)
 if err != nil {
 t.Fatalf("failed to generate test token: %v", err)
 }
@@ -157,63 +157,72 @@ t.Errorf("expected ErrExpiredToken, got %v", err)
 }
 }
 
-func TestRefreshToken(t *testing.T) {
+func TestGetUserIDFromToken(t *testing.T) {
 os.Setenv("JWT_SECRET", "test-secret-key-min-32-characters-long")
 defer os.Unsetenv("JWT_SECRET")
 
-// Generate original token
-originalToken, err := GenerateToken(456)
-if err != nil {
-t.Fatalf("failed to generate test token: %v", err)
-}
-
-// Wait a moment to ensure timestamps differ
-time.Sleep(time.Second)
+token, _ := GenerateToken(789, 0)
 
-// Refresh the token
-newToken, err := RefreshToken(originalToken)
+userID, err := GetUserIDFromToken(token)
 if err != nil {
-t.Errorf("unexpected error refreshing token: %v", err)
+t.Errorf("unexpected error: %v", err)
 }
 
-if newToken == originalToken {
-t.Error("refreshed token should be different from original")
+if userID != 789 {
+t.Errorf("expected user ID 789, got %d", userID)
+}
 }
 
-// Validate new token
-claims, err := ValidateToken(newToken)
+func TestTokenCarriesIDAndVersion(t *testing.T) {
+os.Setenv("JWT_SECRET", "test-secret-key-min-32-characters-long")
+defer os.Unsetenv("JWT_SECRET")
+
+first, _ := GenerateToken(42, 3)
+second, _ := GenerateToken(42, 3)
+
+firstClaims, err := ValidateToken(first)
 if err != nil {
-t.Errorf("refreshed token is invalid: %v", err)
+t.Fatalf("token validation failed: %v", err)
 }
+secondClaims, _ := ValidateToken(second)
 
-if claims.UserID != 456 {
-t.Errorf("expected user ID 456, got %d", claims.UserID)
+if firstClaims.TokenVersion != 3 {
+t.Errorf("expected token version 3, got %d", firstClaims.TokenVersion)
 }
+if firstClaims.ID == "" {
+t.Error("expected token to carry a jti")
 }
-
-func TestRefreshInvalidToken(t *testing.T) {
-os.Setenv("JWT_SECRET", "test-secret-key-min-32-characters-long")
-defer os.Unsetenv("JWT_SECRET")
-
-_, err := RefreshToken("invalid.token.here")
-if err == nil {
-t.Error("expected error when refreshing invalid token")
+if firstClaims.ID == secondClaims.ID {
+t.Error("expected every token to get a unique jti")
 }
 }
 
-func TestGetUserIDFromToken(t *testing.T) {
+func TestTokenCarriesRolesAndPermissions(t *testing.T) {
 os.Setenv("JWT_SECRET", "test-secret-key-min-32-characters-long")
 defer os.Unsetenv("JWT_SECRET")
 
-token, _ := GenerateToken(789)
+token, err := IssueToken(Subject{
+UserID:      5,
+Roles:       []string{"support"},
+Permissions: []string{"admin:access", "orders:refund"},
+})
+if err != nil {
+t.Fatalf("failed to issue token: %v", err)
+}
 
-userID, err := GetUserIDFromToken(token)
+claims, err := ValidateToken(token)
 if err != nil {
-t.Errorf("unexpected error: %v", err)
+t.Fatalf("token validation failed: %v", err)
 }
 
-if userID != 789 {
-t.Errorf("expected user ID 789, got %d", userID)
+if len(claims.Roles) != 1 || claims.Roles[0] != "support" {
+t.Errorf("unexpected roles %v", claims.Roles)
+}
+if !claims.HasPermission("orders:refund") {
+t.Error("expected token to grant orders:refund")
+}
+if claims.HasPermission("users:manage") {
+t.Error("expected token not to grant users:manage")
 }
 }
 
@@ -221,7 +230,7 @@ func TestTokenExpiration(t *testing.T) {
 os.Setenv("JWT_SECRET", "test-secret-key-min-32-characters-long")
 defer os.Unsetenv("JWT_SECRET")
 
-token, err := GenerateToken(123)
+token, err := GenerateToken(123, 0)
 if err != nil {
 t.Fatalf("failed to generate token: %v", err)
 }
@@ -231,9 +240,9 @@ if err != nil {
 t.Fatalf("token validation failed: %v", err)
 }
 
-// Check token expires in approximately 24 hours
+// Check token expires in approximately AccessTokenTTL
 expiresIn := time.Until(claims.ExpiresAt.Time)
-expectedExpiration := 24 * time.Hour
+expectedExpiration := AccessTokenTTL
 
 // Allow 1 minute variance for test execution time
 if expiresIn < expectedExpiration-time.Minute || expiresIn > expectedExpiration+time.Minute {
@@ -248,7 +257,7 @@ defer os.Unsetenv("JWT_SECRET")
 
 b.ResetTimer()
 for i := 0; i < b.N; i++ {
-_, _ = GenerateToken(123)
+_, _ = GenerateToken(123, 0)
 }
 }
 
@@ -257,7 +266,7 @@ func BenchmarkValidateToken(b *testing.B) {
 os.Setenv("JWT_SECRET", "test-secret-key-min-32-characters-long")
 defer os.Unsetenv("JWT_SECRET")
 
-token, _ := GenerateToken(123)
+token, _ := GenerateToken(123, 0)
 
 b.ResetTimer()
 for i := 0; i < b.N; i++ {
@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKeyID  = errors.New("token signed with an unknown key")
+	ErrNoSigningKeys = errors.New("no JWT signing key configured")
+)
+
+// Key is one entry of a KeySet. Keys loaded from a public key file can only
+// verify tokens; they let a retired key keep verifying until its last
+// tokens expire.
+type Key struct {
+	ID        string
+	Algorithm string
+	private   crypto.Signer
+	public    crypto.PublicKey
+}
+
+// CanSign reports whether the key has its private half.
+func (k *Key) CanSign() bool {
+	return k.private != nil
+}
+
+// KeySet holds the keys tokens are signed and verified with.
+//
+// Asymmetric keys (RS256, EdDSA) carry a "kid" header so verifiers can pick
+// the right public key, and several may be active at once during rotation.
+// An HMAC secret (HS256) is the legacy option: it is used for signing only
+// when no asymmetric key is configured, and accepted for verification
+// whenever it is set so tokens issued before a switch keep working.
+type KeySet struct {
+	signing    *Key
+	keys       map[string]*Key
+	hmacSecret []byte
+}
+
+// NewHMACKeySet returns a legacy key set that signs with HS256.
+func NewHMACKeySet(secret []byte) *KeySet {
+	return &KeySet{keys: map[string]*Key{}, hmacSecret: secret}
+}
+
+// LoadKeySet reads every *.pem file in dir. The file name without its
+// extension is the key ID. Private keys (PKCS#1 or PKCS#8, RSA or Ed25519)
+// can sign; public keys (PKIX) only verify. signingKID selects the key new
+// tokens are signed with; if empty, the private key with the greatest ID is
+// used, so date-based IDs such as "2026-10-01" rotate naturally.
+func LoadKeySet(dir, signingKID string, hmacSecret []byte) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{keys: map[string]*Key{}, hmacSecret: hmacSecret}
+	for _, path := range paths {
+		key, err := loadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		ks.keys[key.ID] = key
+	}
+
+	if signingKID == "" {
+		var ids []string
+		for id, key := range ks.keys {
+			if key.CanSign() {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("%w in %s", ErrNoSigningKeys, dir)
+		}
+		sort.Strings(ids)
+		signingKID = ids[len(ids)-1]
+	}
+
+	signing, ok := ks.keys[signingKID]
+	if !ok || !signing.CanSign() {
+		return nil, fmt.Errorf("%w: no private key with ID %q in %s", ErrNoSigningKeys, signingKID, dir)
+	}
+	ks.signing = signing
+	return ks, nil
+}
+
+// LoadKeySetFromEnv builds the key set from the environment.
+//
+//	JWT_KEYS_DIR     directory of <kid>.pem files (RS256/EdDSA)
+//	JWT_SIGNING_KID  key to sign with (optional)
+//	JWT_SECRET       HS256 secret: signs when JWT_KEYS_DIR is unset,
+//	                 otherwise only verifies legacy tokens
+func LoadKeySetFromEnv() (*KeySet, error) {
+	var secret []byte
+	if s := os.Getenv("JWT_SECRET"); s != "" {
+		secret = []byte(s)
+	}
+
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		if secret == nil {
+			return nil, ErrNoJWTSecret
+		}
+		return NewHMACKeySet(secret), nil
+	}
+	return LoadKeySet(dir, os.Getenv("JWT_SIGNING_KID"), secret)
+}
+
+func loadKeyFile(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key %s: %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s is not PEM encoded", path)
+	}
+
+	key := &Key{ID: strings.TrimSuffix(filepath.Base(path), ".pem")}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse key %s: %w", path, err)
+		}
+		key.private = priv
+	case "PRIVATE KEY":
+		priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse key %s: %w", path, err)
+		}
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key %s: unsupported private key type %T", path, priv)
+		}
+		key.private = signer
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse key %s: %w", path, err)
+		}
+		key.public = pub
+	default:
+		return nil, fmt.Errorf("key %s: unsupported PEM block %q", path, block.Type)
+	}
+
+	if key.private != nil {
+		key.public = key.private.Public()
+	}
+
+	switch key.public.(type) {
+	case *rsa.PublicKey:
+		key.Algorithm = jwt.SigningMethodRS256.Alg()
+	case ed25519.PublicKey:
+		key.Algorithm = jwt.SigningMethodEdDSA.Alg()
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", path, key.public)
+	}
+	return key, nil
+}
+
+// Sign signs claims with the active key.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if ks.signing == nil {
+		if ks.hmacSecret == nil {
+			return "", ErrNoSigningKeys
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.hmacSecret)
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(ks.signing.Algorithm), claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.private)
+}
+
+// keyfunc picks the verification key for a parsed token. The key must match
+// both the token's kid and its algorithm, so a public key can never be
+// used as an HMAC secret.
+func (ks *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if ks.hmacSecret == nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSignMethod, token.Header["alg"])
+		}
+		return ks.hmacSecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKeyID, kid)
+	}
+	if key.Algorithm != token.Method.Alg() {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignMethod, token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// validMethods lists the algorithms the key set accepts.
+func (ks *KeySet) validMethods() []string {
+	methods := []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+	if ks.hmacSecret != nil {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	return methods
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns every asymmetric public key, sorted by ID. HMAC secrets are
+// never published.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
+
+var (
+	keySetMu sync.RWMutex
+	keySet   *KeySet
+)
+
+// SetKeySet installs the key set used by GenerateToken and ValidateToken.
+// Until one is set, both fall back to an HS256 key set built from
+// JWT_SECRET on each call.
+func SetKeySet(ks *KeySet) {
+	keySetMu.Lock()
+	defer keySetMu.Unlock()
+	keySet = ks
+}
+
+// CurrentKeySet returns the installed key set, or the legacy JWT_SECRET
+// fallback if none has been installed.
+func CurrentKeySet() (*KeySet, error) {
+	keySetMu.RLock()
+	ks := keySet
+	keySetMu.RUnlock()
+	if ks != nil {
+		return ks, nil
+	}
+
+	secret, err := getJWTSecret()
+	if err != nil {
+		return nil, err
+	}
+	return NewHMACKeySet(secret), nil
+}
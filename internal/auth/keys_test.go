@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+}
+
+// writeTestKeys writes an RSA key "2026-01" and an Ed25519 key "2026-06".
+func writeTestKeys(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	writePEM(t, dir, "2026-01.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("marshal ed25519 key: %v", err)
+	}
+	writePEM(t, dir, "2026-06.pem", "PRIVATE KEY", der)
+
+	return dir
+}
+
+func testClaims() Claims {
+	return Claims{
+		UserID: 7,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "test-jti",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+}
+
+func TestKeySetSignsWithNewestKey(t *testing.T) {
+	dir := writeTestKeys(t)
+	ks, err := LoadKeySet(dir, "", nil)
+	if err != nil {
+		t.Fatalf("load key set: %v", err)
+	}
+	SetKeySet(ks)
+	defer SetKeySet(nil)
+
+	token, err := GenerateToken(7, 0)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	if parsed.Header["kid"] != "2026-06" || parsed.Method.Alg() != "EdDSA" {
+		t.Errorf("expected EdDSA token from 2026-06, got %v %v", parsed.Method.Alg(), parsed.Header["kid"])
+	}
+
+	claims, err := ValidateToken(token)
+	if err != nil || claims.UserID != 7 {
+		t.Errorf("token did not validate: %v", err)
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	dir := writeTestKeys(t)
+
+	old, err := LoadKeySet(dir, "2026-01", nil)
+	if err != nil {
+		t.Fatalf("load key set: %v", err)
+	}
+	oldToken, err := old.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	// After rotation the old key still verifies tokens it signed
+	rotated, err := LoadKeySet(dir, "2026-06", nil)
+	if err != nil {
+		t.Fatalf("load key set: %v", err)
+	}
+	SetKeySet(rotated)
+	defer SetKeySet(nil)
+
+	if _, err := ValidateToken(oldToken); err != nil {
+		t.Errorf("token from previous key should still validate: %v", err)
+	}
+
+	// Once the old key is removed its tokens are rejected
+	os.Remove(filepath.Join(dir, "2026-01.pem"))
+	retired, err := LoadKeySet(dir, "", nil)
+	if err != nil {
+		t.Fatalf("load key set: %v", err)
+	}
+	SetKeySet(retired)
+
+	if _, err := ValidateToken(oldToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected token from removed key to be rejected, got %v", err)
+	}
+}
+
+func TestKeySetPublicKeyOnlyVerifies(t *testing.T) {
+	dir := t.TempDir()
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	pub, _ := x509.MarshalPKIXPublicKey(edKey.Public())
+	writePEM(t, dir, "retired.pem", "PUBLIC KEY", pub)
+
+	if _, err := LoadKeySet(dir, "", nil); !errors.Is(err, ErrNoSigningKeys) {
+		t.Errorf("expected ErrNoSigningKeys, got %v", err)
+	}
+	if _, err := LoadKeySet(dir, "retired", nil); !errors.Is(err, ErrNoSigningKeys) {
+		t.Errorf("expected a public key not to be usable for signing, got %v", err)
+	}
+}
+
+func TestKeySetRejectsHS256WithoutSecret(t *testing.T) {
+	ks, err := LoadKeySet(writeTestKeys(t), "", nil)
+	if err != nil {
+		t.Fatalf("load key set: %v", err)
+	}
+	SetKeySet(ks)
+	defer SetKeySet(nil)
+
+	legacy, _ := NewHMACKeySet([]byte("test-secret-key-min-32-characters-long")).Sign(testClaims())
+	if _, err := ValidateToken(legacy); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected HS256 token to be rejected, got %v", err)
+	}
+}
+
+func TestKeySetAcceptsLegacyHS256(t *testing.T) {
+	secret := []byte("test-secret-key-min-32-characters-long")
+	ks, err := LoadKeySet(writeTestKeys(t), "", secret)
+	if err != nil {
+		t.Fatalf("load key set: %v", err)
+	}
+	SetKeySet(ks)
+	defer SetKeySet(nil)
+
+	legacy, _ := NewHMACKeySet(secret).Sign(testClaims())
+	if _, err := ValidateToken(legacy); err != nil {
+		t.Errorf("expected legacy HS256 token to validate, got %v", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	ks, err := LoadKeySet(writeTestKeys(t), "", []byte("never-published"))
+	if err != nil {
+		t.Fatalf("load key set: %v", err)
+	}
+
+	set := ks.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(set.Keys))
+	}
+
+	rsaJWK, edJWK := set.Keys[0], set.Keys[1]
+	if rsaJWK.KeyID != "2026-01" || rsaJWK.KeyType != "RSA" || rsaJWK.Algorithm != "RS256" || rsaJWK.N == "" || rsaJWK.E != "AQAB" {
+		t.Errorf("unexpected RSA key: %+v", rsaJWK)
+	}
+	if edJWK.KeyID != "2026-06" || edJWK.KeyType != "OKP" || edJWK.Curve != "Ed25519" || edJWK.Algorithm != "EdDSA" || edJWK.X == "" {
+		t.Errorf("unexpected Ed25519 key: %+v", edJWK)
+	}
+}
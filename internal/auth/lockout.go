@@ -0,0 +1,405 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	ErrAccountLocked   = errors.New("account temporarily locked")
+	ErrTooManyAttempts = errors.New("too many failed login attempts")
+)
+
+// LockoutError is returned while logins for an account or IP are blocked.
+// It wraps ErrAccountLocked or ErrTooManyAttempts.
+type LockoutError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", e.Err, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockoutError) Unwrap() error { return e.Err }
+
+// LockoutPolicy controls how failed logins are throttled. After FreeAttempts
+// failures each further failure blocks the account for BaseDelay, doubling
+// up to MaxDelay. At LockAfter failures the account is locked for
+// LockDuration. An IP is blocked for LockDuration after IPLockAfter failures
+// across any accounts. Failures are forgotten after Window without one.
+type LockoutPolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockAfter    int
+	LockDuration time.Duration
+	IPLockAfter  int
+	Window       time.Duration
+}
+
+// DefaultLockoutPolicy is the policy used by the API.
+var DefaultLockoutPolicy = LockoutPolicy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	LockAfter:    10,
+	LockDuration: 15 * time.Minute,
+	IPLockAfter:  50,
+	Window:       time.Hour,
+}
+
+// LoginOutcome describes the effect of a failed login.
+type LoginOutcome struct {
+	Failures int
+	// Locked is true when this failure locked the account. Once an account
+	// reaches LockAfter failures, each failure after the lock expires locks
+	// it again.
+	Locked bool
+	// RetryAfter is how long the account is blocked, if at all.
+	RetryAfter time.Duration
+}
+
+// LoginGuard tracks failed logins per account and per IP. Counters live in
+// Redis so every replica sees them; an in-memory store is used when redis is
+// nil or failing.
+//
+// Check counts each attempt as a failure, and applies the backoff or lockout
+// it would earn, before the password is checked. Concurrent guesses therefore
+// each see the count left by the ones before them and cannot all slip in
+// under the limit. Succeed and Release give the attempt back.
+type LoginGuard struct {
+	policy LockoutPolicy
+	redis  *redis.Client
+	memory *memoryAttempts
+	now    func() time.Time
+}
+
+// NewLoginGuard returns a LoginGuard. redisClient may be nil.
+func NewLoginGuard(redisClient *redis.Client, policy LockoutPolicy) *LoginGuard {
+	return &LoginGuard{
+		policy: policy,
+		redis:  redisClient,
+		memory: newMemoryAttempts(),
+		now:    time.Now,
+	}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// attemptRule is how the failures counted on one key turn into blocks.
+type attemptRule struct {
+	free      int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	lockAfter int
+	lockFor   time.Duration
+}
+
+func (g *LoginGuard) accountRule() attemptRule {
+	p := g.policy
+	return attemptRule{free: p.FreeAttempts, baseDelay: p.BaseDelay, maxDelay: p.MaxDelay, lockAfter: p.LockAfter, lockFor: p.LockDuration}
+}
+
+// ipRule blocks an IP only once it reaches IPLockAfter failures.
+func (g *LoginGuard) ipRule() attemptRule {
+	p := g.policy
+	return attemptRule{free: p.IPLockAfter, lockAfter: p.IPLockAfter, lockFor: p.LockDuration}
+}
+
+// delay is how long a key is blocked after its nth failure.
+func (r attemptRule) delay(n int) time.Duration {
+	switch {
+	case n >= r.lockAfter:
+		return r.lockFor
+	case n > r.free && r.baseDelay > 0:
+		shift := n - r.free - 1
+		if shift > 30 {
+			return r.maxDelay
+		}
+		if delay := r.baseDelay << shift; delay > 0 && delay < r.maxDelay {
+			return delay
+		}
+		return r.maxDelay
+	}
+	return 0
+}
+
+// Check counts a login attempt against the IP and the account, or returns a
+// *LockoutError without counting it if either is blocked. Every attempt that
+// passes must end in Fail, Succeed or Release.
+func (g *LoginGuard) Check(ctx context.Context, email, ip string) error {
+	if ip != "" {
+		if retry, blocked := g.attempt(ctx, ipKey(ip), g.ipRule()); blocked {
+			return &LockoutError{Err: ErrTooManyAttempts, RetryAfter: retry}
+		}
+	}
+	if retry, blocked := g.attempt(ctx, accountKey(email), g.accountRule()); blocked {
+		if ip != "" {
+			g.undo(ctx, ipKey(ip))
+		}
+		return &LockoutError{Err: ErrAccountLocked, RetryAfter: retry}
+	}
+	return nil
+}
+
+// Fail reports the effect of an attempt counted by Check that turned out to
+// be a failed login.
+func (g *LoginGuard) Fail(ctx context.Context, email, ip string) LoginOutcome {
+	now := g.now()
+	n, until := g.state(ctx, accountKey(email))
+	outcome := LoginOutcome{Failures: n}
+	if until.After(now) {
+		outcome.RetryAfter = until.Sub(now)
+		outcome.Locked = n >= g.policy.LockAfter
+	}
+	return outcome
+}
+
+// Succeed clears the account's failures after a successful login and gives
+// the attempt back to the IP. The IP's earlier failures are kept, so one
+// valid account cannot be used to reset them.
+func (g *LoginGuard) Succeed(ctx context.Context, email, ip string) {
+	g.reset(ctx, accountKey(email))
+	if ip != "" {
+		g.undo(ctx, ipKey(ip))
+	}
+}
+
+// Release gives back an attempt that was neither a failure nor a completed
+// login, e.g. a correct password that still needs a second factor.
+func (g *LoginGuard) Release(ctx context.Context, email, ip string) {
+	g.undo(ctx, accountKey(email))
+	if ip != "" {
+		g.undo(ctx, ipKey(ip))
+	}
+}
+
+// Unlock clears an account's failures and any lockout. It reports whether
+// the account was locked or backing off.
+func (g *LoginGuard) Unlock(ctx context.Context, email string) bool {
+	key := accountKey(email)
+	_, until := g.state(ctx, key)
+	g.reset(ctx, key)
+	return until.After(g.now())
+}
+
+// attemptScript counts a failure unless the key is blocked, and blocks the
+// key for as long as that failure earns, all in one step. It returns
+// {blocked, retry_ms}. The failure count that set the block is kept in
+// blocked_by so undoScript can lift it again.
+var attemptScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local free = tonumber(ARGV[3])
+local base = tonumber(ARGV[4])
+local max = tonumber(ARGV[5])
+local lock_after = tonumber(ARGV[6])
+local lock_for = tonumber(ARGV[7])
+
+local until = tonumber(redis.call('HGET', key, 'blocked_until') or '0')
+if until > now then
+	return {1, until - now}
+end
+
+local n = redis.call('HINCRBY', key, 'failures', 1)
+local delay = 0
+if n >= lock_after then
+	delay = lock_for
+elseif n > free and base > 0 then
+	local shift = n - free - 1
+	if shift > 30 then
+		delay = max
+	else
+		delay = math.min(base * 2 ^ shift, max)
+	end
+end
+
+if delay > 0 then
+	redis.call('HSET', key, 'blocked_until', now + delay, 'blocked_by', n)
+	redis.call('PEXPIRE', key, delay + window)
+else
+	redis.call('PEXPIRE', key, window)
+end
+return {0, 0}
+`)
+
+// undoScript takes back one counted failure, lifting the block it set.
+var undoScript = redis.NewScript(`
+local key = KEYS[1]
+if redis.call('EXISTS', key) == 0 then
+	return 0
+end
+local n = redis.call('HINCRBY', key, 'failures', -1)
+if tonumber(redis.call('HGET', key, 'blocked_by') or '-1') == n + 1 then
+	redis.call('HDEL', key, 'blocked_until', 'blocked_by')
+end
+if n <= 0 then
+	redis.call('DEL', key)
+end
+return n
+`)
+
+func (g *LoginGuard) attempt(ctx context.Context, key string, rule attemptRule) (time.Duration, bool) {
+	now := g.now()
+	if g.redis != nil {
+		vals, err := attemptScript.Run(ctx, g.redis, []string{loginAttemptKey(key)},
+			now.UnixMilli(), g.policy.Window.Milliseconds(),
+			rule.free, rule.baseDelay.Milliseconds(), rule.maxDelay.Milliseconds(),
+			rule.lockAfter, rule.lockFor.Milliseconds(),
+		).Int64Slice()
+		if err == nil {
+			return time.Duration(vals[1]) * time.Millisecond, vals[0] == 1
+		}
+	}
+	return g.memory.attempt(key, now, g.policy.Window, rule)
+}
+
+func (g *LoginGuard) undo(ctx context.Context, key string) {
+	if g.redis != nil {
+		if err := undoScript.Run(ctx, g.redis, []string{loginAttemptKey(key)}).Err(); err == nil {
+			return
+		}
+	}
+	g.memory.undo(key, g.now())
+}
+
+func (g *LoginGuard) state(ctx context.Context, key string) (int, time.Time) {
+	if g.redis != nil {
+		vals, err := g.redis.HMGet(ctx, loginAttemptKey(key), "failures", "blocked_until").Result()
+		if err == nil {
+			failures, _ := strconv.Atoi(redisString(vals[0]))
+			until, _ := strconv.ParseInt(redisString(vals[1]), 10, 64)
+			if until == 0 {
+				return failures, time.Time{}
+			}
+			return failures, time.UnixMilli(until)
+		}
+	}
+	return g.memory.state(key, g.now())
+}
+
+func (g *LoginGuard) reset(ctx context.Context, key string) {
+	if g.redis != nil {
+		g.redis.Del(ctx, loginAttemptKey(key))
+	}
+	g.memory.reset(key)
+}
+
+func loginAttemptKey(key string) string {
+	return "auth:login:" + key
+}
+
+func redisString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// memoryAttempts is the in-process fallback for LoginGuard.
+type memoryAttempts struct {
+	mu      sync.Mutex
+	entries map[string]*attemptEntry
+}
+
+type attemptEntry struct {
+	failures     int
+	blockedUntil time.Time
+	blockedBy    int
+	expiresAt    time.Time
+}
+
+func newMemoryAttempts() *memoryAttempts {
+	return &memoryAttempts{entries: make(map[string]*attemptEntry)}
+}
+
+func (m *memoryAttempts) get(key string, now time.Time) *attemptEntry {
+	e, ok := m.entries[key]
+	if !ok || now.After(e.expiresAt) {
+		e = &attemptEntry{}
+		m.entries[key] = e
+	}
+	return e
+}
+
+// attempt is attemptScript for the in-memory store.
+func (m *memoryAttempts) attempt(key string, now time.Time, window time.Duration, rule attemptRule) (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(now)
+	e := m.get(key, now)
+	if e.blockedUntil.After(now) {
+		return e.blockedUntil.Sub(now), true
+	}
+
+	e.failures++
+	e.expiresAt = now.Add(window)
+	if delay := rule.delay(e.failures); delay > 0 {
+		e.blockedUntil = now.Add(delay)
+		e.blockedBy = e.failures
+		e.expiresAt = e.blockedUntil.Add(window)
+	}
+	return 0, false
+}
+
+// undo is undoScript for the in-memory store.
+func (m *memoryAttempts) undo(key string, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok || now.After(e.expiresAt) {
+		return
+	}
+	e.failures--
+	if e.blockedBy == e.failures+1 {
+		e.blockedUntil, e.blockedBy = time.Time{}, 0
+	}
+	if e.failures <= 0 {
+		delete(m.entries, key)
+	}
+}
+
+func (m *memoryAttempts) state(key string, now time.Time) (int, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok || now.After(e.expiresAt) {
+		return 0, time.Time{}
+	}
+	return e.failures, e.blockedUntil
+}
+
+func (m *memoryAttempts) reset(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+}
+
+// sweep drops expired entries once the map grows large, so a stream of
+// failures for random emails cannot grow it without bound.
+func (m *memoryAttempts) sweep(now time.Time) {
+	if len(m.entries) < 10000 {
+		return
+	}
+	for key, e := range m.entries {
+		if now.After(e.expiresAt) {
+			delete(m.entries, key)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testLockoutPolicy = LockoutPolicy{
+	FreeAttempts: 2,
+	BaseDelay:    time.Second,
+	MaxDelay:     4 * time.Second,
+	LockAfter:    6,
+	LockDuration: 15 * time.Minute,
+	IPLockAfter:  10,
+	Window:       time.Hour,
+}
+
+func newTestGuard() (*LoginGuard, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g := NewLoginGuard(nil, testLockoutPolicy)
+	g.now = func() time.Time { return now }
+	return g, &now
+}
+
+// guess makes one failed login attempt, which Check must let through.
+func guess(t *testing.T, g *LoginGuard, email, ip string) LoginOutcome {
+	t.Helper()
+	ctx := context.Background()
+	if err := g.Check(ctx, email, ip); err != nil {
+		t.Fatalf("attempt for %s rejected: %v", email, err)
+	}
+	return g.Fail(ctx, email, ip)
+}
+
+func TestLoginGuardBackoff(t *testing.T) {
+	g, now := newTestGuard()
+
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second}
+	for i, delay := range want {
+		outcome := guess(t, g, "user@example.com", "")
+		if outcome.Failures != i+1 {
+			t.Fatalf("failure %d: Failures = %d", i+1, outcome.Failures)
+		}
+		if outcome.RetryAfter != delay {
+			t.Errorf("failure %d: RetryAfter = %v, want %v", i+1, outcome.RetryAfter, delay)
+		}
+		if outcome.Locked {
+			t.Errorf("failure %d: account locked too early", i+1)
+		}
+		*now = now.Add(outcome.RetryAfter)
+	}
+}
+
+func TestLoginGuardCheckHonoursBackoff(t *testing.T) {
+	ctx := context.Background()
+	g, now := newTestGuard()
+
+	for i := 0; i < 3; i++ {
+		guess(t, g, "user@example.com", "")
+	}
+
+	err := g.Check(ctx, "USER@example.com", "")
+	var lockout *LockoutError
+	if !errors.As(err, &lockout) || !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("expected account lockout, got %v", err)
+	}
+	if lockout.RetryAfter != time.Second {
+		t.Errorf("RetryAfter = %v, want 1s", lockout.RetryAfter)
+	}
+
+	*now = now.Add(time.Second)
+	if err := g.Check(ctx, "user@example.com", ""); err != nil {
+		t.Errorf("expected backoff to have expired, got %v", err)
+	}
+}
+
+func TestLoginGuardCountsConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestGuard()
+
+	// Guesses that all arrive before any of them fails are counted as they
+	// pass Check, so the ones past the free attempts are turned away
+	admitted := 0
+	for i := 0; i < 5; i++ {
+		if g.Check(ctx, "user@example.com", "203.0.113.7") == nil {
+			admitted++
+		}
+	}
+	if admitted != testLockoutPolicy.FreeAttempts+1 {
+		t.Errorf("admitted %d concurrent guesses, want %d", admitted, testLockoutPolicy.FreeAttempts+1)
+	}
+}
+
+func TestLoginGuardLocksAccount(t *testing.T) {
+	ctx := context.Background()
+	g, now := newTestGuard()
+
+	var outcome LoginOutcome
+	for i := 0; i < testLockoutPolicy.LockAfter; i++ {
+		// Wait out each backoff before guessing again
+		*now = now.Add(outcome.RetryAfter)
+		outcome = guess(t, g, "user@example.com", "")
+	}
+	if !outcome.Locked || outcome.RetryAfter != testLockoutPolicy.LockDuration {
+		t.Fatalf("expected lockout, got %+v", outcome)
+	}
+
+	// A failure after the lock expires locks the account straight away
+	*now = now.Add(testLockoutPolicy.LockDuration)
+	if outcome := guess(t, g, "user@example.com", ""); !outcome.Locked {
+		t.Errorf("expected relock, got %+v", outcome)
+	}
+
+	if !g.Unlock(ctx, "user@example.com") {
+		t.Error("expected Unlock to report a locked account")
+	}
+	if err := g.Check(ctx, "user@example.com", ""); err != nil {
+		t.Errorf("expected unlocked account, got %v", err)
+	}
+	if g.Unlock(ctx, "user@example.com") {
+		t.Error("expected Unlock to report an unlocked account")
+	}
+}
+
+func TestLoginGuardSucceedResetsAccountOnly(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestGuard()
+
+	for i := 0; i < 2; i++ {
+		guess(t, g, "user@example.com", "203.0.113.7")
+	}
+	if err := g.Check(ctx, "user@example.com", "203.0.113.7"); err != nil {
+		t.Fatalf("attempt rejected: %v", err)
+	}
+	g.Succeed(ctx, "user@example.com", "203.0.113.7")
+
+	if outcome := guess(t, g, "user@example.com", "203.0.113.7"); outcome.Failures != 1 {
+		t.Errorf("expected account failures to restart, got %d", outcome.Failures)
+	}
+	if n, _ := g.memory.state(ipKey("203.0.113.7"), g.now()); n != 3 {
+		t.Errorf("expected IP failures to be kept, got %d", n)
+	}
+}
+
+func TestLoginGuardReleaseLiftsBackoff(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestGuard()
+
+	for i := 0; i < testLockoutPolicy.FreeAttempts; i++ {
+		guess(t, g, "user@example.com", "")
+	}
+
+	// The right password for an account with 2FA: the attempt that would
+	// have started the backoff is given back
+	if err := g.Check(ctx, "user@example.com", ""); err != nil {
+		t.Fatalf("attempt rejected: %v", err)
+	}
+	g.Release(ctx, "user@example.com", "")
+
+	if err := g.Check(ctx, "user@example.com", ""); err != nil {
+		t.Errorf("expected no backoff after release, got %v", err)
+	}
+	if n, _ := g.memory.state(accountKey("user@example.com"), g.now()); n != testLockoutPolicy.FreeAttempts+1 {
+		t.Errorf("expected earlier failures to be kept, got %d", n)
+	}
+}
+
+func TestLoginGuardBlocksIP(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestGuard()
+
+	// Spread failures over many accounts so no single account backs off
+	for i := 0; i < testLockoutPolicy.IPLockAfter; i++ {
+		guess(t, g, string(rune('a'+i))+"@example.com", "203.0.113.7")
+	}
+
+	err := g.Check(ctx, "new@example.com", "203.0.113.7")
+	if !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("expected IP block, got %v", err)
+	}
+	if err := g.Check(ctx, "new@example.com", "198.51.100.1"); err != nil {
+		t.Errorf("expected other IPs to be allowed, got %v", err)
+	}
+}
+
+func TestLoginGuardForgetsOldFailures(t *testing.T) {
+	g, now := newTestGuard()
+
+	guess(t, g, "user@example.com", "")
+	guess(t, g, "user@example.com", "")
+
+	*now = now.Add(testLockoutPolicy.Window + time.Second)
+	if outcome := guess(t, g, "user@example.com", ""); outcome.Failures != 1 {
+		t.Errorf("expected failures outside the window to be forgotten, got %d", outcome.Failures)
+	}
+}
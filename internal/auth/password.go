@@ -3,55 +3,29 @@ package auth
 
 import (
 	"errors"
-	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/validator"
 )
 
 // ErrInvalidPassword is returned when password verification fails
 var ErrInvalidPassword = errors.New("invalid password")
 
-// HashPassword generates a bcrypt hash of the password
-// Uses bcrypt.DefaultCost (currently 10) which provides a good balance
-// between security and performance
+// HashPassword hashes a password with the installed PasswordHasher
+// (bcrypt at bcrypt.DefaultCost unless configured otherwise)
 func HashPassword(password string) (string, error) {
-	// Validate password is not empty
-	if password == "" {
-		return "", errors.New("password cannot be empty")
-	}
-
-	// bcrypt handles salting automatically
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	
-	return string(bytes), nil
+	return CurrentPasswordHasher().Hash(password)
 }
 
-// CheckPasswordHash compares a password with its bcrypt hash
-// Returns nil if the password matches the hash, ErrInvalidPassword otherwise
-func CheckPasswordHash(password, hash string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return ErrInvalidPassword
-		}
-		return err
-	}
-	return nil
+// CheckPasswordHash compares a password with its hash
+// Returns ErrInvalidPassword if the password does not match. needsRehash
+// reports that the password matched but the hash uses an outdated
+// algorithm or parameters and should be replaced with HashPassword
+func CheckPasswordHash(password, hash string) (needsRehash bool, err error) {
+	return CurrentPasswordHasher().Verify(password, hash)
 }
 
-// ValidatePasswordStrength performs basic password strength validation
-// This is a simple implementation - enhance based on your requirements
-func ValidatePasswordStrength(password string) error {
-	if len(password) < 8 {
-		return errors.New("password must be at least 8 characters long")
-	}
-	
-	// You can add more validation rules here:
-	// - Contains uppercase letter
-	// - Contains lowercase letter
-	// - Contains number
-	// - Contains special character
-	
-	return nil
+// ValidatePasswordStrength checks a new password against the installed
+// PasswordPolicy and returns every rule it breaks, or nil if it is acceptable
+func ValidatePasswordStrength(password string, user PasswordContext) validator.ValidationErrors {
+	return CurrentPasswordPolicy().Validate(password, user)
 }
@@ -93,7 +93,7 @@ func TestCheckPasswordHash(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := CheckPasswordHash(tt.password, tt.hash)
+			_, err := CheckPasswordHash(tt.password, tt.hash)
 			
 			if tt.shouldError && err == nil {
 				t.Error("expected error but got none")
@@ -124,14 +124,19 @@ func TestValidatePasswordStrength(t *testing.T) {
 		},
 		{
 			name:        "exactly 8 characters",
-			password:    "12345678",
+			password:    "7rq!vb2k",
 			shouldError: false,
 		},
+		{
+			name:        "common password",
+			password:    "12345678",
+			shouldError: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := ValidatePasswordStrength(tt.password)
+			err := ValidatePasswordStrength(tt.password, PasswordContext{})
 			
 			if tt.shouldError && err == nil {
 				t.Error("expected error but got none")
@@ -159,6 +164,6 @@ func BenchmarkCheckPasswordHash(b *testing.B) {
 	
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = CheckPasswordHash(password, hash)
+		_, _ = CheckPasswordHash(password, hash)
 	}
 }
@@ -0,0 +1,384 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/validator"
+)
+
+//go:embed common-passwords.txt
+var commonPasswordList string
+
+// bcryptMaxBytes is the most bcrypt will hash; longer passwords are rejected
+// by bcrypt rather than silently truncated, so the policy stops them first.
+const bcryptMaxBytes = 72
+
+// PasswordPolicy decides whether a new password is acceptable. Lengths are
+// counted in runes, so multi-byte characters count once, but a password can
+// never exceed MaxBytes bytes.
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	MaxBytes  int
+
+	// Common holds lower-cased passwords that are always rejected.
+	Common map[string]struct{}
+	// Breached, if set, is checked for the password's SHA-1 hash.
+	Breached BreachedPasswords
+}
+
+// PasswordContext holds what is known about the account, so passwords made
+// from the user's own details can be rejected.
+type PasswordContext struct {
+	Email     string
+	FirstName string
+	LastName  string
+}
+
+// BreachedPasswords looks up breached passwords by SHA-1 hash the way the
+// Have I Been Pwned range API does: the caller only reveals the first five
+// hex characters of the hash and gets back the suffixes of every breached
+// hash with that prefix.
+type BreachedPasswords interface {
+	Range(prefix string) []string
+}
+
+// DefaultPasswordPolicy returns the policy used when none is configured.
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength: 8,
+		MaxLength: 64,
+		MaxBytes:  bcryptMaxBytes,
+		Common:    parseCommonPasswords(commonPasswordList),
+	}
+}
+
+// PasswordPolicyFromEnv builds on DefaultPasswordPolicy with
+// PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH and BREACHED_PASSWORDS_FILE, a
+// file of SHA-1 hashes sorted by hash, as in the Have I Been Pwned "ordered
+// by hash" download. The file is searched in place, not loaded.
+func PasswordPolicyFromEnv() (PasswordPolicy, error) {
+	p := DefaultPasswordPolicy()
+
+	for name, dst := range map[string]*int{
+		"PASSWORD_MIN_LENGTH": &p.MinLength,
+		"PASSWORD_MAX_LENGTH": &p.MaxLength,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				return p, fmt.Errorf("invalid %s: %q", name, raw)
+			}
+			*dst = n
+		}
+	}
+	if p.MinLength > p.MaxLength {
+		return p, fmt.Errorf("PASSWORD_MIN_LENGTH (%d) is greater than PASSWORD_MAX_LENGTH (%d)", p.MinLength, p.MaxLength)
+	}
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := OpenBreachedPasswordFile(path)
+		if err != nil {
+			return p, err
+		}
+		p.Breached = breached
+	}
+	return p, nil
+}
+
+// Validate returns every rule the password breaks, or nil if it is
+// acceptable.
+func (p PasswordPolicy) Validate(password string, user PasswordContext) validator.ValidationErrors {
+	v := validator.New()
+
+	runes := utf8.RuneCountInString(password)
+	if runes < p.MinLength {
+		v.AddError("password", fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && runes > p.MaxLength {
+		v.AddError("password", fmt.Sprintf("must not exceed %d characters", p.MaxLength))
+	} else if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		v.AddError("password", fmt.Sprintf("must not exceed %d bytes; use fewer non-ASCII characters", p.MaxBytes))
+	}
+
+	lower := strings.ToLower(password)
+	if _, ok := p.Common[lower]; ok {
+		v.AddError("password", "is too common")
+	} else if p.Breached != nil && isBreached(p.Breached, password) {
+		v.AddError("password", "has appeared in a data breach")
+	}
+
+	if containsEmail(lower, user.Email) {
+		v.AddError("password", "must not contain your email address")
+	}
+	if containsName(lower, user.FirstName, user.LastName) {
+		v.AddError("password", "must not contain your name")
+	}
+
+	if v.IsValid() {
+		return nil
+	}
+	return v.Errors()
+}
+
+func isBreached(b BreachedPasswords, password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	for _, suffix := range b.Range(hash[:5]) {
+		if suffix == hash[5:] {
+			return true
+		}
+	}
+	return false
+}
+
+// containsEmail checks for the whole address and for its local part.
+// Local parts shorter than 3 characters are too likely to match by chance.
+func containsEmail(lowerPassword, email string) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+	if strings.Contains(lowerPassword, email) {
+		return true
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return utf8.RuneCountInString(local) >= 3 && strings.Contains(lowerPassword, local)
+}
+
+func containsName(lowerPassword string, names ...string) bool {
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if utf8.RuneCountInString(name) >= 3 && strings.Contains(lowerPassword, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCommonPasswords(list string) map[string]struct{} {
+	common := make(map[string]struct{})
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		common[strings.ToLower(line)] = struct{}{}
+	}
+	return common
+}
+
+// BreachedPasswordSet is an in-memory BreachedPasswords loaded from a file.
+// It takes roughly 100 bytes per hash, so it suits lists of up to a few
+// million hashes; use BreachedPasswordFile for a full Have I Been Pwned
+// corpus.
+type BreachedPasswordSet struct {
+	ranges map[string][]string
+}
+
+// LoadBreachedPasswords reads upper- or lower-case SHA-1 hex hashes, one
+// per line, optionally followed by ":count" as in the Have I Been Pwned
+// downloads. Blank lines and lines starting with # are skipped.
+func LoadBreachedPasswords(r io.Reader) (*BreachedPasswordSet, error) {
+	set := &BreachedPasswordSet{ranges: make(map[string][]string)}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != 40 {
+			return nil, fmt.Errorf("breached password file line %d: not a SHA-1 hash", line)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("breached password file line %d: not a SHA-1 hash", line)
+		}
+		set.ranges[hash[:5]] = append(set.ranges[hash[:5]], hash[5:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password file: %w", err)
+	}
+	return set, nil
+}
+
+// Range returns the hash suffixes stored under a 5-character prefix.
+func (s *BreachedPasswordSet) Range(prefix string) []string {
+	return s.ranges[strings.ToUpper(prefix)]
+}
+
+// BreachedPasswordFile is a BreachedPasswords backed by a file of SHA-1
+// hashes sorted by hash, one per line, optionally followed by ":count".
+// Each Range is a binary search over the file, so memory use does not grow
+// with the file and the full Have I Been Pwned download (over 30 GB) costs
+// a few dozen small reads per lookup. The file must not contain blank lines
+// or comments, which would break the ordering.
+type BreachedPasswordFile struct {
+	r    io.ReaderAt
+	size int64
+}
+
+// breachedLineMax bounds a line of a breached password file: a 40-character
+// hash, a count and a line ending.
+const breachedLineMax = 128
+
+// OpenBreachedPasswordFile opens a sorted breached password file. The file
+// stays open for the life of the process.
+func OpenBreachedPasswordFile(path string) (*BreachedPasswordFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open breached password file: %w", err)
+	}
+
+	b := NewBreachedPasswordFile(f, info.Size())
+	if b.size > 0 {
+		line, err := b.lineAt(0)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read breached password file: %w", err)
+		}
+		if hash := breachedHash(line); len(hash) != 40 || !isHex(hash) {
+			f.Close()
+			return nil, fmt.Errorf("breached password file line 1: not a SHA-1 hash")
+		}
+	}
+	return b, nil
+}
+
+// NewBreachedPasswordFile searches the first size bytes of r, which must
+// hold sorted hashes as described on BreachedPasswordFile.
+func NewBreachedPasswordFile(r io.ReaderAt, size int64) *BreachedPasswordFile {
+	return &BreachedPasswordFile{r: r, size: size}
+}
+
+// Range returns the hash suffixes stored under a 5-character prefix. A read
+// error is treated as no match.
+func (b *BreachedPasswordFile) Range(prefix string) []string {
+	prefix = strings.ToUpper(prefix)
+
+	// Find the smallest offset whose next line sorts at or after prefix
+	lo, hi := int64(0), b.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := b.nextLineStart(mid)
+		if err != nil {
+			return nil
+		}
+		if start >= b.size {
+			hi = mid
+			continue
+		}
+		line, err := b.lineAt(start)
+		if err != nil {
+			return nil
+		}
+		if breachedHash(line) >= prefix {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	start, err := b.nextLineStart(lo)
+	if err != nil {
+		return nil
+	}
+	scanner := bufio.NewScanner(io.NewSectionReader(b.r, start, b.size-start))
+	var suffixes []string
+	for scanner.Scan() {
+		hash := breachedHash(scanner.Text())
+		if !strings.HasPrefix(hash, prefix) {
+			break
+		}
+		suffixes = append(suffixes, hash[5:])
+	}
+	return suffixes
+}
+
+// nextLineStart returns the offset of the first line starting at or after
+// off, or the file size if there is none.
+func (b *BreachedPasswordFile) nextLineStart(off int64) (int64, error) {
+	if off == 0 {
+		return 0, nil
+	}
+	buf := make([]byte, breachedLineMax)
+	for pos := off - 1; pos < b.size; pos += int64(len(buf)) {
+		n, err := b.r.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+	return b.size, nil
+}
+
+// lineAt returns the line starting at off, without its line ending.
+func (b *BreachedPasswordFile) lineAt(off int64) (string, error) {
+	buf := make([]byte, breachedLineMax)
+	n, err := b.r.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	line, _, _ := bytes.Cut(buf[:n], []byte("\n"))
+	return string(line), nil
+}
+
+// breachedHash returns the upper-cased hash of a breached password line.
+func breachedHash(line string) string {
+	hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return strings.ToUpper(hash)
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+var (
+	passwordPolicyMu  sync.RWMutex
+	passwordPolicy    *PasswordPolicy
+	defaultPolicyOnce sync.Once
+	defaultPolicy     PasswordPolicy
+)
+
+// SetPasswordPolicy installs the policy used by ValidatePasswordStrength.
+// Until one is set, DefaultPasswordPolicy is used.
+func SetPasswordPolicy(p PasswordPolicy) {
+	passwordPolicyMu.Lock()
+	defer passwordPolicyMu.Unlock()
+	passwordPolicy = &p
+}
+
+// CurrentPasswordPolicy returns the installed policy, or the default.
+func CurrentPasswordPolicy() PasswordPolicy {
+	passwordPolicyMu.RLock()
+	p := passwordPolicy
+	passwordPolicyMu.RUnlock()
+	if p != nil {
+		return *p
+	}
+	defaultPolicyOnce.Do(func() { defaultPolicy = DefaultPasswordPolicy() })
+	return defaultPolicy
+}
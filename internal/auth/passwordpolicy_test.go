@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+	user := PasswordContext{Email: "Jane.Doe@example.com", FirstName: "Jane", LastName: "Doe"}
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{name: "acceptable", password: "correct horse battery", want: nil},
+		{name: "too short", password: "x7!kq", want: []string{"must be at least 8 characters long"}},
+		{name: "length counts runes", password: "ééééééé", want: []string{"must be at least 8 characters long"}},
+		{name: "multi-byte password long enough", password: "пароль-надёжный", want: nil},
+		{name: "too many runes", password: strings.Repeat("a1", 33), want: []string{"must not exceed 64 characters"}},
+		{name: "over the bcrypt byte limit", password: strings.Repeat("日", 25), want: []string{"must not exceed 72 bytes; use fewer non-ASCII characters"}},
+		{name: "common password", password: "Password123", want: []string{"is too common"}},
+		{name: "contains email local part", password: "xx-jane.doe-xx", want: []string{
+			"must not contain your email address",
+			"must not contain your name",
+		}},
+		{name: "contains name", password: "ilovejane2024", want: []string{"must not contain your name"}},
+		{name: "several problems at once", password: "jane", want: []string{
+			"must be at least 8 characters long",
+			"must not contain your name",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := policy.Validate(tt.password, user)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("got %v, want %v", errs, tt.want)
+			}
+			for i, e := range errs {
+				if e.Field != "password" || e.Message != tt.want[i] {
+					t.Errorf("error %d = %s: %s, want password: %s", i, e.Field, e.Message, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyShortNamesIgnored(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+	user := PasswordContext{Email: "al@example.com", FirstName: "Al", LastName: "Li"}
+
+	if errs := policy.Validate("always-alive-7", user); errs != nil {
+		t.Errorf("expected short names not to be matched, got %v", errs)
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	// An unrelated hash, then two breached passwords in both cases
+	file := `# sample
+E6F79D3FF0F8B7CD46D8E5E0F3B8C8B4B6E77D0D:3
+` + sha1Hex("correct horse battery") + `:12
+
+` + strings.ToLower(sha1Hex("monkey-business-42")) + `
+`
+
+	set, err := LoadBreachedPasswords(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("LoadBreachedPasswords failed: %v", err)
+	}
+
+	policy := DefaultPasswordPolicy()
+	policy.Breached = set
+
+	for _, pw := range []string{"correct horse battery", "monkey-business-42"} {
+		errs := policy.Validate(pw, PasswordContext{})
+		if len(errs) != 1 || errs[0].Message != "has appeared in a data breach" {
+			t.Errorf("expected %q to be reported as breached, got %v", pw, errs)
+		}
+	}
+	if errs := policy.Validate("not in the corpus 9", PasswordContext{}); errs != nil {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	if got := set.Range(sha1Hex("correct horse battery")[:5]); len(got) == 0 {
+		t.Error("expected Range to return suffixes for a known prefix")
+	}
+}
+
+func TestLoadBreachedPasswordsRejectsGarbage(t *testing.T) {
+	if _, err := LoadBreachedPasswords(strings.NewReader("not-a-hash\n")); err == nil {
+		t.Error("expected an error for a malformed line")
+	}
+}
+
+func TestBreachedPasswordFile(t *testing.T) {
+	// Enough sorted hashes that the binary search takes several steps, in
+	// the HIBP download format with counts and CRLF line endings
+	var hashes []string
+	for i := 0; i < 500; i++ {
+		hashes = append(hashes, sha1Hex(fmt.Sprintf("password-%d", i)))
+	}
+	sort.Strings(hashes)
+	var file strings.Builder
+	for i, h := range hashes {
+		fmt.Fprintf(&file, "%s:%d\r\n", h, i+1)
+	}
+
+	b := NewBreachedPasswordFile(strings.NewReader(file.String()), int64(file.Len()))
+	for _, h := range []string{hashes[0], hashes[250], hashes[499]} {
+		got := b.Range(strings.ToLower(h[:5]))
+		found := false
+		for _, suffix := range got {
+			found = found || suffix == h[5:]
+		}
+		if !found {
+			t.Errorf("expected Range(%s) to contain %s, got %v", h[:5], h[5:], got)
+		}
+	}
+
+	policy := DefaultPasswordPolicy()
+	policy.Breached = b
+	if errs := policy.Validate("password-123", PasswordContext{}); len(errs) != 1 || errs[0].Message != "has appeared in a data breach" {
+		t.Errorf("expected password-123 to be reported as breached, got %v", errs)
+	}
+	if errs := policy.Validate("not in the corpus 9", PasswordContext{}); errs != nil {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	empty := NewBreachedPasswordFile(strings.NewReader(""), 0)
+	if got := empty.Range(hashes[0][:5]); got != nil {
+		t.Errorf("expected no suffixes from an empty file, got %v", got)
+	}
+}
+
+func TestOpenBreachedPasswordFile(t *testing.T) {
+	dir := t.TempDir()
+
+	good := filepath.Join(dir, "good.txt")
+	if err := os.WriteFile(good, []byte(sha1Hex("correct horse battery")+":12\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BREACHED_PASSWORDS_FILE", good)
+	p, err := PasswordPolicyFromEnv()
+	if err != nil {
+		t.Fatalf("PasswordPolicyFromEnv failed: %v", err)
+	}
+	if errs := p.Validate("correct horse battery", PasswordContext{}); len(errs) != 1 {
+		t.Errorf("expected the password to be reported as breached, got %v", errs)
+	}
+
+	bad := filepath.Join(dir, "bad.txt")
+	if err := os.WriteFile(bad, []byte("# comment\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenBreachedPasswordFile(bad); err == nil {
+		t.Error("expected an error for a file that does not start with a hash")
+	}
+}
+
+func TestPasswordPolicyFromEnv(t *testing.T) {
+	t.Setenv("PASSWORD_MIN_LENGTH", "12")
+	t.Setenv("PASSWORD_MAX_LENGTH", "40")
+
+	p, err := PasswordPolicyFromEnv()
+	if err != nil {
+		t.Fatalf("PasswordPolicyFromEnv failed: %v", err)
+	}
+	if p.MinLength != 12 || p.MaxLength != 40 || p.MaxBytes != bcryptMaxBytes {
+		t.Errorf("unexpected policy: min %d max %d bytes %d", p.MinLength, p.MaxLength, p.MaxBytes)
+	}
+
+	t.Setenv("PASSWORD_MIN_LENGTH", "50")
+	if _, err := PasswordPolicyFromEnv(); err == nil {
+		t.Error("expected min > max to be rejected")
+	}
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RefreshTokenTTL is how long a refresh token can be exchanged for a new
+// token pair. Each exchange issues a new refresh token with a fresh TTL.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+// RefreshStore issues, rotates and revokes opaque refresh tokens. Only a
+// SHA-256 hash of each token is stored. Tokens issued by rotating one
+// another share a family; presenting a token that has already been rotated
+// means it was copied, so the whole family is revoked.
+type RefreshStore struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+func NewRefreshStore(db *sql.DB) *RefreshStore {
+	return &RefreshStore{db: db, now: time.Now}
+}
+
+// Issue starts a new token family for a user, e.g. at login.
+func (s *RefreshStore) Issue(ctx context.Context, userID int) (string, time.Time, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return s.insert(ctx, s.db, userID, familyID)
+}
+
+// Rotate exchanges a refresh token for a new one in the same family and
+// returns the user it belongs to. Reusing a rotated token revokes every
+// token in its family and returns ErrRefreshTokenReused.
+func (s *RefreshStore) Rotate(ctx context.Context, token string) (int, string, time.Time, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", time.Time{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		id        int64
+		userID    int
+		familyID  string
+		expiresAt time.Time
+		usedAt    sql.NullTime
+		revokedAt sql.NullTime
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, user_id, family_id, expires_at, used_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`, HashToken(token)).Scan(&id, &userID, &familyID, &expiresAt, &usedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return 0, "", time.Time{}, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return 0, "", time.Time{}, fmt.Errorf("failed to load refresh token: %w", err)
+	}
+
+	if revokedAt.Valid || !s.now().Before(expiresAt) {
+		return 0, "", time.Time{}, ErrRefreshTokenInvalid
+	}
+
+	if usedAt.Valid {
+		if err := revokeFamily(ctx, tx, familyID); err != nil {
+			return 0, "", time.Time{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, "", time.Time{}, fmt.Errorf("failed to revoke token family: %w", err)
+		}
+		return userID, "", time.Time{}, ErrRefreshTokenReused
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1", id,
+	); err != nil {
+		return 0, "", time.Time{}, fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+
+	next, nextExpiresAt, err := s.insert(ctx, tx, userID, familyID)
+	if err != nil {
+		return 0, "", time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, "", time.Time{}, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	return userID, next, nextExpiresAt, nil
+}
+
+// Revoke ends the session a refresh token belongs to, e.g. at logout.
+// Unknown tokens are ignored so logout never fails.
+func (s *RefreshStore) Revoke(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE revoked_at IS NULL
+			AND family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1)
+	`, HashToken(token))
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+	return nil
+}
+
+// RevokeUser ends every session a user has.
+func (s *RefreshStore) RevokeUser(ctx context.Context, userID int) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL",
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (s *RefreshStore) insert(ctx context.Context, db execer, userID int, familyID string) (string, time.Time, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := s.now().Add(RefreshTokenTTL)
+
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, familyID, HashToken(token), expiresAt); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+func revokeFamily(ctx context.Context, tx *sql.Tx, familyID string) error {
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL",
+		familyID,
+	); err != nil {
+		return fmt.Errorf("failed to revoke token family: %w", err)
+	}
+	return nil
+}
+
+// randomToken returns n random bytes encoded as URL-safe base64.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex SHA-256 of an opaque token, the form in which
+// tokens are stored and looked up.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRandomTokenIsUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		token, err := randomToken(32)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(token) != 43 {
+			t.Errorf("expected 43 characters for 32 bytes, got %d", len(token))
+		}
+		if seen[token] {
+			t.Fatalf("duplicate token %s", token)
+		}
+		seen[token] = true
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	token, _ := randomToken(32)
+
+	hash := HashToken(token)
+	if len(hash) != 64 {
+		t.Errorf("expected 64 hex characters, got %d", len(hash))
+	}
+	if hash != HashToken(token) {
+		t.Error("expected hashing to be deterministic")
+	}
+	if strings.Contains(hash, token) {
+		t.Error("hash must not contain the token")
+	}
+
+	other, _ := randomToken(32)
+	if HashToken(other) == hash {
+		t.Error("expected different tokens to hash differently")
+	}
+}
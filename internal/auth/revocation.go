@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// tokenVersionCacheTTL bounds how long a cached token version is trusted
+// without going back to Postgres.
+const tokenVersionCacheTTL = time.Hour
+
+// Revoker decides whether a signed access token is still allowed. A token
+// is rejected if its jti is on the denylist or its version is older than the
+// user's current token version.
+//
+// Denylist entries are written to Postgres and Redis, and revoking fails if
+// either write fails. While Redis answers, it alone is consulted, so
+// checking a token that is not revoked costs no query; Postgres is read only
+// when Redis is not configured or returns an error. Entries lost from Redis
+// (an eviction, or a restart without persistence) are therefore not seen
+// until Redis errors, so its eviction policy must not drop auth:denylist:*
+// keys. Token versions are cached and only ever move forward, and a cache
+// miss goes to Postgres.
+type Revoker struct {
+	db    *sql.DB
+	redis *redis.Client
+}
+
+// NewRevoker returns a Revoker. redisClient may be nil.
+func NewRevoker(db *sql.DB, redisClient *redis.Client) *Revoker {
+	return &Revoker{db: db, redis: redisClient}
+}
+
+func denylistKey(jti string) string {
+	return "auth:denylist:" + jti
+}
+
+func tokenVersionKey(userID int) string {
+	return fmt.Sprintf("auth:token_version:%d", userID)
+}
+
+// Check returns ErrTokenRevoked if the token has been revoked, or another
+// error if revocation state could not be read.
+func (r *Revoker) Check(ctx context.Context, claims *Claims) error {
+	denied, err := r.isDenied(ctx, claims.ID)
+	if err != nil {
+		return err
+	}
+	if denied {
+		return ErrTokenRevoked
+	}
+
+	version, err := r.TokenVersion(ctx, claims.UserID)
+	if err != nil {
+		return err
+	}
+	if claims.TokenVersion < version {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+func (r *Revoker) isDenied(ctx context.Context, jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+
+	// Redis holds every entry, so while it answers its answer is final
+	if r.redis != nil {
+		n, err := r.redis.Exists(ctx, denylistKey(jti)).Result()
+		if err == nil {
+			return n > 0, nil
+		}
+	}
+
+	var denied bool
+	if err := r.db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti = $1)", jti,
+	).Scan(&denied); err != nil {
+		return false, fmt.Errorf("failed to check token denylist: %w", err)
+	}
+	return denied, nil
+}
+
+// TokenVersion returns the user's current token version. New tokens must be
+// issued at this version.
+func (r *Revoker) TokenVersion(ctx context.Context, userID int) (int, error) {
+	if r.redis != nil {
+		if v, err := r.redis.Get(ctx, tokenVersionKey(userID)).Int(); err == nil {
+			return v, nil
+		}
+	}
+
+	var version int
+	err := r.db.QueryRowContext(ctx,
+		"SELECT token_version FROM users WHERE id = $1", userID,
+	).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, ErrTokenRevoked
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load token version: %w", err)
+	}
+
+	// Best effort: the script never lowers a cached version, so a racing
+	// RevokeAll cannot be undone by this read
+	r.cacheVersion(ctx, userID, version)
+	return version, nil
+}
+
+// RevokeToken denylists a single access token until it would have expired.
+func (r *Revoker) RevokeToken(ctx context.Context, claims *Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return nil
+	}
+
+	if _, err := r.db.ExecContext(ctx, `
+		INSERT INTO revoked_access_tokens (jti, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING
+	`, claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	// Expired entries can never match a valid token again
+	r.db.ExecContext(ctx, "DELETE FROM revoked_access_tokens WHERE expires_at < NOW()")
+
+	// Checks trust a Redis miss, so the token is only revoked once Redis
+	// has the entry too
+	if r.redis != nil {
+		if err := r.redis.Set(ctx, denylistKey(claims.ID), strconv.Itoa(claims.UserID), ttl).Err(); err != nil {
+			return fmt.Errorf("failed to cache revoked token: %w", err)
+		}
+	}
+	return nil
+}
+
+// RevokeAll invalidates every access token issued to a user so far by
+// bumping their token version. It returns the new version. If the cached
+// version can be neither updated nor dropped, an error is returned: the
+// old version would otherwise keep being trusted until the cache expires.
+func (r *Revoker) RevokeAll(ctx context.Context, userID int) (int, error) {
+	var version int
+	err := r.db.QueryRowContext(ctx,
+		"UPDATE users SET token_version = token_version + 1 WHERE id = $1 RETURNING token_version",
+		userID,
+	).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to bump token version: %w", err)
+	}
+
+	if err := r.cacheVersion(ctx, userID, version); err != nil {
+		if delErr := r.redis.Del(ctx, tokenVersionKey(userID)).Err(); delErr != nil {
+			return 0, fmt.Errorf("failed to update cached token version: %w", err)
+		}
+	}
+	return version, nil
+}
+
+// cacheVersionScript stores ARGV[1] unless a higher version is already
+// cached, so a slow reader holding an old version cannot overwrite a bump.
+var cacheVersionScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current and tonumber(current) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+func (r *Revoker) cacheVersion(ctx context.Context, userID, version int) error {
+	if r.redis == nil {
+		return nil
+	}
+	return cacheVersionScript.Run(ctx, r.redis,
+		[]string{tokenVersionKey(userID)}, version, tokenVersionCacheTTL.Milliseconds(),
+	).Err()
+}
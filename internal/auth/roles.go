@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Permissions checked by the API. They are seeded by migration 017.
+const (
+	PermAdminAccess    = "admin:access"
+	PermOrdersRefund   = "orders:refund"
+	PermPaymentsRead   = "payments:read"
+	PermPaymentsManage = "payments:manage"
+	PermUsersManage    = "users:manage"
+)
+
+// RoleAdmin is the role that can manage every other role.
+const RoleAdmin = "admin"
+
+var (
+	ErrUnknownRole = errors.New("unknown role")
+	ErrUnknownUser = errors.New("unknown user")
+	ErrLastAdmin   = errors.New("cannot remove the last admin")
+)
+
+// Role is a named set of permissions.
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// RoleStore reads and changes role assignments.
+type RoleStore struct {
+	db *sql.DB
+}
+
+func NewRoleStore(db *sql.DB) *RoleStore {
+	return &RoleStore{db: db}
+}
+
+// ForUser returns the user's role names and the union of their permissions,
+// both sorted.
+func (s *RoleStore) ForUser(ctx context.Context, userID int) ([]string, []string, error) {
+	var roles, permissions []string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(ARRAY(
+				SELECT r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+				WHERE ur.user_id = $1 ORDER BY r.name
+			), '{}'),
+			COALESCE(ARRAY(
+				SELECT DISTINCT rp.permission FROM user_roles ur JOIN role_permissions rp ON rp.role_id = ur.role_id
+				WHERE ur.user_id = $1 ORDER BY rp.permission
+			), '{}')
+	`, userID).Scan(pq.Array(&roles), pq.Array(&permissions))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load roles: %w", err)
+	}
+	return roles, permissions, nil
+}
+
+// Roles lists every role with its permissions.
+func (s *RoleStore) Roles(ctx context.Context) ([]Role, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.name, COALESCE(r.description, ''),
+			COALESCE(ARRAY(SELECT permission FROM role_permissions WHERE role_id = r.id ORDER BY permission), '{}')
+		FROM roles r
+		ORDER BY r.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.Name, &role.Description, pq.Array(&role.Permissions)); err != nil {
+			return nil, fmt.Errorf("failed to list roles: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// Grant gives a user a role. Granting a role the user already has is not
+// an error.
+func (s *RoleStore) Grant(ctx context.Context, userID int, role, grantedBy string) error {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO user_roles (user_id, role_id, granted_by)
+		SELECT u.id, r.id, $3 FROM users u, roles r WHERE u.id = $1 AND r.name = $2
+		ON CONFLICT (user_id, role_id) DO NOTHING
+	`, userID, role, grantedBy)
+	if err != nil {
+		return fmt.Errorf("failed to grant role: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return s.checkExists(ctx, userID, role)
+	}
+	return nil
+}
+
+// Revoke removes a role from a user. Removing a role the user does not have
+// is not an error. Removing the admin role from its last holder fails with
+// ErrLastAdmin, so the admin API can never be locked out.
+func (s *RoleStore) Revoke(ctx context.Context, userID int, role string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to revoke role: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Locking the role row makes concurrent revokes of the same role take
+	// turns, so two admins cannot remove each other at once
+	var roleID int
+	err = tx.QueryRowContext(ctx, "SELECT id FROM roles WHERE name = $1 FOR UPDATE", role).Scan(&roleID)
+	if err == sql.ErrNoRows {
+		return s.checkExists(ctx, userID, role)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke role: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2", userID, roleID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke role: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n > 0 && role == RoleAdmin {
+		var remaining int
+		if err := tx.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM user_roles WHERE role_id = $1", roleID,
+		).Scan(&remaining); err != nil {
+			return fmt.Errorf("failed to revoke role: %w", err)
+		}
+		if remaining == 0 {
+			return ErrLastAdmin
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to revoke role: %w", err)
+	}
+	return s.checkExists(ctx, userID, role)
+}
+
+// checkExists reports which of the user and role is missing, if either.
+func (s *RoleStore) checkExists(ctx context.Context, userID int, role string) error {
+	var userExists, roleExists bool
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM users WHERE id = $1), EXISTS (SELECT 1 FROM roles WHERE name = $2)
+	`, userID, role).Scan(&userExists, &roleExists); err != nil {
+		return fmt.Errorf("failed to check role: %w", err)
+	}
+	if !userExists {
+		return ErrUnknownUser
+	}
+	if !roleExists {
+		return fmt.Errorf("%w: %s", ErrUnknownRole, role)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 test key from RFC 6238 appendix B,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8-digit codes; these are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, totpStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("totpCode failed: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now)
+
+	previous, _ := totpCode(rfc6238Secret, step-1)
+	current, _ := totpCode(rfc6238Secret, step)
+	stale, _ := totpCode(rfc6238Secret, step-2)
+
+	if got, ok := ValidateTOTP(rfc6238Secret, current, now); !ok || got != step {
+		t.Errorf("expected current code to match step %d, got %d %v", step, got, ok)
+	}
+	if got, ok := ValidateTOTP(rfc6238Secret, " "+previous+" ", now); !ok || got != step-1 {
+		t.Errorf("expected previous code within skew, got %d %v", got, ok)
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, stale, now); ok {
+		t.Error("expected code two periods old to be rejected")
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, "12345", now); ok {
+		t.Error("expected short code to be rejected")
+	}
+	if _, ok := ValidateTOTP("not base32!", current, now); ok {
+		t.Error("expected invalid secret to be rejected")
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret failed: %v", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("expected 32 base32 characters, got %d", len(secret))
+	}
+	if _, err := totpCode(secret, 1); err != nil {
+		t.Errorf("generated secret is not usable: %v", err)
+	}
+
+	other, _ := GenerateTOTPSecret()
+	if secret == other {
+		t.Error("expected different secrets")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("IOC Labs", "jane@example.com", rfc6238Secret)
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("invalid URI: %v", err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" {
+		t.Errorf("unexpected URI prefix: %s", uri)
+	}
+	if !strings.HasPrefix(u.Path, "/IOC Labs:jane@example.com") {
+		t.Errorf("unexpected label: %s", u.Path)
+	}
+
+	q := u.Query()
+	if q.Get("secret") != rfc6238Secret || q.Get("issuer") != "IOC Labs" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("unexpected parameters: %s", u.RawQuery)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	code, err := generateRecoveryCode()
+	if err != nil {
+		t.Fatalf("generateRecoveryCode failed: %v", err)
+	}
+	if len(code) != 11 || code[5] != '-' {
+		t.Errorf("unexpected recovery code format: %q", code)
+	}
+
+	if normalizeRecoveryCode(" "+strings.ToUpper(code)+" ") != normalizeRecoveryCode(strings.Replace(code, "-", "", 1)) {
+		t.Error("expected recovery codes to ignore case, spaces and dashes")
+	}
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// PurposeTwoFactorChallenge is the user token handed out by the password
+// step of a login when the account has two-factor authentication on.
+const PurposeTwoFactorChallenge = "2fa_challenge"
+
+// TwoFactorChallengeTTL is how long the second login step may take.
+const TwoFactorChallengeTTL = 5 * time.Minute
+
+// RecoveryCodeCount is how many recovery codes are issued on enrollment.
+const RecoveryCodeCount = 10
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("two-factor enrollment has not been started")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+)
+
+// TwoFactor manages TOTP enrollment and recovery codes. The TOTP secret is
+// stored on the user row; recovery codes are stored as SHA-256 hashes and
+// can each be used once.
+type TwoFactor struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+func NewTwoFactor(db *sql.DB) *TwoFactor {
+	return &TwoFactor{db: db, now: time.Now}
+}
+
+// Enabled reports whether a user has confirmed two-factor authentication.
+func (f *TwoFactor) Enabled(ctx context.Context, userID int) (bool, error) {
+	var enabled bool
+	if err := f.db.QueryRowContext(ctx,
+		"SELECT totp_enabled_at IS NOT NULL FROM users WHERE id = $1", userID,
+	).Scan(&enabled); err != nil {
+		return false, fmt.Errorf("failed to check two-factor status: %w", err)
+	}
+	return enabled, nil
+}
+
+// BeginEnrollment stores a new pending secret for the user and returns it.
+// The secret does not protect the account until ConfirmEnrollment is called
+// with a code generated from it.
+func (f *TwoFactor) BeginEnrollment(ctx context.Context, userID int) (string, error) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	res, err := f.db.ExecContext(ctx,
+		"UPDATE users SET totp_secret = $2, totp_last_step = 0 WHERE id = $1 AND totp_enabled_at IS NULL",
+		userID, secret,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", ErrTwoFactorEnabled
+	}
+	return secret, nil
+}
+
+// ConfirmEnrollment turns two-factor authentication on once the user proves
+// their authenticator works, and returns a fresh set of recovery codes. The
+// codes are only ever shown this once.
+func (f *TwoFactor) ConfirmEnrollment(ctx context.Context, userID int, code string) ([]string, error) {
+	tx, err := f.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var enabled bool
+	if err := tx.QueryRowContext(ctx,
+		"SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE", userID,
+	).Scan(&secret, &enabled); err != nil {
+		return nil, fmt.Errorf("failed to load TOTP secret: %w", err)
+	}
+	if enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if !secret.Valid {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	step, ok := ValidateTOTP(secret.String, code, f.now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $2 WHERE id = $1", userID, step,
+	); err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+	return codes, nil
+}
+
+// Verify checks a TOTP code or an unused recovery code for a user inside tx.
+// A TOTP code is accepted once only; a recovery code is used up. Either way
+// the change only sticks if tx commits.
+func (f *TwoFactor) Verify(ctx context.Context, tx *sql.Tx, userID int, code string) error {
+	var secret sql.NullString
+	var enabled bool
+	var lastStep int64
+	if err := tx.QueryRowContext(ctx,
+		"SELECT totp_secret, totp_enabled_at IS NOT NULL, totp_last_step FROM users WHERE id = $1 FOR UPDATE", userID,
+	).Scan(&secret, &enabled, &lastStep); err != nil {
+		return fmt.Errorf("failed to load TOTP secret: %w", err)
+	}
+	if !enabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if step, ok := ValidateTOTP(secret.String, code, f.now()); ok {
+		if step <= lastStep {
+			return ErrInvalidTwoFactorCode
+		}
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE users SET totp_last_step = $2 WHERE id = $1", userID, step,
+		); err != nil {
+			return fmt.Errorf("failed to record TOTP use: %w", err)
+		}
+		return nil
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return fmt.Errorf("failed to redeem recovery code: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// Disable turns two-factor authentication off and deletes the secret and
+// recovery codes. The caller is expected to have checked a code with
+// Verify in the same tx.
+func (f *TwoFactor) Disable(ctx context.Context, tx *sql.Tx, userID int) error {
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = $1", userID,
+	); err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	return nil
+}
+
+// replaceRecoveryCodes deletes a user's recovery codes and stores new ones.
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, HashToken(normalizeRecoveryCode(code)),
+		); err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+		codes[i] = code
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode returns a code like "k7qm2-x9fpt": about 49 bits of
+// entropy, without characters that are easy to misread.
+func generateRecoveryCode() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	// rand.Int draws uniformly, so every character is equally likely
+	size := big.NewInt(int64(len(alphabet)))
+	code := make([]byte, 10)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		code[i] = alphabet[n.Int64()]
+	}
+	return string(code[:5]) + "-" + string(code[5:]), nil
+}
+
+// normalizeRecoveryCode makes recovery codes case- and separator-insensitive.
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Purposes a user token can be issued for. A token only works for the
+// purpose it was issued for.
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+)
+
+// How long mailed links stay valid.
+const (
+	PasswordResetTTL     = time.Hour
+	EmailVerificationTTL = 48 * time.Hour
+)
+
+var ErrUserTokenInvalid = errors.New("token is invalid, expired or already used")
+
+// UserTokens issues and redeems single-use tokens that are mailed to users.
+// Only a hash of each token is stored.
+type UserTokens struct {
+	db *sql.DB
+}
+
+func NewUserTokens(db *sql.DB) *UserTokens {
+	return &UserTokens{db: db}
+}
+
+// Issue creates a token for purpose that expires after ttl. Any earlier
+// unused token for the same user and purpose stops working, so only the
+// most recent email is valid.
+func (t *UserTokens) Issue(ctx context.Context, userID int, purpose string, ttl time.Duration) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL",
+		userID, purpose,
+	); err != nil {
+		return "", fmt.Errorf("failed to expire previous tokens: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, purpose, HashToken(token), time.Now().Add(ttl)); err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+	return token, nil
+}
+
+// Consume redeems a token inside tx and returns the user it was issued to.
+// The token is used up only if tx commits, so a failed action can be
+// retried with the same link.
+func (t *UserTokens) Consume(ctx context.Context, tx *sql.Tx, token, purpose string) (int, error) {
+	var userID int
+	err := tx.QueryRowContext(ctx, `
+		UPDATE user_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id
+	`, HashToken(token), purpose).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrUserTokenInvalid
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to redeem token: %w", err)
+	}
+	return userID, nil
+}
+
+// RecentIssues reports how many tokens for purpose were issued to a user
+// since the given time, and when the latest one was issued. It is used to
+// throttle resend requests.
+func (t *UserTokens) RecentIssues(ctx context.Context, userID int, purpose string, since time.Time) (int, time.Time, error) {
+	var count int
+	var latest sql.NullTime
+	if err := t.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), MAX(created_at)
+		FROM user_tokens
+		WHERE user_id = $1 AND purpose = $2 AND created_at >= $3
+	`, userID, purpose, since).Scan(&count, &latest); err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to count tokens: %w", err)
+	}
+	return count, latest.Time, nil
+}
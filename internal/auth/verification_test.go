@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"context"
+	"testing"
+)
+
+func TestVerificationPolicyFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "", want: false},
+		{value: "false", want: false},
+		{value: "true", want: true},
+		{value: "1", want: true},
+		{value: "yes please", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("REQUIRE_VERIFIED_EMAIL_FOR_ORDERS", tt.value)
+			if got := VerificationPolicyFromEnv().RequireForOrders; got != tt.want {
+				t.Errorf("RequireForOrders = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptionalVerificationSkipsLookup(t *testing.T) {
+	// With verification optional no database is needed to decide
+	if err := (VerificationPolicy{}).CheckCanOrder(context.Background(), nil, 1); err != nil {
+		t.Errorf("expected unverified users to be allowed, got %v", err)
+	}
+}
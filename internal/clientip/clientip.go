@@ -0,0 +1,209 @@
+// Package clientip works out the real client address of a request that may
+// have passed through load balancers and proxies.
+//
+// Forwarding headers are only believed when they were added by a trusted
+// proxy. Only the one header the proxies write is read (X-Forwarded-For by
+// default); the other is passed through untouched by the proxy and so is
+// entirely client-controlled. The chain of addresses (RemoteAddr preceded by
+// the header's entries) is walked from the right, skipping trusted proxies;
+// the first untrusted address is the client. Everything to the left of it
+// may have been written by the client and is ignored.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Forwarding headers a Resolver can read.
+const (
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderForwarded     = "Forwarded"
+)
+
+// Resolver resolves client addresses given a set of trusted proxy networks.
+type Resolver struct {
+	trusted []*net.IPNet
+	header  string
+}
+
+// NewResolver returns a Resolver trusting the given CIDRs and reading the
+// client chain from header, HeaderXForwardedFor or HeaderForwarded (empty
+// means X-Forwarded-For). Bare IP addresses are accepted as single-host
+// networks.
+func NewResolver(header string, cidrs []string) (*Resolver, error) {
+	r := &Resolver{}
+	switch {
+	case header == "" || strings.EqualFold(header, HeaderXForwardedFor):
+		r.header = HeaderXForwardedFor
+	case strings.EqualFold(header, HeaderForwarded):
+		r.header = HeaderForwarded
+	default:
+		return nil, fmt.Errorf("unsupported trusted proxy header %q", header)
+	}
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", c)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			r.trusted = append(r.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", c, err)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+	return r, nil
+}
+
+// ResolverFromEnv builds a Resolver from TRUSTED_PROXY_CIDRS, a comma
+// separated list such as the load balancer subnets, and
+// TRUSTED_PROXY_HEADER, the header those proxies append to. With no CIDRs
+// configured forwarding headers are ignored and RemoteAddr is the client.
+func ResolverFromEnv() (*Resolver, error) {
+	return NewResolver(os.Getenv("TRUSTED_PROXY_HEADER"), strings.Split(os.Getenv("TRUSTED_PROXY_CIDRS"), ","))
+}
+
+// TrustedCount returns how many proxy networks are trusted.
+func (r *Resolver) TrustedCount() int {
+	return len(r.trusted)
+}
+
+// Header returns the forwarding header the resolver reads.
+func (r *Resolver) Header() string {
+	return r.header
+}
+
+func (r *Resolver) isTrusted(ip net.IP) bool {
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the client address of req, read from the configured
+// header only. There is deliberately no fallback to the other header.
+func (r *Resolver) ClientIP(req *http.Request) string {
+	peer := parseIP(req.RemoteAddr)
+	if peer == nil {
+		return req.RemoteAddr
+	}
+	if !r.isTrusted(peer) {
+		return peer.String()
+	}
+
+	var hops []string
+	if r.header == HeaderForwarded {
+		hops = forwardedFor(req.Header.Values(HeaderForwarded))
+	} else {
+		for _, v := range req.Header.Values(HeaderXForwardedFor) {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// Garbage or an obfuscated identifier: the last proxy we
+			// trust is the best we can do.
+			break
+		}
+		client = ip
+		if !r.isTrusted(ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// Middleware resolves the client address once and stores it in the request
+// context for FromRequest.
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), contextKey{}, r.ClientIP(req))
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+type contextKey struct{}
+
+// FromRequest returns the address stored by Middleware. Without it, the
+// request's RemoteAddr host is returned and forwarding headers are ignored.
+func FromRequest(req *http.Request) string {
+	if ip, ok := req.Context().Value(contextKey{}).(string); ok {
+		return ip
+	}
+	if ip := parseIP(req.RemoteAddr); ip != nil {
+		return ip.String()
+	}
+	return req.RemoteAddr
+}
+
+// parseIP accepts "ip", "ip:port", "[ipv6]" and "[ipv6]:port".
+func parseIP(s string) net.IP {
+	s = strings.Trim(s, `"`)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+
+	ip := net.ParseIP(s)
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// forwardedFor extracts the for= values of Forwarded header elements, in
+// order.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, element := range splitQuoted(v, ',') {
+			for _, pair := range splitQuoted(element, ';') {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// splitQuoted splits s on sep, except inside double quotes.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,209 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	resolver, err := NewResolver("", []string{"10.0.0.0/16", "2001:db8:aaaa::/48", "192.0.2.10"})
+	if err != nil {
+		t.Fatalf("NewResolver failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "203.0.113.7:51234",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "untrusted peer cannot set XFF",
+			remoteAddr: "203.0.113.7:51234",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "load balancer appends the client",
+			remoteAddr: "10.0.1.5:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.20"}},
+			want:       "198.51.100.20",
+		},
+		{
+			name:       "spoofed entries left of the client are ignored",
+			remoteAddr: "10.0.1.5:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.20"}},
+			want:       "198.51.100.20",
+		},
+		{
+			name:       "chain of trusted proxies is skipped",
+			remoteAddr: "10.0.1.5:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.20, 192.0.2.10, 10.0.2.9"}},
+			want:       "198.51.100.20",
+		},
+		{
+			name:       "repeated XFF headers are joined in order",
+			remoteAddr: "10.0.1.5:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4", "198.51.100.20, 10.0.2.9"}},
+			want:       "198.51.100.20",
+		},
+		{
+			name:       "all hops trusted",
+			remoteAddr: "10.0.1.5:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.3.3"}},
+			want:       "10.0.3.3",
+		},
+		{
+			name:       "garbage stops the walk at the last trusted proxy",
+			remoteAddr: "10.0.1.5:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.20, not-an-ip"}},
+			want:       "10.0.1.5",
+		},
+		{
+			name:       "no forwarding headers from a trusted peer",
+			remoteAddr: "10.0.1.5:443",
+			want:       "10.0.1.5",
+		},
+		{
+			name:       "client-sent Forwarded header is ignored",
+			remoteAddr: "10.0.1.5:443",
+			headers: map[string][]string{
+				"Forwarded":       {`for=1.2.3.4, for=198.51.100.20;proto=https;by=10.0.1.5`},
+				"X-Forwarded-For": {"9.9.9.9"},
+			},
+			want: "9.9.9.9",
+		},
+		{
+			name:       "client-sent obfuscated Forwarded identifier is ignored",
+			remoteAddr: "10.0.1.5:443",
+			headers: map[string][]string{
+				"Forwarded":       {`for=_hidden`},
+				"X-Forwarded-For": {"198.51.100.20"},
+			},
+			want: "198.51.100.20",
+		},
+		{
+			name:       "IPv4-mapped IPv6 peer",
+			remoteAddr: "[::ffff:10.0.1.5]:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.20"}},
+			want:       "198.51.100.20",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, values := range tt.headers {
+				for _, v := range values {
+					req.Header.Add(k, v)
+				}
+			}
+
+			if got := resolver.ClientIP(req); got != tt.want {
+				t.Errorf("ClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPForwardedHeader(t *testing.T) {
+	resolver, err := NewResolver("forwarded", []string{"10.0.0.0/16", "2001:db8:aaaa::/48"})
+	if err != nil {
+		t.Fatalf("NewResolver failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{
+			name:       "Forwarded header",
+			remoteAddr: "10.0.1.5:443",
+			headers:    map[string][]string{"Forwarded": {`for=1.2.3.4, for=198.51.100.20;proto=https;by=10.0.1.5`}},
+			want:       "198.51.100.20",
+		},
+		{
+			name:       "client-sent X-Forwarded-For is ignored",
+			remoteAddr: "10.0.1.5:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4"}},
+			want:       "10.0.1.5",
+		},
+		{
+			name:       "quoted IPv6 and port",
+			remoteAddr: "[2001:db8:aaaa::1]:443",
+			headers:    map[string][]string{"Forwarded": {`For="[2001:db8:cafe::17]:4711"`}},
+			want:       "2001:db8:cafe::17",
+		},
+		{
+			name:       "obfuscated identifier",
+			remoteAddr: "10.0.1.5:443",
+			headers:    map[string][]string{"Forwarded": {`for=_hidden`}},
+			want:       "10.0.1.5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, values := range tt.headers {
+				for _, v := range values {
+					req.Header.Add(k, v)
+				}
+			}
+
+			if got := resolver.ClientIP(req); got != tt.want {
+				t.Errorf("ClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareStoresClientIP(t *testing.T) {
+	resolver, _ := NewResolver("", []string{"10.0.0.0/8"})
+
+	var got string
+	handler := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromRequest(r)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.1.2.3:443"
+	req.Header.Set("X-Forwarded-For", "198.51.100.20")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "198.51.100.20" {
+		t.Errorf("FromRequest = %s, want 198.51.100.20", got)
+	}
+
+	// Without the middleware headers are not trusted
+	if ip := FromRequest(req); ip != "10.1.2.3" {
+		t.Errorf("FromRequest without middleware = %s, want 10.1.2.3", ip)
+	}
+}
+
+func TestNewResolverRejectsBadCIDRs(t *testing.T) {
+	for _, cidr := range []string{"10.0.0.0/33", "not-an-ip"} {
+		if _, err := NewResolver("", []string{cidr}); err == nil {
+			t.Errorf("expected %q to be rejected", cidr)
+		}
+	}
+
+	r, err := NewResolver("", []string{"", " 10.0.0.0/8 "})
+	if err != nil || r.TrustedCount() != 1 {
+		t.Errorf("expected blanks to be skipped, got %v, %v", r, err)
+	}
+
+	if _, err := NewResolver("X-Real-IP", nil); err == nil {
+		t.Error("expected an unsupported header to be rejected")
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/stripe/stripe-go/v76"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/services"
+)
+
+// SimulatePayment - Settles a fake payment intent and delivers the resulting
+// webhook in-process (fake provider only)
+//
+// This stands in for the customer confirming payment in Stripe.js, so the
+// checkout -> webhook -> paid flow can run locally without network access.
+func (h *PaymentHandler) SimulatePayment(w http.ResponseWriter, r *http.Request) {
+	fake, ok := h.payments.Provider().(*services.FakeProvider)
+	if !ok {
+		h.jsonError(w, http.StatusNotFound, "NOT_FOUND", "Payment simulation is only available with the fake provider")
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int64)
+	intentID := mux.Vars(r)["intent_id"]
+
+	var req struct {
+		Outcome string `json:"outcome"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.jsonError(w, http.StatusBadRequest, "INVALID_JSON", "Invalid request body")
+			return
+		}
+	}
+
+	pi, err := fake.GetPaymentIntent(r.Context(), intentID)
+	if err != nil {
+		h.jsonError(w, http.StatusNotFound, "NOT_FOUND", "Payment intent not found")
+		return
+	}
+	if pi.Metadata["user_id"] != strconv.FormatInt(userID, 10) {
+		h.jsonError(w, http.StatusForbidden, "FORBIDDEN", "Not your payment")
+		return
+	}
+
+	var eventType stripe.EventType
+	switch req.Outcome {
+	case "", "succeeded":
+		pi, err = fake.SucceedPaymentIntent(intentID)
+		eventType = "payment_intent.succeeded"
+	case "failed":
+		pi, err = fake.FailPaymentIntent(intentID)
+		eventType = "payment_intent.payment_failed"
+	default:
+		h.jsonError(w, http.StatusBadRequest, "INVALID_OUTCOME", "outcome must be succeeded or failed")
+		return
+	}
+	if err != nil {
+		h.jsonError(w, http.StatusConflict, "PAYMENT_NOT_SETTLEABLE", err.Error())
+		return
+	}
+
+	payload, signature, err := fake.SignedEvent(eventType, pi)
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to build webhook")
+		return
+	}
+
+	webhookStatus, err := h.handleWebhookPayload(r.Context(), payload, signature)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Err(err).Str("payment_intent", intentID).Msg("Simulated webhook failed")
+	}
+
+	h.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"payment_intent_id": pi.ID,
+		"status":            pi.Status,
+		"webhook_status":    webhookStatus,
+	})
+}
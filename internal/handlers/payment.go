@@ -1,93 +1,197 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strconv"
 
+	"github.com/rs/zerolog"
 	"github.com/stripe/stripe-go/v76"
-	"github.com/stripe/stripe-go/v76/paymentintent"
-	"github.com/stripe/stripe-go/v76/webhook"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/audit"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/auth"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/clientip"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/metrics"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/orders"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/services"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/money"
 )
 
 type PaymentHandler struct {
-	db *sql.DB
+	db           *sql.DB
+	payments     *services.PaymentService
+	verification auth.VerificationPolicy
 }
 
-func NewPaymentHandler(db *sql.DB) *PaymentHandler {
-	return &PaymentHandler{db: db}
+func NewPaymentHandler(db *sql.DB, payments *services.PaymentService, verification auth.VerificationPolicy) *PaymentHandler {
+	return &PaymentHandler{db: db, payments: payments, verification: verification}
 }
 
 // CreatePaymentIntent - Creates a Stripe payment intent for an order
+//
+// The intent is saved on the order. Repeat calls return the saved intent
+// while it can still be paid, updating its amount if the order total has
+// changed, so double clicks never create a second charge. An optional
+// Idempotency-Key header is passed through to the provider.
 func (h *PaymentHandler) CreatePaymentIntent(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int64)
 
 	var req struct {
 		OrderID int64 `json:"order_id"`
 	}
-	
+
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.jsonError(w, http.StatusBadRequest, "INVALID_JSON", "Invalid request body")
 		return
 	}
 
-	// Get order and verify ownership
+	if err := h.verification.CheckCanOrder(r.Context(), h.db, userID); err != nil {
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			h.jsonError(w, http.StatusForbidden, "EMAIL_NOT_VERIFIED", "Please verify your email address before paying")
+			return
+		}
+		h.jsonError(w, http.StatusInternalServerError, "PAYMENT_FAILED", "Failed to create payment")
+		return
+	}
+
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > 200 {
+		h.jsonError(w, http.StatusBadRequest, "INVALID_IDEMPOTENCY_KEY", "Idempotency-Key must be at most 200 characters")
+		return
+	}
+	if idempotencyKey != "" {
+		// Keys are scoped per user so one customer cannot collide with another
+		idempotencyKey = fmt.Sprintf("user-%d-%s", userID, idempotencyKey)
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "PAYMENT_FAILED", "Failed to create payment")
+		return
+	}
+	defer tx.Rollback()
+
+	// Get order and verify ownership. The row lock makes concurrent
+	// attempts for the same order wait for the first one's intent.
 	var order struct {
-		ID     int64
-		UserID int64
-		Total  float64
-		Status string
-	}
-	
-	err := h.db.QueryRow(
-		"SELECT id, user_id, total, status FROM orders WHERE id = $1",
+		ID              int64
+		UserID          int64
+		Total           int64
+		Currency        string
+		Status          string
+		PaymentIntentID sql.NullString
+	}
+
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, user_id, total_cents, currency, status, stripe_payment_intent_id FROM orders WHERE id = $1 FOR UPDATE",
 		req.OrderID,
-	).Scan(&order.ID, &order.UserID, &order.Total, &order.Status)
-	
+	).Scan(&order.ID, &order.UserID, &order.Total, &order.Currency, &order.Status, &order.PaymentIntentID)
+
 	if err == sql.ErrNoRows {
 		h.jsonError(w, http.StatusNotFound, "NOT_FOUND", "Order not found")
 		return
 	}
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "PAYMENT_FAILED", "Failed to load order")
+		return
+	}
 
 	if order.UserID != userID {
 		h.jsonError(w, http.StatusForbidden, "FORBIDDEN", "Not your order")
 		return
 	}
 
-	if order.Status == "paid" {
+	switch orders.Status(order.Status) {
+	case orders.StatusPending, orders.StatusAwaitingPayment:
+	case orders.StatusPaid:
 		h.jsonError(w, http.StatusBadRequest, "ALREADY_PAID", "Order already paid")
 		return
+	default:
+		h.jsonError(w, http.StatusConflict, "ORDER_NOT_PAYABLE", fmt.Sprintf("Order is %s and cannot be paid", order.Status))
+		return
 	}
 
-	// Create payment intent
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
-	
-	amountCents := int64(order.Total * 100)
-	params := &stripe.PaymentIntentParams{
-		Amount:   stripe.Int64(amountCents),
-		Currency: stripe.String("usd"),
-		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
-			Enabled: stripe.Bool(true),
-		},
+	total := money.New(order.Total, order.Currency)
+
+	// Reuse the saved intent while the customer can still pay it
+	var pi *stripe.PaymentIntent
+	var replacing string
+	if order.PaymentIntentID.Valid {
+		existing, err := h.payments.GetPaymentIntent(ctx, order.PaymentIntentID.String)
+		if err != nil {
+			h.jsonError(w, http.StatusBadGateway, "PAYMENT_FAILED", "Failed to load payment")
+			return
+		}
+
+		switch {
+		case services.IntentReusable(existing):
+			pi, err = h.payments.SyncPaymentIntent(ctx, existing, total)
+			if err != nil {
+				h.jsonError(w, http.StatusBadGateway, "PAYMENT_FAILED", "Failed to update payment")
+				return
+			}
+		case existing.Status == stripe.PaymentIntentStatusCanceled:
+			// Start over with a fresh intent below
+			replacing = existing.ID
+		default:
+			h.jsonError(w, http.StatusConflict, "PAYMENT_IN_PROGRESS", fmt.Sprintf("Payment is already %s", existing.Status))
+			return
+		}
 	}
-	
-	// Add metadata
-	params.AddMetadata("order_id", fmt.Sprintf("%d", order.ID))
-	params.AddMetadata("user_id", fmt.Sprintf("%d", order.UserID))
 
-	pi, err := paymentintent.New(params)
+	if pi == nil {
+		// A key reused from before the cancellation would make the provider
+		// replay the canceled intent, so the replacement gets its own key
+		if idempotencyKey != "" && replacing != "" {
+			idempotencyKey += "-after-" + replacing
+		}
+
+		// Create payment intent for exactly the order total
+		pi, err = h.payments.CreatePaymentIntent(ctx, total, idempotencyKey, map[string]string{
+			"order_id":  fmt.Sprintf("%d", order.ID),
+			"user_id":   fmt.Sprintf("%d", order.UserID),
+			"client_ip": clientip.FromRequest(r),
+		})
+		if err != nil {
+			h.jsonError(w, http.StatusInternalServerError, "PAYMENT_FAILED", "Failed to create payment")
+			return
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE orders SET stripe_payment_intent_id = $1 WHERE id = $2",
+			pi.ID, order.ID,
+		); err != nil {
+			h.jsonError(w, http.StatusInternalServerError, "PAYMENT_FAILED", "Failed to update order")
+			return
+		}
+	}
+
+	_, err = orders.Transition(ctx, tx, order.ID, orders.Change{
+		To:            orders.StatusAwaitingPayment,
+		PaymentStatus: string(pi.Status),
+		Actor:         audit.UserActor(userID),
+		Reason:        fmt.Sprintf("payment_intent %s created", pi.ID),
+	})
 	if err != nil {
-		h.jsonError(w, http.StatusInternalServerError, "PAYMENT_FAILED", "Failed to create payment")
+		h.jsonError(w, http.StatusInternalServerError, "PAYMENT_FAILED", "Failed to update order")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "PAYMENT_FAILED", "Failed to update order")
 		return
 	}
 
 	h.jsonResponse(w, http.StatusOK, map[string]interface{}{
-		"client_secret": pi.ClientSecret,
-		"amount":        pi.Amount,
+		"payment_intent_id": pi.ID,
+		"client_secret":     pi.ClientSecret,
+		"amount":            money.New(pi.Amount, string(pi.Currency)),
 	})
 }
 
@@ -95,7 +199,7 @@ func (h *PaymentHandler) CreatePaymentIntent(w http.ResponseWriter, r *http.Requ
 func (h *PaymentHandler) HandleStripeWebhook(w http.ResponseWriter, r *http.Request) {
 	const MaxBodyBytes = int64(65536)
 	r.Body = http.MaxBytesReader(w, r.Body, MaxBodyBytes)
-	
+
 	payload, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "Error reading request body", http.StatusBadRequest)
@@ -103,147 +207,258 @@ func (h *PaymentHandler) HandleStripeWebhook(w http.ResponseWriter, r *http.Requ
 	}
 
 	signature := r.Header.Get("Stripe-Signature")
-	webhookSecret := os.Getenv("STRIPE_WEBHOOK_SECRET")
-	
-	if webhookSecret == "" {
-		http.Error(w, "Webhook secret not configured", http.StatusInternalServerError)
+	status, err := h.handleWebhookPayload(r.Context(), payload, signature)
+	if err != nil {
+		http.Error(w, err.Error(), status)
 		return
 	}
 
+	w.WriteHeader(status)
+}
+
+// handleWebhookPayload verifies, records and applies one webhook delivery.
+// It returns the HTTP status to answer the delivery with.
+func (h *PaymentHandler) handleWebhookPayload(ctx context.Context, payload []byte, signature string) (int, error) {
 	// Verify webhook signature
-	event, err := webhook.ConstructEvent(payload, signature, webhookSecret)
+	event, err := h.payments.ConstructEvent(payload, signature)
+	if errors.Is(err, services.ErrWebhookSecretMissing) {
+		return http.StatusInternalServerError, fmt.Errorf("Webhook secret not configured")
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Webhook signature verification failed: %v", err), http.StatusBadRequest)
-		return
+		return http.StatusBadRequest, fmt.Errorf("Webhook signature verification failed: %v", err)
+	}
+
+	// Record the event and skip it if it has already been applied
+	claimed, err := h.claimEvent(ctx, &event, payload)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Error recording event")
+	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("event_id", event.ID).Str("event_type", string(event.Type)).Msg("Skipping duplicate event")
+		metrics.WebhookEvent(string(event.Type), metrics.WebhookDuplicate)
+		return http.StatusOK, nil
+	}
+
+	err = h.processEvent(ctx, &event)
+	h.finishEvent(ctx, event.ID, err)
+	switch {
+	case errors.Is(err, errUnhandledEvent):
+		metrics.WebhookEvent(string(event.Type), metrics.WebhookUnhandled)
+	case err != nil:
+		metrics.WebhookEvent(string(event.Type), metrics.WebhookFailed)
+		return http.StatusInternalServerError, fmt.Errorf("Error processing event")
+	default:
+		metrics.WebhookEvent(string(event.Type), metrics.WebhookProcessed)
 	}
 
-	// Handle the event
+	return http.StatusOK, nil
+}
+
+// errUnhandledEvent marks event types the handler does not act on.
+var errUnhandledEvent = errors.New("unhandled event type")
+
+// processEvent applies the side effects of a verified event.
+func (h *PaymentHandler) processEvent(ctx context.Context, event *stripe.Event) error {
 	switch event.Type {
 	case "payment_intent.succeeded":
 		var pi stripe.PaymentIntent
 		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
-			http.Error(w, "Error parsing webhook JSON", http.StatusBadRequest)
-			return
-		}
-		if err := h.handlePaymentSuccess(&pi); err != nil {
-			http.Error(w, "Error processing payment", http.StatusInternalServerError)
-			return
+			return fmt.Errorf("error parsing webhook JSON: %w", err)
 		}
+		return h.handlePaymentSuccess(ctx, &pi)
 
 	case "payment_intent.payment_failed":
 		var pi stripe.PaymentIntent
 		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
-			http.Error(w, "Error parsing webhook JSON", http.StatusBadRequest)
-			return
-		}
-		if err := h.handlePaymentFailure(&pi); err != nil {
-			http.Error(w, "Error processing payment failure", http.StatusInternalServerError)
-			return
+			return fmt.Errorf("error parsing webhook JSON: %w", err)
 		}
+		return h.handlePaymentFailure(ctx, &pi)
 
 	case "payment_intent.canceled":
 		var pi stripe.PaymentIntent
 		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
-			http.Error(w, "Error parsing webhook JSON", http.StatusBadRequest)
-			return
+			return fmt.Errorf("error parsing webhook JSON: %w", err)
 		}
-		if err := h.handlePaymentCanceled(&pi); err != nil {
-			http.Error(w, "Error processing cancellation", http.StatusInternalServerError)
-			return
+		return h.handlePaymentCanceled(ctx, &pi)
+
+	case "charge.refunded":
+		var ch stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &ch); err != nil {
+			return fmt.Errorf("error parsing webhook JSON: %w", err)
 		}
+		return h.handleChargeRefunded(ctx, &ch)
 
 	default:
-		fmt.Printf("Unhandled event type: %s\n", event.Type)
+		zerolog.Ctx(ctx).Info().Str("event_type", string(event.Type)).Msg("Unhandled event type")
+		return errUnhandledEvent
 	}
-
-	w.WriteHeader(http.StatusOK)
 }
 
-func (h *PaymentHandler) handlePaymentSuccess(pi *stripe.PaymentIntent) error {
-	orderIDStr, exists := pi.Metadata["order_id"]
-	if !exists {
-		return fmt.Errorf("order_id not found in payment intent metadata")
+// handlePaymentSuccess marks the order paid, but only if the intent matches
+// the order's total, currency and stored intent ID. A mismatched payment
+// moves the order to payment_review and raises an alert instead, as does a
+// payment for an order that can no longer be paid (which keeps its status).
+func (h *PaymentHandler) handlePaymentSuccess(ctx context.Context, pi *stripe.PaymentIntent) error {
+	orderID, err := orderIDFromMetadata(pi)
+	if err != nil {
+		return err
 	}
 
-	orderID, err := strconv.ParseInt(orderIDStr, 10, 64)
+	var mismatches []services.PaymentMismatch
+	applied, err := h.updateOrder(ctx, orderID, func(tx *sql.Tx) error {
+		var err error
+		mismatches, err = orders.ApplyPaymentSuccess(ctx, tx, orderID, pi, orders.ActorStripe)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("invalid order_id: %w", err)
+		return err
+	}
+	if !applied {
+		metrics.PaymentOutcome(metrics.PaymentStale)
+		return nil
 	}
 
-	query := `
-		UPDATE orders 
-		SET 
-			status = 'paid',
-			payment_status = 'succeeded',
-			stripe_payment_intent_id = $1,
-			updated_at = NOW()
-		WHERE id = $2
-	`
-
-	_, err = h.db.Exec(query, pi.ID, orderID)
-	if err != nil {
-		return fmt.Errorf("failed to update order: %w", err)
+	if len(mismatches) > 0 {
+		zerolog.Ctx(ctx).Warn().Int64("order_id", orderID).Str("payment_intent", pi.ID).Interface("mismatches", mismatches).Msg("Payment flagged for review")
+		metrics.PaymentOutcome(metrics.PaymentReview)
+		return nil
 	}
 
-	fmt.Printf("✓ Payment succeeded for order %d (payment_intent: %s)\n", orderID, pi.ID)
+	zerolog.Ctx(ctx).Info().Int64("order_id", orderID).Str("payment_intent", pi.ID).Msg("Payment succeeded")
+	metrics.PaymentOutcome(metrics.PaymentSucceeded)
 	return nil
 }
 
-func (h *PaymentHandler) handlePaymentFailure(pi *stripe.PaymentIntent) error {
-	orderIDStr, exists := pi.Metadata["order_id"]
-	if !exists {
-		return fmt.Errorf("order_id not found in payment intent metadata")
+func (h *PaymentHandler) handlePaymentFailure(ctx context.Context, pi *stripe.PaymentIntent) error {
+	orderID, err := orderIDFromMetadata(pi)
+	if err != nil {
+		return err
 	}
 
-	orderID, err := strconv.ParseInt(orderIDStr, 10, 64)
+	// A failed attempt leaves the order waiting for another payment. If the
+	// order has already moved on (e.g. a late failure after a success), the
+	// transition is rejected and the order is left untouched.
+	applied, err := h.updateOrderIntent(ctx, orderID, pi.ID, func(tx *sql.Tx) error {
+		_, err := orders.Transition(ctx, tx, orderID, orders.Change{
+			To:            orders.StatusAwaitingPayment,
+			PaymentStatus: "failed",
+			Actor:         orders.ActorStripe,
+			Reason:        fmt.Sprintf("payment_intent %s failed", pi.ID),
+		})
+		return err
+	})
+	if errors.Is(err, errStaleIntent) {
+		staleIntent(ctx, orderID, pi)
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("invalid order_id: %w", err)
+		return err
+	}
+	if !applied {
+		metrics.PaymentOutcome(metrics.PaymentStale)
+		return nil
 	}
 
-	query := `
-		UPDATE orders 
-		SET 
-			payment_status = 'failed',
-			updated_at = NOW()
-		WHERE id = $1
-	`
+	zerolog.Ctx(ctx).Warn().Int64("order_id", orderID).Str("payment_intent", pi.ID).Msg("Payment failed")
+	metrics.PaymentOutcome(metrics.PaymentFailed)
+	return nil
+}
+
+func (h *PaymentHandler) handlePaymentCanceled(ctx context.Context, pi *stripe.PaymentIntent) error {
+	orderID, err := orderIDFromMetadata(pi)
+	if err != nil {
+		return err
+	}
 
-	_, err = h.db.Exec(query, orderID)
+	applied, err := h.updateOrderIntent(ctx, orderID, pi.ID, func(tx *sql.Tx) error {
+		return orders.Cancel(ctx, tx, orderID, orders.ActorStripe, fmt.Sprintf("payment_intent %s canceled", pi.ID))
+	})
+	if errors.Is(err, errStaleIntent) {
+		staleIntent(ctx, orderID, pi)
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to update order: %w", err)
+		return err
+	}
+	if !applied {
+		metrics.PaymentOutcome(metrics.PaymentStale)
+		return nil
 	}
 
-	fmt.Printf("✗ Payment failed for order %d (payment_intent: %s)\n", orderID, pi.ID)
+	zerolog.Ctx(ctx).Warn().Int64("order_id", orderID).Str("payment_intent", pi.ID).Msg("Payment canceled")
+	metrics.PaymentOutcome(metrics.PaymentCanceled)
 	return nil
 }
 
-func (h *PaymentHandler) handlePaymentCanceled(pi *stripe.PaymentIntent) error {
-	orderIDStr, exists := pi.Metadata["order_id"]
-	if !exists {
-		return fmt.Errorf("order_id not found in payment intent metadata")
+// errStaleIntent marks an event for an intent the order no longer uses,
+// e.g. one CreatePaymentIntent replaced after it was canceled.
+var errStaleIntent = errors.New("payment intent is not the order's current intent")
+
+// updateOrderIntent is updateOrder for events about one payment intent. The
+// change is only applied while that intent is the one stored on the order;
+// otherwise errStaleIntent is returned and nothing is changed.
+func (h *PaymentHandler) updateOrderIntent(ctx context.Context, orderID int64, intentID string, apply func(tx *sql.Tx) error) (bool, error) {
+	return h.updateOrder(ctx, orderID, func(tx *sql.Tx) error {
+		var stored sql.NullString
+		err := tx.QueryRowContext(ctx,
+			"SELECT stripe_payment_intent_id FROM orders WHERE id = $1 FOR UPDATE", orderID,
+		).Scan(&stored)
+		if err == sql.ErrNoRows {
+			return orders.ErrOrderNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("lock order: %w", err)
+		}
+		if stored.Valid && stored.String != intentID {
+			return errStaleIntent
+		}
+		return apply(tx)
+	})
+}
+
+// staleIntent logs and counts an ignored event for a replaced intent.
+func staleIntent(ctx context.Context, orderID int64, pi *stripe.PaymentIntent) {
+	zerolog.Ctx(ctx).Info().Int64("order_id", orderID).Str("payment_intent", pi.ID).Str("intent_status", string(pi.Status)).Msg("Ignoring event for replaced payment intent")
+	metrics.PaymentOutcome(metrics.PaymentStale)
+}
+
+// updateOrder runs apply in a transaction and commits it, reporting whether
+// the change was applied. Changes the order lifecycle does not allow are
+// logged and dropped rather than returned, so Stripe does not keep retrying
+// them.
+func (h *PaymentHandler) updateOrder(ctx context.Context, orderID int64, apply func(tx *sql.Tx) error) (bool, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	orderID, err := strconv.ParseInt(orderIDStr, 10, 64)
+	err = apply(tx)
+	if errors.Is(err, orders.ErrInvalidTransition) {
+		zerolog.Ctx(ctx).Info().Err(err).Int64("order_id", orderID).Msg("Ignoring update for order")
+		return false, nil
+	}
 	if err != nil {
-		return fmt.Errorf("invalid order_id: %w", err)
+		return false, fmt.Errorf("failed to update order %d: %w", orderID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
 	}
+	return true, nil
+}
 
-	query := `
-		UPDATE orders 
-		SET 
-			status = 'canceled',
-			payment_status = 'canceled',
-			updated_at = NOW()
-		WHERE id = $1
-	`
+func orderIDFromMetadata(pi *stripe.PaymentIntent) (int64, error) {
+	orderIDStr, exists := pi.Metadata["order_id"]
+	if !exists {
+		return 0, fmt.Errorf("order_id not found in payment intent metadata")
+	}
 
-	_, err = h.db.Exec(query, orderID)
+	orderID, err := strconv.ParseInt(orderIDStr, 10, 64)
 	if err != nil {
-		return fmt.Errorf("failed to update order: %w", err)
+		return 0, fmt.Errorf("invalid order_id: %w", err)
 	}
-
-	fmt.Printf("⚠ Payment canceled for order %d (payment_intent: %s)\n", orderID, pi.ID)
-	return nil
+	return orderID, nil
 }
 
 // Helper functions
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/audit"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/orders"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/services"
+)
+
+// paymentAlert is a flagged payment as returned by the admin API.
+type paymentAlert struct {
+	ID              int64                      `json:"id"`
+	OrderID         int64                      `json:"order_id"`
+	PaymentIntentID string                     `json:"payment_intent_id"`
+	Mismatches      []services.PaymentMismatch `json:"mismatches"`
+	Status          string                     `json:"status"`
+	ResolvedBy      string                     `json:"resolved_by,omitempty"`
+	ResolutionNote  string                     `json:"resolution_note,omitempty"`
+	ResolvedAt      *time.Time                 `json:"resolved_at,omitempty"`
+	CreatedAt       time.Time                  `json:"created_at"`
+}
+
+// ListPaymentAlerts - Lists flagged payments, open ones by default (admin only)
+func (h *PaymentHandler) ListPaymentAlerts(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "open"
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > 500 {
+		limit = 100
+	}
+
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT id, order_id, payment_intent_id, mismatches, status,
+			COALESCE(resolved_by, ''), COALESCE(resolution_note, ''), resolved_at, created_at
+		FROM payment_alerts
+		WHERE status = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, status, limit)
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to load alerts")
+		return
+	}
+	defer rows.Close()
+
+	alerts := []paymentAlert{}
+	for rows.Next() {
+		var a paymentAlert
+		var mismatches []byte
+		var resolvedAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.OrderID, &a.PaymentIntentID, &mismatches, &a.Status,
+			&a.ResolvedBy, &a.ResolutionNote, &resolvedAt, &a.CreatedAt); err != nil {
+			h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to load alerts")
+			return
+		}
+		if err := json.Unmarshal(mismatches, &a.Mismatches); err != nil {
+			h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to load alerts")
+			return
+		}
+		if resolvedAt.Valid {
+			a.ResolvedAt = &resolvedAt.Time
+		}
+		alerts = append(alerts, a)
+	}
+
+	h.jsonResponse(w, http.StatusOK, alerts)
+}
+
+// ResolvePaymentAlert - Closes a payment alert (admin only)
+//
+// "approve" accepts the payment and moves an order held in payment_review to
+// paid. "dismiss" closes the alert without touching the order, e.g. after
+// the payment has been refunded.
+func (h *PaymentHandler) ResolvePaymentAlert(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value("user_id").(int64)
+	alertID, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+
+	var req struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.jsonError(w, http.StatusBadRequest, "INVALID_JSON", "Invalid request body")
+		return
+	}
+
+	var resolution string
+	switch req.Action {
+	case "approve":
+		resolution = "approved"
+	case "dismiss":
+		resolution = "dismissed"
+	default:
+		h.jsonError(w, http.StatusBadRequest, "INVALID_ACTION", "action must be approve or dismiss")
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to resolve alert")
+		return
+	}
+	defer tx.Rollback()
+
+	var orderID int64
+	var paymentIntentID, status string
+	err = tx.QueryRowContext(ctx,
+		"SELECT order_id, payment_intent_id, status FROM payment_alerts WHERE id = $1 FOR UPDATE",
+		alertID,
+	).Scan(&orderID, &paymentIntentID, &status)
+	if err == sql.ErrNoRows {
+		h.jsonError(w, http.StatusNotFound, "NOT_FOUND", "Alert not found")
+		return
+	}
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to resolve alert")
+		return
+	}
+	if status != "open" {
+		h.jsonError(w, http.StatusConflict, "ALERT_RESOLVED", fmt.Sprintf("Alert is already %s", status))
+		return
+	}
+
+	actor := audit.AdminActor(adminID)
+	if resolution == "approved" {
+		var orderStatus orders.Status
+		if err := tx.QueryRowContext(ctx,
+			"SELECT status FROM orders WHERE id = $1", orderID,
+		).Scan(&orderStatus); err != nil {
+			h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to resolve alert")
+			return
+		}
+		if orderStatus != orders.StatusPaymentReview {
+			h.jsonError(w, http.StatusConflict, "ORDER_NOT_IN_REVIEW", fmt.Sprintf("Order is %s; dismiss the alert instead", orderStatus))
+			return
+		}
+
+		// The approved intent is the one any later refund must go against
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE orders SET stripe_payment_intent_id = $1 WHERE id = $2",
+			paymentIntentID, orderID,
+		); err != nil {
+			h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to resolve alert")
+			return
+		}
+
+		if _, err := orders.Transition(ctx, tx, orderID, orders.Change{
+			To:     orders.StatusPaid,
+			Actor:  actor,
+			Reason: fmt.Sprintf("payment alert %d approved", alertID),
+		}); err != nil {
+			h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to resolve alert")
+			return
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE payment_alerts
+		SET status = $1, resolved_by = $2, resolution_note = NULLIF($3, ''), resolved_at = NOW()
+		WHERE id = $4
+	`, resolution, actor, req.Note, alertID); err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to resolve alert")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to resolve alert")
+		return
+	}
+
+	zerolog.Ctx(ctx).Info().Int64("alert_id", alertID).Int64("order_id", orderID).Str("resolution", resolution).Str("actor", actor).Msg("Payment alert resolved")
+
+	h.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"id":       alertID,
+		"order_id": orderID,
+		"status":   resolution,
+	})
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+	"github.com/stripe/stripe-go/v76"
+)
+
+// staleProcessingAfter is how long an event may sit in "processing" before
+// another delivery is allowed to take it over, e.g. after a crash.
+const staleProcessingAfter = 5 * time.Minute
+
+// finishEventTimeout bounds recording an event's outcome, which outlives the
+// request that processed it.
+const finishEventTimeout = 5 * time.Second
+
+// claimEvent stores a verified event and marks it as being processed.
+// It returns false when the event has already been applied or another
+// delivery of it is in progress, in which case the caller should only
+// acknowledge it.
+func (h *PaymentHandler) claimEvent(ctx context.Context, event *stripe.Event, payload []byte) (bool, error) {
+	if _, err := h.db.ExecContext(ctx, `
+		INSERT INTO payment_events (event_id, type, payload)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (event_id) DO NOTHING
+	`, event.ID, string(event.Type), string(payload)); err != nil {
+		return false, fmt.Errorf("failed to store event: %w", err)
+	}
+
+	return h.markProcessing(ctx, event.ID, "received", "failed")
+}
+
+// markProcessing moves an event from one of the given statuses (or a stale
+// "processing") to "processing". It reports whether this caller won the event.
+func (h *PaymentHandler) markProcessing(ctx context.Context, eventID string, from ...string) (bool, error) {
+	var id int64
+	err := h.db.QueryRowContext(ctx, `
+		UPDATE payment_events
+		SET status = 'processing', attempts = attempts + 1, updated_at = NOW()
+		WHERE event_id = $1
+			AND (status = ANY($2) OR (status = 'processing' AND updated_at < NOW() - $3 * INTERVAL '1 second'))
+		RETURNING id
+	`, eventID, pq.Array(from), int(staleProcessingAfter.Seconds())).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim event: %w", err)
+	}
+	return true, nil
+}
+
+// finishEvent records the outcome of processing an event. It runs even if
+// the request was canceled meanwhile, so a client that disconnects does not
+// leave the event in "processing" until it goes stale.
+func (h *PaymentHandler) finishEvent(ctx context.Context, eventID string, procErr error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finishEventTimeout)
+	defer cancel()
+
+	status, errText := "processed", ""
+	switch {
+	case errors.Is(procErr, errUnhandledEvent):
+		status = "ignored"
+	case procErr != nil:
+		status, errText = "failed", procErr.Error()
+	}
+
+	if _, err := h.db.ExecContext(ctx, `
+		UPDATE payment_events
+		SET status = $1,
+			error = NULLIF($2, ''),
+			processed_at = CASE WHEN $1 = 'failed' THEN processed_at ELSE NOW() END,
+			updated_at = NOW()
+		WHERE event_id = $3
+	`, status, errText, eventID); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("event_id", eventID).Msg("Failed to record outcome of event")
+	}
+}
+
+// paymentEvent is a stored webhook event as returned by the admin API.
+type paymentEvent struct {
+	ID          int64      `json:"id"`
+	EventID     string     `json:"event_id"`
+	Type        string     `json:"type"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	Attempts    int        `json:"attempts"`
+	ReceivedAt  time.Time  `json:"received_at"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+}
+
+// ListPaymentEvents - Lists stored webhook events, failed ones by default (admin only)
+func (h *PaymentHandler) ListPaymentEvents(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "failed"
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > 500 {
+		limit = 100
+	}
+
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT id, event_id, type, status, COALESCE(error, ''), attempts, received_at, processed_at
+		FROM payment_events
+		WHERE status = $1
+		ORDER BY received_at DESC
+		LIMIT $2
+	`, status, limit)
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to load events")
+		return
+	}
+	defer rows.Close()
+
+	events := []paymentEvent{}
+	for rows.Next() {
+		var e paymentEvent
+		var processedAt sql.NullTime
+		if err := rows.Scan(&e.ID, &e.EventID, &e.Type, &e.Status, &e.Error, &e.Attempts, &e.ReceivedAt, &processedAt); err != nil {
+			h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to load events")
+			return
+		}
+		if processedAt.Valid {
+			e.ProcessedAt = &processedAt.Time
+		}
+		events = append(events, e)
+	}
+
+	h.jsonResponse(w, http.StatusOK, events)
+}
+
+// ReplayPaymentEvent - Re-runs a failed webhook event from its stored payload (admin only)
+func (h *PaymentHandler) ReplayPaymentEvent(w http.ResponseWriter, r *http.Request) {
+	eventID := mux.Vars(r)["event_id"]
+
+	var payload []byte
+	err := h.db.QueryRowContext(r.Context(),
+		"SELECT payload FROM payment_events WHERE event_id = $1", eventID,
+	).Scan(&payload)
+	if err == sql.ErrNoRows {
+		h.jsonError(w, http.StatusNotFound, "NOT_FOUND", "Event not found")
+		return
+	}
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to load event")
+		return
+	}
+
+	var event stripe.Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		h.jsonError(w, http.StatusUnprocessableEntity, "INVALID_EVENT", "Stored payload is not a valid event")
+		return
+	}
+
+	claimed, err := h.markProcessing(r.Context(), eventID, "failed")
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to claim event")
+		return
+	}
+	if !claimed {
+		h.jsonError(w, http.StatusConflict, "EVENT_NOT_REPLAYABLE", "Only failed events can be replayed")
+		return
+	}
+
+	procErr := h.processEvent(r.Context(), &event)
+	h.finishEvent(r.Context(), eventID, procErr)
+
+	result := map[string]interface{}{
+		"event_id": eventID,
+		"type":     event.Type,
+		"status":   "processed",
+	}
+	switch {
+	case errors.Is(procErr, errUnhandledEvent):
+		result["status"] = "ignored"
+	case procErr != nil:
+		result["status"] = "failed"
+		result["error"] = procErr.Error()
+	}
+	h.jsonResponse(w, http.StatusOK, result)
+}
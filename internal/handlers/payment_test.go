@@ -0,0 +1,459 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v76"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/auth"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/metrics"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/orders"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/services"
+)
+
+// testDB returns a database with the migrations applied to a schema of its
+// own, which is dropped when the test ends. The handlers lean on PostgreSQL
+// row locks and constraints, so these tests need a real server: they are
+// skipped unless TEST_DATABASE_URL is set, e.g. to the docker-compose
+// database.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	schema := fmt.Sprintf("handlers_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	db, err := sql.Open("postgres", withSearchPath(dsn, schema))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	files, err := filepath.Glob("../../migrations/*.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no migrations found: %v", err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		if _, err := db.Exec(string(migration)); err != nil {
+			t.Fatalf("apply %s: %v", filepath.Base(file), err)
+		}
+	}
+	return db
+}
+
+// withSearchPath points every connection opened with dsn at schema. It
+// accepts both URL and key=value connection strings.
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err == nil {
+			q := u.Query()
+			q.Set("search_path", schema)
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+	return dsn + " search_path=" + schema
+}
+
+type fixture struct {
+	db      *sql.DB
+	fake    *services.FakeProvider
+	handler *PaymentHandler
+	userID  int64
+}
+
+func newFixture(t *testing.T) fixture {
+	t.Helper()
+	db := testDB(t)
+	fake := services.NewFakeProvider("", "")
+
+	var userID int64
+	if err := db.QueryRow(
+		"INSERT INTO users (email, password_hash) VALUES ('shopper@example.com', 'x') RETURNING id",
+	).Scan(&userID); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	return fixture{
+		db:      db,
+		fake:    fake,
+		handler: NewPaymentHandler(db, services.NewPaymentService(fake), auth.VerificationPolicy{}),
+		userID:  userID,
+	}
+}
+
+// createOrder inserts an order for the fixture's user. intentID may be
+// empty for an order that has no payment intent yet.
+func (f fixture) createOrder(t *testing.T, totalCents int64, status orders.Status, intentID string) int64 {
+	t.Helper()
+	var orderID int64
+	if err := f.db.QueryRow(`
+		INSERT INTO orders (user_id, total_cents, currency, status, stripe_payment_intent_id)
+		VALUES ($1, $2, 'USD', $3, NULLIF($4, ''))
+		RETURNING id
+	`, f.userID, totalCents, string(status), intentID).Scan(&orderID); err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	return orderID
+}
+
+// paidIntent creates an intent for orderID in the fake provider and settles
+// it, as if the customer had paid amountCents.
+func (f fixture) paidIntent(t *testing.T, orderID, amountCents int64) *stripe.PaymentIntent {
+	t.Helper()
+	ctx := context.Background()
+	pi, err := f.fake.CreatePaymentIntent(ctx, services.CreateIntentParams{
+		AmountCents: amountCents,
+		Currency:    "usd",
+		Metadata:    map[string]string{"order_id": strconv.FormatInt(orderID, 10)},
+	})
+	if err != nil {
+		t.Fatalf("create intent: %v", err)
+	}
+	pi, err = f.fake.SucceedPaymentIntent(pi.ID)
+	if err != nil {
+		t.Fatalf("settle intent: %v", err)
+	}
+	return pi
+}
+
+// deliver signs an event for object and runs it through the webhook
+// handler, returning the event's payload and signature for redelivery.
+func (f fixture) deliver(t *testing.T, eventType stripe.EventType, object interface{}) ([]byte, string) {
+	t.Helper()
+	payload, signature, err := f.fake.SignedEvent(eventType, object)
+	if err != nil {
+		t.Fatalf("sign event: %v", err)
+	}
+	f.redeliver(t, payload, signature)
+	return payload, signature
+}
+
+func (f fixture) redeliver(t *testing.T, payload []byte, signature string) {
+	t.Helper()
+	status, err := f.handler.handleWebhookPayload(context.Background(), payload, signature)
+	if status != http.StatusOK || err != nil {
+		t.Fatalf("webhook answered %d: %v", status, err)
+	}
+}
+
+func (f fixture) orderStatus(t *testing.T, orderID int64) orders.Status {
+	t.Helper()
+	var status orders.Status
+	if err := f.db.QueryRow("SELECT status FROM orders WHERE id = $1", orderID).Scan(&status); err != nil {
+		t.Fatalf("load order %d: %v", orderID, err)
+	}
+	return status
+}
+
+func (f fixture) eventState(t *testing.T, payload []byte) (string, int) {
+	t.Helper()
+	var event struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(payload, &event)
+
+	var status string
+	var attempts int
+	if err := f.db.QueryRow(
+		"SELECT status, attempts FROM payment_events WHERE event_id = $1", event.ID,
+	).Scan(&status, &attempts); err != nil {
+		t.Fatalf("load event %s: %v", event.ID, err)
+	}
+	return status, attempts
+}
+
+func TestWebhookAppliesEventOnce(t *testing.T) {
+	f := newFixture(t)
+
+	orderID := f.createOrder(t, 1000, orders.StatusAwaitingPayment, "")
+	pi := f.paidIntent(t, orderID, 1000)
+	f.db.Exec("UPDATE orders SET stripe_payment_intent_id = $1 WHERE id = $2", pi.ID, orderID)
+
+	payload, signature := f.deliver(t, "payment_intent.succeeded", pi)
+	if got := f.orderStatus(t, orderID); got != orders.StatusPaid {
+		t.Fatalf("order is %s, want paid", got)
+	}
+	if status, attempts := f.eventState(t, payload); status != "processed" || attempts != 1 {
+		t.Errorf("event is %s after %d attempts, want processed after 1", status, attempts)
+	}
+
+	// A redelivery is acknowledged without being claimed again
+	f.redeliver(t, payload, signature)
+	if status, attempts := f.eventState(t, payload); status != "processed" || attempts != 1 {
+		t.Errorf("redelivery was applied: event is %s after %d attempts", status, attempts)
+	}
+
+	var history int
+	f.db.QueryRow("SELECT COUNT(*) FROM order_status_history WHERE order_id = $1 AND to_status = 'paid'", orderID).Scan(&history)
+	if history != 1 {
+		t.Errorf("order was marked paid %d times", history)
+	}
+}
+
+func TestWebhookTakesOverStaleProcessing(t *testing.T) {
+	f := newFixture(t)
+
+	tests := []struct {
+		name       string
+		age        time.Duration
+		wantStatus orders.Status
+		wantEvent  string
+	}{
+		{"stale delivery is taken over", 2 * staleProcessingAfter, orders.StatusPaid, "processed"},
+		{"in-progress delivery is left alone", 0, orders.StatusAwaitingPayment, "processing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orderID := f.createOrder(t, 1000, orders.StatusAwaitingPayment, "")
+			pi := f.paidIntent(t, orderID, 1000)
+			f.db.Exec("UPDATE orders SET stripe_payment_intent_id = $1 WHERE id = $2", pi.ID, orderID)
+
+			payload, signature, err := f.fake.SignedEvent("payment_intent.succeeded", pi)
+			if err != nil {
+				t.Fatalf("sign event: %v", err)
+			}
+			var event stripe.Event
+			json.Unmarshal(payload, &event)
+
+			// An earlier delivery claimed the event and has not finished
+			if _, err := f.db.Exec(`
+				INSERT INTO payment_events (event_id, type, payload, status, attempts, updated_at)
+				VALUES ($1, $2, $3, 'processing', 1, NOW() - $4 * INTERVAL '1 second')
+			`, event.ID, string(event.Type), string(payload), int(tt.age.Seconds())); err != nil {
+				t.Fatalf("insert event: %v", err)
+			}
+
+			f.redeliver(t, payload, signature)
+
+			if got := f.orderStatus(t, orderID); got != tt.wantStatus {
+				t.Errorf("order is %s, want %s", got, tt.wantStatus)
+			}
+			if status, _ := f.eventState(t, payload); status != tt.wantEvent {
+				t.Errorf("event is %s, want %s", status, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestWebhookHoldsMismatchedPaymentForReview(t *testing.T) {
+	f := newFixture(t)
+
+	tests := []struct {
+		name       string
+		status     orders.Status
+		paidCents  int64
+		wantStatus orders.Status
+		wantAlert  string
+	}{
+		{"short payment", orders.StatusAwaitingPayment, 500, orders.StatusPaymentReview, services.MismatchAmount},
+		{"payment for canceled order", orders.StatusCanceled, 1000, orders.StatusCanceled, services.MismatchOrderNotPayable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orderID := f.createOrder(t, 1000, tt.status, "")
+			pi := f.paidIntent(t, orderID, tt.paidCents)
+			f.db.Exec("UPDATE orders SET stripe_payment_intent_id = $1 WHERE id = $2", pi.ID, orderID)
+
+			f.deliver(t, "payment_intent.succeeded", pi)
+
+			if got := f.orderStatus(t, orderID); got != tt.wantStatus {
+				t.Errorf("order is %s, want %s", got, tt.wantStatus)
+			}
+
+			var mismatches string
+			if err := f.db.QueryRow(
+				"SELECT mismatches FROM payment_alerts WHERE order_id = $1 AND payment_intent_id = $2", orderID, pi.ID,
+			).Scan(&mismatches); err != nil {
+				t.Fatalf("no payment alert: %v", err)
+			}
+			if !strings.Contains(mismatches, tt.wantAlert) {
+				t.Errorf("alert %s does not mention %s", mismatches, tt.wantAlert)
+			}
+		})
+	}
+}
+
+// createIntent calls CreatePaymentIntent as the fixture's user and returns
+// the response and the intent ID it answered with.
+func (f fixture) createIntent(t *testing.T, orderID int64, idempotencyKey string) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	body := fmt.Sprintf(`{"order_id": %d}`, orderID)
+	req := httptest.NewRequest(http.MethodPost, "/api/payments/create-intent", bytes.NewBufferString(body))
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", f.userID))
+
+	rec := httptest.NewRecorder()
+	f.handler.CreatePaymentIntent(rec, req)
+
+	var resp struct {
+		Data struct {
+			PaymentIntentID string `json:"payment_intent_id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp.Data.PaymentIntentID
+}
+
+func TestCreatePaymentIntentReusesOrReplaces(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	orderID := f.createOrder(t, 1000, orders.StatusPending, "")
+
+	rec, first := f.createIntent(t, orderID, "checkout-1")
+	if rec.Code != http.StatusOK || first == "" {
+		t.Fatalf("create intent answered %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := f.orderStatus(t, orderID); got != orders.StatusAwaitingPayment {
+		t.Errorf("order is %s, want awaiting_payment", got)
+	}
+
+	// A repeat request gets the same intent, resized to the current total
+	f.db.Exec("UPDATE orders SET total_cents = 1500 WHERE id = $1", orderID)
+	if _, again := f.createIntent(t, orderID, "checkout-2"); again != first {
+		t.Errorf("repeat request got intent %s, want %s reused", again, first)
+	}
+	pi, _ := f.fake.GetPaymentIntent(ctx, first)
+	if pi.Amount != 1500 {
+		t.Errorf("reused intent charges %d, want 1500", pi.Amount)
+	}
+
+	// Once the intent is canceled, even the original key gets a new one
+	f.fake.CancelPaymentIntent(ctx, first)
+	rec, replacement := f.createIntent(t, orderID, "checkout-1")
+	if rec.Code != http.StatusOK || replacement == "" || replacement == first {
+		t.Fatalf("expected a new intent after cancellation, got %d %q: %s", rec.Code, replacement, rec.Body.String())
+	}
+	var stored string
+	f.db.QueryRow("SELECT stripe_payment_intent_id FROM orders WHERE id = $1", orderID).Scan(&stored)
+	if stored != replacement {
+		t.Errorf("order stores intent %s, want %s", stored, replacement)
+	}
+
+	// An intent that is being paid is neither reused nor replaced
+	f.fake.SucceedPaymentIntent(replacement)
+	if rec, _ := f.createIntent(t, orderID, ""); rec.Code != http.StatusConflict {
+		t.Errorf("paying intent answered %d, want 409: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestWebhookIgnoresEventsForReplacedIntent(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	orderID := f.createOrder(t, 1000, orders.StatusPending, "")
+
+	_, old := f.createIntent(t, orderID, "")
+	f.fake.CancelPaymentIntent(ctx, old)
+	_, current := f.createIntent(t, orderID, "")
+	if current == "" || current == old {
+		t.Fatalf("expected the canceled intent %s to be replaced, got %q", old, current)
+	}
+
+	canceled, _ := f.fake.GetPaymentIntent(ctx, old)
+	failed := *canceled
+	failed.Status = stripe.PaymentIntentStatusRequiresPaymentMethod
+
+	for _, event := range []struct {
+		eventType stripe.EventType
+		pi        *stripe.PaymentIntent
+	}{
+		{"payment_intent.canceled", canceled},
+		{"payment_intent.payment_failed", &failed},
+	} {
+		f.deliver(t, event.eventType, event.pi)
+
+		var status orders.Status
+		var paymentStatus, stored string
+		f.db.QueryRow(
+			"SELECT status, payment_status, stripe_payment_intent_id FROM orders WHERE id = $1", orderID,
+		).Scan(&status, &paymentStatus, &stored)
+		if status != orders.StatusAwaitingPayment || paymentStatus == "failed" || stored != current {
+			t.Errorf("%s for the old intent changed the order: %s/%s with intent %s", event.eventType, status, paymentStatus, stored)
+		}
+	}
+}
+
+// paymentOutcomes reads the ioc_payments_total counter for one outcome.
+func paymentOutcomes(t *testing.T, outcome string) float64 {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "ioc_payments_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "outcome" && label.GetValue() == outcome {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestWebhookCountsLateFailureAsStale(t *testing.T) {
+	f := newFixture(t)
+
+	orderID := f.createOrder(t, 1000, orders.StatusAwaitingPayment, "")
+	pi := f.paidIntent(t, orderID, 1000)
+	f.db.Exec("UPDATE orders SET stripe_payment_intent_id = $1 WHERE id = $2", pi.ID, orderID)
+	f.deliver(t, "payment_intent.succeeded", pi)
+
+	failedBefore := paymentOutcomes(t, metrics.PaymentFailed)
+	staleBefore := paymentOutcomes(t, metrics.PaymentStale)
+
+	// A failure for an earlier attempt on the same intent, delivered late
+	failed := *pi
+	failed.Status = stripe.PaymentIntentStatusRequiresPaymentMethod
+	f.deliver(t, "payment_intent.payment_failed", &failed)
+
+	if got := f.orderStatus(t, orderID); got != orders.StatusPaid {
+		t.Errorf("late failure moved the order to %s", got)
+	}
+	if got := paymentOutcomes(t, metrics.PaymentFailed); got != failedBefore {
+		t.Errorf("late failure was counted as failed: %v -> %v", failedBefore, got)
+	}
+	if got := paymentOutcomes(t, metrics.PaymentStale); got != staleBefore+1 {
+		t.Errorf("stale outcomes = %v, want %v", got, staleBefore+1)
+	}
+}
@@ -0,0 +1,621 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/stripe/stripe-go/v76"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/audit"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/orders"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/money"
+)
+
+// refundableStatuses are the order statuses money can be returned from.
+var refundableStatuses = map[orders.Status]bool{
+	orders.StatusPaid:          true,
+	orders.StatusPaymentReview: true,
+	orders.StatusFulfilled:     true,
+	orders.StatusShipped:       true,
+	orders.StatusDelivered:     true,
+}
+
+// refundLine asks for some units of one order line to be refunded.
+type refundLine struct {
+	OrderItemID int64 `json:"order_item_id"`
+	Quantity    int   `json:"quantity"`
+}
+
+// refundItem is a refunded order line as stored in refund_items.
+type refundItem struct {
+	OrderItemID int64       `json:"order_item_id"`
+	ProductID   int64       `json:"product_id"`
+	Quantity    int         `json:"quantity"`
+	Amount      money.Money `json:"amount"`
+}
+
+// orderLine is an order item together with how much of it is still
+// refundable.
+type orderLine struct {
+	ID        int64
+	ProductID int64
+	UnitCents int64
+	Quantity  int
+	Refunded  int
+}
+
+func (l orderLine) remaining() int {
+	return l.Quantity - l.Refunded
+}
+
+// CreateRefund - Refunds all or part of a paid order (admin only)
+//
+// The body may name order lines to refund, a plain amount, or neither for a
+// full refund of the remaining balance. With "restock" set, the refunded
+// lines are returned to product stock. An Idempotency-Key header is required
+// so a retry, by any admin, returns the refund the first attempt made.
+func (h *PaymentHandler) CreateRefund(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value("user_id").(int64)
+	orderID, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if idempotencyKey == "" || len(idempotencyKey) > 200 {
+		h.jsonError(w, http.StatusBadRequest, "INVALID_IDEMPOTENCY_KEY", "An Idempotency-Key header of at most 200 characters is required")
+		return
+	}
+
+	var req struct {
+		AmountCents int64        `json:"amount_cents"`
+		Items       []refundLine `json:"items"`
+		Reason      string       `json:"reason"`
+		Restock     bool         `json:"restock"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.jsonError(w, http.StatusBadRequest, "INVALID_JSON", "Invalid request body")
+		return
+	}
+
+	if req.AmountCents < 0 {
+		h.jsonError(w, http.StatusBadRequest, "INVALID_AMOUNT", "amount_cents must be positive")
+		return
+	}
+	if req.AmountCents > 0 && len(req.Items) > 0 {
+		h.jsonError(w, http.StatusBadRequest, "INVALID_REQUEST", "Specify either items or amount_cents, not both")
+		return
+	}
+	if req.AmountCents > 0 && req.Restock {
+		h.jsonError(w, http.StatusBadRequest, "INVALID_REQUEST", "Restocking requires items or a full refund")
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to create refund")
+		return
+	}
+	defer tx.Rollback()
+
+	var (
+		status          orders.Status
+		totalCents      int64
+		currency        string
+		refundedCents   int64
+		paymentIntentID sql.NullString
+	)
+	err = tx.QueryRowContext(ctx,
+		"SELECT status, total_cents, currency, refunded_cents, stripe_payment_intent_id FROM orders WHERE id = $1 FOR UPDATE",
+		orderID,
+	).Scan(&status, &totalCents, &currency, &refundedCents, &paymentIntentID)
+	if err == sql.ErrNoRows {
+		h.jsonError(w, http.StatusNotFound, "NOT_FOUND", "Order not found")
+		return
+	}
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to create refund")
+		return
+	}
+
+	// A retry of a request that was already recorded returns that refund
+	// before the order is checked again, since the first attempt has since
+	// changed its balance and maybe its status
+	prior, err := loadKeyedRefund(ctx, tx, orderID, idempotencyKey)
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to create refund")
+		return
+	}
+	if prior != nil {
+		h.jsonResponse(w, http.StatusOK, prior)
+		return
+	}
+
+	if !refundableStatuses[status] || !paymentIntentID.Valid {
+		h.jsonError(w, http.StatusConflict, "ORDER_NOT_REFUNDABLE", fmt.Sprintf("Order is %s and cannot be refunded", status))
+		return
+	}
+
+	balance := totalCents - refundedCents
+	if balance <= 0 {
+		h.jsonError(w, http.StatusConflict, "ALREADY_REFUNDED", "Order has been fully refunded")
+		return
+	}
+
+	lines, err := loadOrderLines(ctx, tx, orderID)
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to create refund")
+		return
+	}
+	amount, items, err := planRefund(lines, req.Items, req.AmountCents, balance, currency)
+	var invalid *refundRequestError
+	if errors.As(err, &invalid) {
+		h.jsonError(w, http.StatusBadRequest, invalid.code, invalid.message)
+		return
+	}
+
+	// The key comes from the client rather than from the order's refunds,
+	// which the charge.refunded webhook can add to between an attempt and its
+	// retry. Only stable details go to the provider under it; the admin is
+	// recorded locally.
+	rf, err := h.payments.CreateRefund(ctx, paymentIntentID.String, amount, fmt.Sprintf("refund-%d-%s", orderID, idempotencyKey), map[string]string{
+		"order_id": strconv.FormatInt(orderID, 10),
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Int64("order_id", orderID).Msg("Refund failed")
+		h.jsonError(w, http.StatusBadGateway, "REFUND_FAILED", "Payment provider rejected the refund")
+		return
+	}
+
+	// The webhook may already have recorded the refund if an earlier attempt
+	// reached the provider but did not commit. That row is claimed for this
+	// request instead of being inserted again.
+	actor := audit.AdminActor(adminID)
+	reason := strings.TrimSpace(req.Reason)
+	refundID, restock, claimed, err := claimWebhookRefund(ctx, tx, rf.ID, idempotencyKey, actor, reason, req.Restock, items)
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to record refund")
+		return
+	}
+	if !claimed {
+		refundID, err = insertRefund(ctx, tx, orderID, rf, idempotencyKey, "admin", actor, reason, req.Restock, items)
+		if err != nil {
+			h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to record refund")
+			return
+		}
+		restock = req.Restock
+	}
+
+	if restock {
+		stock := make([]orders.StockLine, 0, len(items))
+		for _, item := range items {
+			stock = append(stock, orders.StockLine{ProductID: item.ProductID, Quantity: item.Quantity})
+		}
+		if err := orders.Restock(ctx, tx, stock); err != nil {
+			h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to restock items")
+			return
+		}
+	}
+
+	// A claimed refund already moved the order when the webhook recorded it
+	var orderStatus orders.Status
+	var paymentStatus string
+	if claimed {
+		err = tx.QueryRowContext(ctx,
+			"SELECT status, COALESCE(payment_status, '') FROM orders WHERE id = $1", orderID,
+		).Scan(&orderStatus, &paymentStatus)
+	} else {
+		orderStatus, paymentStatus, err = applyRefundStatus(ctx, tx, orderID, actor, reason)
+	}
+	if err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to update order")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.jsonError(w, http.StatusInternalServerError, "SERVER_ERROR", "Failed to record refund")
+		return
+	}
+
+	zerolog.Ctx(ctx).Info().Int64("amount_cents", amount).Int64("order_id", orderID).Str("refund_id", rf.ID).Msg("Refunded order")
+
+	if items == nil {
+		items = []refundItem{}
+	}
+	h.jsonResponse(w, http.StatusCreated, map[string]interface{}{
+		"id":               refundID,
+		"order_id":         orderID,
+		"stripe_refund_id": rf.ID,
+		"amount":           money.New(amount, currency),
+		"status":           rf.Status,
+		"restocked":        req.Restock,
+		"items":            items,
+		"order_status":     orderStatus,
+		"payment_status":   paymentStatus,
+	})
+}
+
+// refundRequestError is a refund request that does not fit the order.
+type refundRequestError struct {
+	code    string
+	message string
+}
+
+func (e *refundRequestError) Error() string {
+	return e.message
+}
+
+// planRefund works out the amount and lines of a refund against an order's
+// lines and refundable balance. Named items are refunded at their unit
+// price, a plain amount refunds no lines, and neither refunds everything
+// that is left. The amount never exceeds the balance.
+func planRefund(lines []orderLine, req []refundLine, amountCents, balance int64, currency string) (int64, []refundItem, error) {
+	linesByID := make(map[int64]orderLine, len(lines))
+	for _, line := range lines {
+		linesByID[line.ID] = line
+	}
+
+	var amount int64
+	var items []refundItem
+	switch {
+	case len(req) > 0:
+		for _, item := range req {
+			line, ok := linesByID[item.OrderItemID]
+			if !ok {
+				return 0, nil, &refundRequestError{"INVALID_ITEM", fmt.Sprintf("Order item %d does not belong to this order", item.OrderItemID)}
+			}
+			if item.Quantity <= 0 || item.Quantity > line.remaining() {
+				return 0, nil, &refundRequestError{"INVALID_QUANTITY",
+					fmt.Sprintf("Order item %d has %d refundable units", item.OrderItemID, line.remaining())}
+			}
+			line.Refunded += item.Quantity
+			linesByID[item.OrderItemID] = line
+
+			itemAmount := money.New(line.UnitCents, currency).Mul(item.Quantity)
+			amount += itemAmount.Amount
+			items = append(items, refundItem{
+				OrderItemID: line.ID,
+				ProductID:   line.ProductID,
+				Quantity:    item.Quantity,
+				Amount:      itemAmount,
+			})
+		}
+	case amountCents > 0:
+		amount = amountCents
+	default:
+		amount = balance
+		for _, line := range lines {
+			if line.remaining() > 0 {
+				items = append(items, refundItem{
+					OrderItemID: line.ID,
+					ProductID:   line.ProductID,
+					Quantity:    line.remaining(),
+					Amount:      money.New(line.UnitCents, currency).Mul(line.remaining()),
+				})
+			}
+		}
+	}
+
+	if amount > balance {
+		return 0, nil, &refundRequestError{"REFUND_EXCEEDS_BALANCE",
+			fmt.Sprintf("Refund of %d cents exceeds the refundable balance of %d cents", amount, balance)}
+	}
+	return amount, items, nil
+}
+
+// handleChargeRefunded reconciles refunds made outside the API, e.g. in the
+// Stripe dashboard, into the refunds table. Refunds are matched on their
+// Stripe ID, so ones issued through CreateRefund are not recorded twice.
+func (h *PaymentHandler) handleChargeRefunded(ctx context.Context, ch *stripe.Charge) error {
+	if ch.PaymentIntent == nil || ch.PaymentIntent.ID == "" {
+		return nil
+	}
+
+	refunds, err := h.payments.ListRefunds(ctx, ch.PaymentIntent.ID)
+	if err != nil {
+		return err
+	}
+
+	var orderID int64
+	err = h.db.QueryRowContext(ctx,
+		"SELECT id FROM orders WHERE stripe_payment_intent_id = $1", ch.PaymentIntent.ID,
+	).Scan(&orderID)
+	if err == sql.ErrNoRows {
+		zerolog.Ctx(ctx).Info().Str("payment_intent", ch.PaymentIntent.ID).Msg("Ignoring refund for unknown payment_intent")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find order: %w", err)
+	}
+
+	_, err = h.updateOrder(ctx, orderID, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "SELECT 1 FROM orders WHERE id = $1 FOR UPDATE", orderID); err != nil {
+			return fmt.Errorf("lock order: %w", err)
+		}
+
+		for _, rf := range refunds {
+			var existingStatus string
+			err := tx.QueryRowContext(ctx,
+				"SELECT status FROM refunds WHERE stripe_refund_id = $1", rf.ID,
+			).Scan(&existingStatus)
+
+			switch {
+			case err == sql.ErrNoRows:
+				if _, err := insertRefund(ctx, tx, orderID, rf, "", "webhook", orders.ActorStripe, string(rf.Reason), false, nil); err != nil {
+					return err
+				}
+			case err != nil:
+				return fmt.Errorf("load refund %s: %w", rf.ID, err)
+			case existingStatus != string(rf.Status):
+				if err := updateRefundStatus(ctx, tx, orderID, rf, stripe.RefundStatus(existingStatus)); err != nil {
+					return err
+				}
+			}
+		}
+
+		// The refund rows must be kept even if the order cannot move to
+		// refunded (e.g. it was canceled), so this is not left to updateOrder,
+		// which would roll them back
+		_, _, err := applyRefundStatus(ctx, tx, orderID, orders.ActorStripe, "charge refunded")
+		if errors.Is(err, orders.ErrInvalidTransition) {
+			zerolog.Ctx(ctx).Info().Err(err).Int64("order_id", orderID).Msg("Recorded refunds without changing order status")
+			return nil
+		}
+		return err
+	})
+	return err
+}
+
+// loadOrderLines returns the order's items in id order, with the quantity
+// already covered by earlier refunds.
+func loadOrderLines(ctx context.Context, tx *sql.Tx, orderID int64) ([]orderLine, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT oi.id, COALESCE(oi.product_id, 0), oi.price_cents, oi.quantity,
+			COALESCE((
+				SELECT SUM(ri.quantity)
+				FROM refund_items ri
+				JOIN refunds rf ON rf.id = ri.refund_id
+				WHERE ri.order_item_id = oi.id AND rf.status NOT IN ('failed', 'canceled')
+			), 0)
+		FROM order_items oi
+		WHERE oi.order_id = $1
+		ORDER BY oi.id
+	`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("load order items: %w", err)
+	}
+	defer rows.Close()
+
+	var lines []orderLine
+	for rows.Next() {
+		var line orderLine
+		if err := rows.Scan(&line.ID, &line.ProductID, &line.UnitCents, &line.Quantity, &line.Refunded); err != nil {
+			return nil, fmt.Errorf("scan order item: %w", err)
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// insertRefund records a refund and its lines and adds it to the order's
+// refunded balance if it has not failed. idempotencyKey is empty for refunds
+// that did not come through CreateRefund.
+func insertRefund(ctx context.Context, tx *sql.Tx, orderID int64, rf *stripe.Refund, idempotencyKey, source, createdBy, reason string, restocked bool, items []refundItem) (int64, error) {
+	var refundID int64
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO refunds (order_id, stripe_refund_id, amount_cents, currency, status, reason, restocked, source, created_by, idempotency_key)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9, NULLIF($10, ''))
+		RETURNING id
+	`, orderID, rf.ID, rf.Amount, money.New(rf.Amount, string(rf.Currency)).Currency, string(rf.Status), reason, restocked, source, createdBy, idempotencyKey,
+	).Scan(&refundID)
+	if err != nil {
+		return 0, fmt.Errorf("insert refund: %w", err)
+	}
+
+	if err := insertRefundItems(ctx, tx, refundID, items); err != nil {
+		return 0, err
+	}
+
+	if refundCounts(rf.Status) {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE orders SET refunded_cents = refunded_cents + $1 WHERE id = $2",
+			rf.Amount, orderID,
+		); err != nil {
+			return 0, fmt.Errorf("update refunded balance: %w", err)
+		}
+	}
+	return refundID, nil
+}
+
+func insertRefundItems(ctx context.Context, tx *sql.Tx, refundID int64, items []refundItem) error {
+	for _, item := range items {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO refund_items (refund_id, order_item_id, quantity, amount_cents) VALUES ($1, $2, $3, $4)",
+			refundID, item.OrderItemID, item.Quantity, item.Amount.Amount,
+		); err != nil {
+			return fmt.Errorf("insert refund item: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadKeyedRefund returns the response for the refund an order already
+// recorded under idempotencyKey, or nil if there is none.
+func loadKeyedRefund(ctx context.Context, tx *sql.Tx, orderID int64, idempotencyKey string) (map[string]interface{}, error) {
+	var (
+		refundID       int64
+		stripeRefundID sql.NullString
+		amountCents    int64
+		currency       string
+		status         string
+		restocked      bool
+		orderStatus    orders.Status
+		paymentStatus  string
+	)
+	err := tx.QueryRowContext(ctx, `
+		SELECT rf.id, rf.stripe_refund_id, rf.amount_cents, rf.currency, rf.status, rf.restocked,
+			o.status, COALESCE(o.payment_status, '')
+		FROM refunds rf
+		JOIN orders o ON o.id = rf.order_id
+		WHERE rf.order_id = $1 AND rf.idempotency_key = $2
+	`, orderID, idempotencyKey).Scan(&refundID, &stripeRefundID, &amountCents, &currency, &status, &restocked, &orderStatus, &paymentStatus)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load refund for key: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT ri.order_item_id, COALESCE(oi.product_id, 0), ri.quantity, ri.amount_cents
+		FROM refund_items ri
+		JOIN order_items oi ON oi.id = ri.order_item_id
+		WHERE ri.refund_id = $1
+		ORDER BY ri.id
+	`, refundID)
+	if err != nil {
+		return nil, fmt.Errorf("load refund items: %w", err)
+	}
+	defer rows.Close()
+
+	items := []refundItem{}
+	for rows.Next() {
+		var item refundItem
+		var cents int64
+		if err := rows.Scan(&item.OrderItemID, &item.ProductID, &item.Quantity, &cents); err != nil {
+			return nil, fmt.Errorf("scan refund item: %w", err)
+		}
+		item.Amount = money.New(cents, currency)
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"id":               refundID,
+		"order_id":         orderID,
+		"stripe_refund_id": stripeRefundID.String,
+		"amount":           money.New(amountCents, currency),
+		"status":           status,
+		"restocked":        restocked,
+		"items":            items,
+		"order_status":     orderStatus,
+		"payment_status":   paymentStatus,
+	}, nil
+}
+
+// claimWebhookRefund takes over a refund the charge.refunded webhook
+// recorded for stripeRefundID, storing the request's key, admin, reason and
+// lines on it. It reports whether there was such a refund and whether its
+// lines still need restocking: the restocked flag keeps a refund from being
+// restocked twice.
+func claimWebhookRefund(ctx context.Context, tx *sql.Tx, stripeRefundID, idempotencyKey, createdBy, reason string, restock bool, items []refundItem) (refundID int64, needsRestock, claimed bool, err error) {
+	var restocked bool
+	var itemCount int
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, restocked, (SELECT COUNT(*) FROM refund_items WHERE refund_id = refunds.id)
+		FROM refunds
+		WHERE stripe_refund_id = $1
+		FOR UPDATE
+	`, stripeRefundID).Scan(&refundID, &restocked, &itemCount)
+	if err == sql.ErrNoRows {
+		return 0, false, false, nil
+	}
+	if err != nil {
+		return 0, false, false, fmt.Errorf("load refund %s: %w", stripeRefundID, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE refunds
+		SET idempotency_key = $1, created_by = $2, reason = COALESCE(NULLIF($3, ''), reason),
+			restocked = restocked OR $4, updated_at = NOW()
+		WHERE id = $5
+	`, idempotencyKey, createdBy, reason, restock, refundID); err != nil {
+		return 0, false, false, fmt.Errorf("claim refund %s: %w", stripeRefundID, err)
+	}
+	if itemCount == 0 {
+		if err := insertRefundItems(ctx, tx, refundID, items); err != nil {
+			return 0, false, false, err
+		}
+	}
+	return refundID, restock && !restocked, true, nil
+}
+
+// updateRefundStatus stores a refund's new status, moving its amount in or
+// out of the order's refunded balance when it starts or stops counting.
+func updateRefundStatus(ctx context.Context, tx *sql.Tx, orderID int64, rf *stripe.Refund, previous stripe.RefundStatus) error {
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE refunds SET status = $1, updated_at = NOW() WHERE stripe_refund_id = $2",
+		string(rf.Status), rf.ID,
+	); err != nil {
+		return fmt.Errorf("update refund %s: %w", rf.ID, err)
+	}
+
+	var delta int64
+	switch {
+	case refundCounts(rf.Status) && !refundCounts(previous):
+		delta = rf.Amount
+	case !refundCounts(rf.Status) && refundCounts(previous):
+		delta = -rf.Amount
+	default:
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE orders SET refunded_cents = refunded_cents + $1 WHERE id = $2",
+		delta, orderID,
+	); err != nil {
+		return fmt.Errorf("update refunded balance: %w", err)
+	}
+	return nil
+}
+
+// applyRefundStatus sets the order to refunded once its whole total has been
+// returned, or marks the payment partially refunded otherwise. Partially
+// refunded orders keep their fulfillment status.
+func applyRefundStatus(ctx context.Context, tx *sql.Tx, orderID int64, actor, reason string) (orders.Status, string, error) {
+	var status orders.Status
+	var totalCents, refundedCents int64
+	if err := tx.QueryRowContext(ctx,
+		"SELECT status, total_cents, refunded_cents FROM orders WHERE id = $1", orderID,
+	).Scan(&status, &totalCents, &refundedCents); err != nil {
+		return "", "", fmt.Errorf("load order balance: %w", err)
+	}
+
+	if refundedCents <= 0 {
+		return status, "", nil
+	}
+
+	if refundedCents >= totalCents {
+		if _, err := orders.Transition(ctx, tx, orderID, orders.Change{
+			To:            orders.StatusRefunded,
+			PaymentStatus: "refunded",
+			Actor:         actor,
+			Reason:        reason,
+		}); err != nil {
+			return status, "", err
+		}
+		return orders.StatusRefunded, "refunded", nil
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE orders SET payment_status = 'partially_refunded', updated_at = NOW() WHERE id = $1",
+		orderID,
+	); err != nil {
+		return status, "", fmt.Errorf("update payment status: %w", err)
+	}
+	return status, "partially_refunded", nil
+}
+
+// refundCounts reports whether a refund in this status reduces the order's
+// refundable balance.
+func refundCounts(status stripe.RefundStatus) bool {
+	return status != stripe.RefundStatusFailed && status != stripe.RefundStatusCanceled
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stripe/stripe-go/v76"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/orders"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/services"
+)
+
+func TestPlanRefund(t *testing.T) {
+	lines := []orderLine{
+		{ID: 1, ProductID: 10, UnitCents: 300, Quantity: 2, Refunded: 1},
+		{ID: 2, ProductID: 20, UnitCents: 400, Quantity: 1},
+	}
+	// 300 of the 1000 order has been refunded already
+	const balance = 700
+
+	tests := []struct {
+		name        string
+		items       []refundLine
+		amountCents int64
+		wantAmount  int64
+		wantItems   int
+		wantCode    string
+	}{
+		{name: "remaining balance", wantAmount: 700, wantItems: 2},
+		{name: "named items", items: []refundLine{{OrderItemID: 2, Quantity: 1}}, wantAmount: 400, wantItems: 1},
+		{name: "plain amount", amountCents: 700, wantAmount: 700},
+		{name: "amount over balance", amountCents: 701, wantCode: "REFUND_EXCEEDS_BALANCE"},
+		{name: "already refunded units", items: []refundLine{{OrderItemID: 1, Quantity: 2}}, wantCode: "INVALID_QUANTITY"},
+		{name: "same line twice", items: []refundLine{{OrderItemID: 2, Quantity: 1}, {OrderItemID: 2, Quantity: 1}}, wantCode: "INVALID_QUANTITY"},
+		{name: "line from another order", items: []refundLine{{OrderItemID: 3, Quantity: 1}}, wantCode: "INVALID_ITEM"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amount, items, err := planRefund(lines, tt.items, tt.amountCents, balance, "USD")
+			if tt.wantCode != "" {
+				var invalid *refundRequestError
+				if !errors.As(err, &invalid) || invalid.code != tt.wantCode {
+					t.Fatalf("got error %v, want %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if amount != tt.wantAmount || len(items) != tt.wantItems {
+				t.Errorf("got %d cents over %d items, want %d over %d", amount, len(items), tt.wantAmount, tt.wantItems)
+			}
+		})
+	}
+}
+
+// refund calls CreateRefund as an admin with the given body and
+// idempotency key.
+func (f fixture) refund(t *testing.T, orderID int64, key, body string) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/orders/"+strconv.FormatInt(orderID, 10)+"/refunds", bytes.NewBufferString(body))
+	req = mux.SetURLVars(req, map[string]string{"id": strconv.FormatInt(orderID, 10)})
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", f.userID))
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+
+	rec := httptest.NewRecorder()
+	f.handler.CreateRefund(rec, req)
+
+	var resp struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp.Error.Code
+}
+
+func TestCreateRefundStaysWithinBalance(t *testing.T) {
+	f := newFixture(t)
+
+	orderID := f.createOrder(t, 1000, orders.StatusPaid, "")
+	pi := f.paidIntent(t, orderID, 1000)
+	f.db.Exec("UPDATE orders SET stripe_payment_intent_id = $1 WHERE id = $2", pi.ID, orderID)
+	for _, item := range []struct{ price, quantity int64 }{{300, 2}, {400, 1}} {
+		if _, err := f.db.Exec(
+			"INSERT INTO order_items (order_id, quantity, price_cents, subtotal_cents) VALUES ($1, $2, $3, $4)",
+			orderID, item.quantity, item.price, item.price*item.quantity,
+		); err != nil {
+			t.Fatalf("create order item: %v", err)
+		}
+	}
+
+	if rec, _ := f.refund(t, orderID, "partial", `{"amount_cents": 300}`); rec.Code != http.StatusCreated {
+		t.Fatalf("partial refund answered %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec, code := f.refund(t, orderID, "over-balance", `{"amount_cents": 800}`); rec.Code != http.StatusBadRequest || code != "REFUND_EXCEEDS_BALANCE" {
+		t.Errorf("refund over balance answered %d %s, want 400 REFUND_EXCEEDS_BALANCE", rec.Code, code)
+	}
+	if rec, _ := f.refund(t, orderID, "balance", `{}`); rec.Code != http.StatusCreated {
+		t.Fatalf("refund of the balance answered %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var refunded int64
+	f.db.QueryRow("SELECT refunded_cents FROM orders WHERE id = $1", orderID).Scan(&refunded)
+	if refunded != 1000 {
+		t.Errorf("refunded %d cents, want 1000", refunded)
+	}
+	if got := f.orderStatus(t, orderID); got != orders.StatusRefunded {
+		t.Errorf("order is %s, want refunded", got)
+	}
+	if rec, _ := f.refund(t, orderID, "refunded", `{}`); rec.Code != http.StatusConflict {
+		t.Errorf("refund of a refunded order answered %d, want 409", rec.Code)
+	}
+}
+
+func TestChargeRefundedKeepsRefundsForCanceledOrder(t *testing.T) {
+	f := newFixture(t)
+
+	orderID := f.createOrder(t, 1000, orders.StatusCanceled, "")
+	pi := f.paidIntent(t, orderID, 1000)
+	f.db.Exec("UPDATE orders SET stripe_payment_intent_id = $1 WHERE id = $2", pi.ID, orderID)
+
+	// Refunded in the dashboard rather than through the API
+	if _, err := f.fake.CreateRefund(context.Background(), services.RefundParams{PaymentIntentID: pi.ID}); err != nil {
+		t.Fatalf("create refund: %v", err)
+	}
+	f.deliver(t, "charge.refunded", &stripe.Charge{ID: "ch_fake_1", PaymentIntent: &stripe.PaymentIntent{ID: pi.ID}})
+
+	var count int
+	var refunded int64
+	f.db.QueryRow("SELECT COUNT(*) FROM refunds WHERE order_id = $1", orderID).Scan(&count)
+	f.db.QueryRow("SELECT refunded_cents FROM orders WHERE id = $1", orderID).Scan(&refunded)
+	if count != 1 || refunded != 1000 {
+		t.Errorf("recorded %d refunds for %d cents, want 1 for 1000", count, refunded)
+	}
+	if got := f.orderStatus(t, orderID); got != orders.StatusCanceled {
+		t.Errorf("order is %s, want canceled", got)
+	}
+}
+
+func TestCreateRefundAfterFailedRefund(t *testing.T) {
+	f := newFixture(t)
+
+	orderID := f.createOrder(t, 1000, orders.StatusPaid, "")
+	pi := f.paidIntent(t, orderID, 1000)
+	f.db.Exec("UPDATE orders SET stripe_payment_intent_id = $1 WHERE id = $2", pi.ID, orderID)
+
+	if rec, _ := f.refund(t, orderID, "first", `{"amount_cents": 300}`); rec.Code != http.StatusCreated {
+		t.Fatalf("first refund answered %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The refund fails at the bank, handing its amount back to the balance
+	f.db.Exec("UPDATE refunds SET status = 'failed' WHERE order_id = $1", orderID)
+	f.db.Exec("UPDATE orders SET refunded_cents = 0 WHERE id = $1", orderID)
+
+	if rec, _ := f.refund(t, orderID, "second", `{"amount_cents": 500}`); rec.Code != http.StatusCreated {
+		t.Fatalf("refund after a failed one answered %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var count int
+	var refunded int64
+	f.db.QueryRow("SELECT COUNT(DISTINCT stripe_refund_id) FROM refunds WHERE order_id = $1", orderID).Scan(&count)
+	f.db.QueryRow("SELECT refunded_cents FROM orders WHERE id = $1", orderID).Scan(&refunded)
+	if count != 2 || refunded != 500 {
+		t.Errorf("got %d provider refunds and %d cents refunded, want 2 and 500", count, refunded)
+	}
+}
+
+func TestCreateRefundRepeatsForSameKey(t *testing.T) {
+	f := newFixture(t)
+
+	orderID := f.createOrder(t, 1000, orders.StatusPaid, "")
+	pi := f.paidIntent(t, orderID, 1000)
+	f.db.Exec("UPDATE orders SET stripe_payment_intent_id = $1 WHERE id = $2", pi.ID, orderID)
+
+	if rec, code := f.refund(t, orderID, "", `{}`); rec.Code != http.StatusBadRequest || code != "INVALID_IDEMPOTENCY_KEY" {
+		t.Errorf("refund without a key answered %d %s, want 400 INVALID_IDEMPOTENCY_KEY", rec.Code, code)
+	}
+
+	first, _ := f.refund(t, orderID, "full", `{}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("refund answered %d: %s", first.Code, first.Body.String())
+	}
+	// The order is fully refunded now, but the same request still gets the
+	// refund it made rather than a 409
+	again, _ := f.refund(t, orderID, "full", `{}`)
+	if again.Code != http.StatusOK {
+		t.Fatalf("repeated refund answered %d: %s", again.Code, again.Body.String())
+	}
+
+	var a, b struct {
+		ID             int64  `json:"id"`
+		StripeRefundID string `json:"stripe_refund_id"`
+	}
+	json.Unmarshal(first.Body.Bytes(), &a)
+	json.Unmarshal(again.Body.Bytes(), &b)
+	if a.ID == 0 || a != b {
+		t.Errorf("repeated refund returned %+v, want %+v", b, a)
+	}
+}
+
+func TestCreateRefundRetryAfterWebhook(t *testing.T) {
+	f := newFixture(t)
+
+	orderID := f.createOrder(t, 1000, orders.StatusPaid, "")
+	pi := f.paidIntent(t, orderID, 1000)
+	f.db.Exec("UPDATE orders SET stripe_payment_intent_id = $1 WHERE id = $2", pi.ID, orderID)
+
+	var productID, itemID int64
+	if err := f.db.QueryRow(
+		"INSERT INTO products (name, price_cents, stock) VALUES ('Mug', 300, 5) RETURNING id",
+	).Scan(&productID); err != nil {
+		t.Fatalf("create product: %v", err)
+	}
+	if err := f.db.QueryRow(
+		"INSERT INTO order_items (order_id, product_id, quantity, price_cents, subtotal_cents) VALUES ($1, $2, 1, 300, 300) RETURNING id",
+		orderID, productID,
+	).Scan(&itemID); err != nil {
+		t.Fatalf("create order item: %v", err)
+	}
+
+	// The first attempt reached the provider but timed out before it was
+	// recorded, and the webhook recorded its refund before the retry
+	if _, err := f.fake.CreateRefund(context.Background(), services.RefundParams{
+		PaymentIntentID: pi.ID,
+		AmountCents:     300,
+		IdempotencyKey:  fmt.Sprintf("refund-%d-retry", orderID),
+	}); err != nil {
+		t.Fatalf("create refund: %v", err)
+	}
+	f.deliver(t, "charge.refunded", &stripe.Charge{ID: "ch_fake_1", PaymentIntent: &stripe.PaymentIntent{ID: pi.ID}})
+
+	body := fmt.Sprintf(`{"items": [{"order_item_id": %d, "quantity": 1}], "restock": true}`, itemID)
+	if rec, _ := f.refund(t, orderID, "retry", body); rec.Code != http.StatusCreated {
+		t.Fatalf("retry answered %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var count, stock int
+	var refunded int64
+	var restocked bool
+	f.db.QueryRow("SELECT COUNT(*), bool_and(restocked) FROM refunds WHERE order_id = $1", orderID).Scan(&count, &restocked)
+	f.db.QueryRow("SELECT refunded_cents FROM orders WHERE id = $1", orderID).Scan(&refunded)
+	f.db.QueryRow("SELECT stock FROM products WHERE id = $1", productID).Scan(&stock)
+	if count != 1 || refunded != 300 {
+		t.Errorf("recorded %d refunds for %d cents, want 1 for 300", count, refunded)
+	}
+	if !restocked || stock != 6 {
+		t.Errorf("restocked = %v with stock %d, want true with 6", restocked, stock)
+	}
+
+	// Repeating the retry neither refunds nor restocks again
+	if rec, _ := f.refund(t, orderID, "retry", body); rec.Code != http.StatusOK {
+		t.Fatalf("repeated retry answered %d: %s", rec.Code, rec.Body.String())
+	}
+	f.db.QueryRow("SELECT stock FROM products WHERE id = $1", productID).Scan(&stock)
+	if stock != 6 {
+		t.Errorf("stock is %d after a repeated retry, want 6", stock)
+	}
+}
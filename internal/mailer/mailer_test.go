@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(dir, "Shop <shop@example.com>")
+	if err != nil {
+		t.Fatalf("create mailer: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := m.Send(context.Background(), Message{
+			To:      "user@example.com",
+			Subject: "Reset your password",
+			Text:    "Line one\nLine two",
+		}); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 2 {
+		t.Fatalf("expected 2 messages in outbox, got %d", len(files))
+	}
+
+	data, _ := os.ReadFile(files[0])
+	body := string(data)
+	for _, want := range []string{
+		"From: Shop <shop@example.com>\r\n",
+		"To: user@example.com\r\n",
+		"Subject: Reset your password\r\n",
+		"\r\n\r\nLine one\r\nLine two",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("message is missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestAddress(t *testing.T) {
+	tests := map[string]string{
+		"Shop <shop@example.com>": "shop@example.com",
+		"shop@example.com":        "shop@example.com",
+	}
+	for in, want := range tests {
+		if got := address(in); got != want {
+			t.Errorf("address(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNewFromEnvRejectsUnknownDriver(t *testing.T) {
+	t.Setenv("MAIL_DRIVER", "pigeon")
+	if _, err := NewFromEnv(); err == nil {
+		t.Error("expected unknown driver to be rejected")
+	}
+}
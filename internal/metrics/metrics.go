@@ -0,0 +1,173 @@
+// Package metrics exposes the API's Prometheus metrics: HTTP traffic per
+// route, database and Redis connection pools, and business events.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/middleware"
+)
+
+const namespace = "ioc"
+
+// Registry holds every metric served by Handler. A dedicated registry keeps
+// metrics registered by libraries out of the output.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, route template and status code.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"method", "route", "status"})
+
+	ordersCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_created_total",
+		Help:      "Orders created at checkout.",
+	})
+
+	payments = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payments_total",
+		Help:      "Payment outcomes reported by the payment provider: succeeded, review, failed, canceled or stale.",
+	}, []string{"outcome"})
+
+	webhookEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_total",
+		Help:      "Verified payment webhook events by event type and result.",
+	}, []string{"type", "result"})
+
+	cartsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "carts_created_total",
+		Help:      "Shopping carts created.",
+	})
+)
+
+// Payment outcomes for PaymentOutcome.
+const (
+	PaymentSucceeded = "succeeded"
+	PaymentReview    = "review"
+	PaymentFailed    = "failed"
+	PaymentCanceled  = "canceled"
+	// PaymentStale is an event for an intent the order has since replaced,
+	// or one the order has moved past (e.g. a late failure after payment).
+	PaymentStale = "stale"
+)
+
+// Webhook results for WebhookEvent.
+const (
+	WebhookProcessed = "processed"
+	WebhookDuplicate = "duplicate"
+	WebhookUnhandled = "unhandled"
+	WebhookFailed    = "failed"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		ordersCreated,
+		payments,
+		webhookEvents,
+		cartsCreated,
+	)
+}
+
+// Handler serves the registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// RegisterDB exports the connection pool statistics of db.
+func RegisterDB(db *sql.DB, name string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterRedis exports the connection pool statistics of client.
+func RegisterRedis(client *redis.Client) {
+	Registry.MustRegister(newRedisCollector(client))
+}
+
+// Middleware counts requests and observes their latency. Register it with
+// the router's Use so the matched route template is known; labelling by
+// template rather than path keeps IDs out of the label values.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		start := time.Now()
+		rec := middleware.NewStatusRecorder(w)
+		completed := false
+		defer func() {
+			// A panicking handler is answered with a 500 further up
+			status := rec.Status()
+			if !completed {
+				status = http.StatusInternalServerError
+			}
+			labels := prometheus.Labels{"method": methodLabel(r.Method), "route": route, "status": strconv.Itoa(status)}
+			httpRequests.With(labels).Inc()
+			httpDuration.With(labels).Observe(time.Since(start).Seconds())
+		}()
+
+		next.ServeHTTP(rec, r)
+		completed = true
+	})
+}
+
+// methodLabel keeps the method label bounded: clients can send any token
+// as a method, so anything non-standard is counted as "other".
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// OrderCreated counts a committed checkout.
+func OrderCreated() {
+	ordersCreated.Inc()
+}
+
+// PaymentOutcome counts a payment result, one of the Payment constants.
+func PaymentOutcome(outcome string) {
+	payments.WithLabelValues(outcome).Inc()
+}
+
+// WebhookEvent counts a verified webhook event of eventType with one of the
+// Webhook result constants.
+func WebhookEvent(eventType, result string) {
+	webhookEvents.WithLabelValues(eventType, result).Inc()
+}
+
+// CartCreated counts a new shopping cart.
+func CartCreated() {
+	cartsCreated.Inc()
+}
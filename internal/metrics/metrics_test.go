@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddlewareLabelsByRouteTemplate(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.HandleFunc("/api/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "404" {
+			http.NotFound(w, r)
+		}
+	})
+
+	for _, path := range []string{"/api/orders/1", "/api/orders/2", "/api/orders/404"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	ok := httpRequests.With(prometheus.Labels{"method": "GET", "route": "/api/orders/{id}", "status": "200"})
+	if got := testutil.ToFloat64(ok); got != 2 {
+		t.Errorf("200 count = %v, want 2", got)
+	}
+	notFound := httpRequests.With(prometheus.Labels{"method": "GET", "route": "/api/orders/{id}", "status": "404"})
+	if got := testutil.ToFloat64(notFound); got != 1 {
+		t.Errorf("404 count = %v, want 1", got)
+	}
+	if n := testutil.CollectAndCount(httpDuration); n != 2 {
+		t.Errorf("expected 2 latency series, got %d", n)
+	}
+}
+
+func TestMiddlewareCountsPanicsAs500(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.HandleFunc("/boom", func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+
+	func() {
+		defer func() { recover() }()
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/boom", nil))
+	}()
+
+	c := httpRequests.With(prometheus.Labels{"method": "POST", "route": "/boom", "status": "500"})
+	if got := testutil.ToFloat64(c); got != 1 {
+		t.Errorf("500 count = %v, want 1", got)
+	}
+}
+
+func TestMiddlewareBoundsMethodLabel(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.HandleFunc("/api/cart", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, method := range []string{"FOO", "BAR", "get"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/api/cart", nil))
+	}
+
+	c := httpRequests.With(prometheus.Labels{"method": "other", "route": "/api/cart", "status": "200"})
+	if got := testutil.ToFloat64(c); got != 3 {
+		t.Errorf("other count = %v, want 3", got)
+	}
+}
+
+func TestBusinessCounters(t *testing.T) {
+	before := testutil.ToFloat64(payments.WithLabelValues(PaymentFailed))
+	PaymentOutcome(PaymentFailed)
+	if got := testutil.ToFloat64(payments.WithLabelValues(PaymentFailed)); got != before+1 {
+		t.Errorf("payments failed = %v, want %v", got, before+1)
+	}
+
+	WebhookEvent("charge.refunded", WebhookProcessed)
+	if got := testutil.ToFloat64(webhookEvents.WithLabelValues("charge.refunded", WebhookProcessed)); got != 1 {
+		t.Errorf("webhook events = %v, want 1", got)
+	}
+}
+
+func TestHandlerServesTextFormat(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	defer client.Close()
+	RegisterRedis(client)
+
+	OrderCreated()
+	CartCreated()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"ioc_orders_created_total",
+		"ioc_carts_created_total",
+		"ioc_redis_pool_connections",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output is missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/clientip"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/response"
+)
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// requestInfo collects what the access log needs but only becomes known
+// further down the chain: the matched route and the authenticated user.
+type requestInfo struct {
+	mu     sync.Mutex
+	id     string
+	route  string
+	userID int
+}
+
+type requestInfoKey struct{}
+
+func infoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// RequestID returns the ID Logging assigned to the request, or "".
+func RequestID(ctx context.Context) string {
+	if info := infoFromContext(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// Logging wraps the whole router. It accepts the client's X-Request-ID or
+// generates one, echoes it in the response, stores a logger carrying it in
+// the request context (read with zerolog.Ctx), recovers panics into a 500
+// JSON error and writes one access log line per request.
+func Logging(logger zerolog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			info := &requestInfo{id: id}
+			logCtx := logger.With().Str("request_id", id)
+			// Set when tracing wraps this middleware, so log lines can be
+			// matched to the request's trace
+			if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+				logCtx = logCtx.Str("trace_id", sc.TraceID().String()).Str("span_id", sc.SpanID().String())
+			}
+			reqLogger := logCtx.Logger()
+			ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+			ctx = reqLogger.WithContext(ctx)
+
+			rec := NewStatusRecorder(w)
+			defer func() {
+				if err := recover(); err != nil {
+					if err == http.ErrAbortHandler {
+						panic(err)
+					}
+					reqLogger.Error().
+						Interface("panic", err).
+						Bytes("stack", debug.Stack()).
+						Str("method", r.Method).
+						Str("path", r.URL.Path).
+						Msg("Recovered from panic")
+					if !rec.WroteHeader() {
+						response.Error(rec, http.StatusInternalServerError, "INTERNAL_ERROR", "An unexpected error occurred")
+					}
+				}
+
+				info.mu.Lock()
+				route, userID := info.route, info.userID
+				info.mu.Unlock()
+
+				status := rec.Status()
+
+				event := reqLogger.Info()
+				switch {
+				case status >= 500:
+					event = reqLogger.Error()
+				case status >= 400:
+					event = reqLogger.Warn()
+				}
+				if route == "" {
+					route = "unmatched"
+				}
+				event = event.
+					Str("method", r.Method).
+					Str("route", route).
+					Str("path", r.URL.Path).
+					Int("status", status).
+					Dur("latency", time.Since(start)).
+					Int64("bytes", rec.BytesWritten()).
+					Str("ip", clientip.FromRequest(r))
+				if userID != 0 {
+					event = event.Int("user_id", userID)
+				}
+				event.Msg("request")
+			}()
+
+			next.ServeHTTP(rec, r.WithContext(ctx))
+		})
+	}
+}
+
+// RouteTemplate records the matched route's path template (e.g.
+// /api/orders/{id}) for the access log. Register it with the router's Use
+// so it runs after matching.
+func RouteTemplate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := infoFromContext(r.Context()); info != nil {
+			if route := mux.CurrentRoute(r); route != nil {
+				if tpl, err := route.GetPathTemplate(); err == nil {
+					info.mu.Lock()
+					info.route = tpl
+					info.mu.Unlock()
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WithUserID attaches the authenticated user to the request's logger and
+// access log line. Auth middleware calls it once the token is verified.
+func WithUserID(ctx context.Context, userID int) context.Context {
+	if info := infoFromContext(ctx); info != nil {
+		info.mu.Lock()
+		info.userID = userID
+		info.mu.Unlock()
+	}
+	logger := zerolog.Ctx(ctx).With().Int("user_id", userID).Logger()
+	return logger.WithContext(ctx)
+}
+
+// validRequestID accepts client-supplied IDs of sane length made of
+// characters that are safe to log and echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// StatusRecorder wraps a ResponseWriter to capture the status code and
+// body size of the response. Logging and metrics.Middleware both use it.
+type StatusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+// NewStatusRecorder wraps w.
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w}
+}
+
+// Status returns the status code sent, 200 if the handler never set one.
+func (rec *StatusRecorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// BytesWritten returns the size of the response body written so far.
+func (rec *StatusRecorder) BytesWritten() int64 {
+	return rec.bytes
+}
+
+// WroteHeader reports whether the response has started.
+func (rec *StatusRecorder) WroteHeader() bool {
+	return rec.wroteHeader
+}
+
+func (rec *StatusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *StatusRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses working through the recorder.
+func (rec *StatusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack keeps connection upgrades (e.g. WebSockets) working through the
+// recorder when the underlying writer supports them.
+func (rec *StatusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && !rec.wroteHeader {
+		rec.status = http.StatusSwitchingProtocols
+		rec.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *StatusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
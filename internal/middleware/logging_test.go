@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logLines decodes the JSON lines written to buf.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestLoggingAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+
+	var handlerRequestID string
+	r := mux.NewRouter()
+	r.Use(RouteTemplate)
+	r.HandleFunc("/api/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerRequestID = RequestID(r.Context())
+		ctx := WithUserID(r.Context(), 42)
+		zerolog.Ctx(ctx).Info().Msg("loading order")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+	handler := Logging(logger)(r)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/orders/7", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(RequestIDHeader); got != "abc-123" {
+		t.Errorf("response X-Request-ID = %q, want abc-123", got)
+	}
+	if handlerRequestID != "abc-123" {
+		t.Errorf("RequestID in handler = %q, want abc-123", handlerRequestID)
+	}
+
+	lines := logLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %d: %s", len(lines), buf.String())
+	}
+
+	handlerLine := lines[0]
+	if handlerLine["request_id"] != "abc-123" || handlerLine["user_id"] != float64(42) {
+		t.Errorf("handler log line missing request context: %v", handlerLine)
+	}
+
+	access := lines[1]
+	want := map[string]interface{}{
+		"request_id": "abc-123",
+		"method":     "GET",
+		"route":      "/api/orders/{id}",
+		"path":       "/api/orders/7",
+		"status":     float64(201),
+		"bytes":      float64(5),
+		"user_id":    float64(42),
+		"level":      "info",
+	}
+	for k, v := range want {
+		if access[k] != v {
+			t.Errorf("access log %s = %v, want %v", k, access[k], v)
+		}
+	}
+	if _, ok := access["latency"]; !ok {
+		t.Error("access log has no latency")
+	}
+}
+
+func TestLoggingGeneratesRequestID(t *testing.T) {
+	handler := Logging(zerolog.Nop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, incoming := range []string{"", "has spaces", strings.Repeat("a", 200)} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if incoming != "" {
+			req.Header.Set(RequestIDHeader, incoming)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		got := rec.Header().Get(RequestIDHeader)
+		if got == incoming || len(got) != 32 {
+			t.Errorf("incoming %q: expected a generated ID, got %q", incoming, got)
+		}
+	}
+}
+
+func TestLoggingRecoversPanics(t *testing.T) {
+	var buf bytes.Buffer
+	handler := Logging(zerolog.New(&buf))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/cart", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"INTERNAL_ERROR"`) {
+		t.Errorf("unexpected body: %s", rec.Body.String())
+	}
+
+	lines := logLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected panic and access log lines, got %d", len(lines))
+	}
+	if lines[0]["panic"] != "boom" || lines[0]["stack"] == nil {
+		t.Errorf("panic log line = %v", lines[0])
+	}
+	if lines[1]["status"] != float64(500) || lines[1]["route"] != "unmatched" || lines[1]["level"] != "error" {
+		t.Errorf("access log line = %v", lines[1])
+	}
+}
+
+func TestLoggingAddsTraceID(t *testing.T) {
+	var buf bytes.Buffer
+	handler := Logging(zerolog.New(&buf))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(trace.ContextWithSpanContext(req.Context(), sc))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := logLines(t, &buf)
+	if len(lines) != 1 || lines[0]["trace_id"] != traceID.String() || lines[0]["span_id"] != spanID.String() {
+		t.Errorf("access log should carry the trace, got %v", lines)
+	}
+}
+
+func TestStatusRecorderPassThrough(t *testing.T) {
+	w := httptest.NewRecorder()
+	rec := NewStatusRecorder(w)
+
+	if rec.Status() != http.StatusOK || rec.WroteHeader() {
+		t.Errorf("expected an unwritten 200, got %d written=%v", rec.Status(), rec.WroteHeader())
+	}
+
+	rec.WriteHeader(http.StatusAccepted)
+	rec.WriteHeader(http.StatusTeapot)
+	rec.Write([]byte("hello"))
+	if rec.Status() != http.StatusAccepted || rec.BytesWritten() != 5 {
+		t.Errorf("expected 202 and 5 bytes, got %d and %d", rec.Status(), rec.BytesWritten())
+	}
+
+	if err := http.NewResponseController(rec).Flush(); err != nil || !w.Flushed {
+		t.Errorf("expected Flush to reach the underlying writer, err %v", err)
+	}
+	// httptest.ResponseRecorder cannot be hijacked
+	if _, _, err := rec.Hijack(); err != http.ErrNotSupported {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
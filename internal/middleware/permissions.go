@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/auth"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/response"
+)
+
+// RequirePermission only lets requests through whose access token grants
+// every listed permission. It reads the claims stored by the auth
+// middleware, so it must run after it. It can be used with a gorilla/mux
+// subrouter's Use or to wrap a single handler.
+func RequirePermission(permissions ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := auth.ClaimsFromContext(r.Context())
+			if !ok {
+				response.Error(w, http.StatusUnauthorized, "UNAUTHORIZED", "Authentication required")
+				return
+			}
+
+			for _, permission := range permissions {
+				if !claims.HasPermission(permission) {
+					response.Error(w, http.StatusForbidden, "FORBIDDEN", "Missing permission: "+permission)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
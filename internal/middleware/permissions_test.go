@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/auth"
+)
+
+func TestRequirePermission(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := RequirePermission(auth.PermAdminAccess, auth.PermOrdersRefund)(ok)
+
+	tests := []struct {
+		name   string
+		claims *auth.Claims
+		want   int
+	}{
+		{name: "no claims", claims: nil, want: http.StatusUnauthorized},
+		{name: "no permissions", claims: &auth.Claims{UserID: 1}, want: http.StatusForbidden},
+		{
+			name:   "one of two permissions",
+			claims: &auth.Claims{UserID: 1, Permissions: []string{auth.PermAdminAccess}},
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "all permissions",
+			claims: &auth.Claims{UserID: 1, Permissions: []string{auth.PermOrdersRefund, auth.PermAdminAccess}},
+			want:   http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/admin/orders/1/refunds", nil)
+			if tt.claims != nil {
+				req = req.WithContext(auth.WithClaims(req.Context(), tt.claims))
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
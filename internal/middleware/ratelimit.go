@@ -1,94 +1,259 @@
 package middleware
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/go-redis/redis/v8"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/auth"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/clientip"
 	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/response"
 )
 
-type rateLimiter struct {
-	requests map[string]*clientInfo
-	mu       sync.RWMutex
-	limit    int
-	window   time.Duration
+// KeyFunc picks the bucket a request is counted in.
+type KeyFunc func(r *http.Request) string
+
+// RateLimitPolicy allows Limit requests per Window for each key. Name
+// separates the counters of different route groups.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+	Key    KeyFunc
+}
+
+// RateLimitResult is the state of a bucket after a request was counted.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the oldest request in the window expires and
+	// a slot frees up.
+	Reset time.Duration
 }
 
-type clientInfo struct {
-	count      int
-	resetAt    time.Time
-	lastAccess time.Time
+// RateLimiter enforces sliding-window limits. Counters live in Redis so all
+// replicas share them; an in-memory store is used when redis is nil or
+// failing, in which case each replica counts on its own.
+type RateLimiter struct {
+	redis  *redis.Client
+	memory *memoryWindows
+	now    func() time.Time
 }
 
-func NewRateLimiter(requestsPerWindow int, window time.Duration) *rateLimiter {
-	rl := &rateLimiter{
-		requests: make(map[string]*clientInfo),
-		limit:    requestsPerWindow,
-		window:   window,
+// NewRateLimiter returns a RateLimiter. redisClient may be nil.
+func NewRateLimiter(redisClient *redis.Client) *RateLimiter {
+	rl := &RateLimiter{
+		redis:  redisClient,
+		memory: newMemoryWindows(),
+		now:    time.Now,
 	}
 
 	go rl.cleanup()
 	return rl
 }
 
-func (rl *rateLimiter) cleanup() {
+func (rl *RateLimiter) cleanup() {
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		rl.mu.Lock()
-		now := time.Now()
-		for ip, info := range rl.requests {
-			if now.Sub(info.lastAccess) > 5*time.Minute {
-				delete(rl.requests, ip)
+		rl.memory.sweep(rl.now())
+	}
+}
+
+// Limit returns middleware enforcing policy, for use with a gorilla/mux
+// subrouter's Use or to wrap a single handler. Every response carries
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers; a
+// rejected request gets 429 with Retry-After.
+func (rl *RateLimiter) Limit(policy RateLimitPolicy) func(http.Handler) http.Handler {
+	keyFunc := policy.Key
+	if keyFunc == nil {
+		keyFunc = KeyByIP
+	}
+	policyHeader := fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Window.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "ratelimit:" + policy.Name + ":" + keyFunc(r)
+			res := rl.Allow(r.Context(), key, policy.Limit, policy.Window)
+
+			h := w.Header()
+			h.Set("RateLimit-Policy", policyHeader)
+			h.Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+			if !res.Allowed {
+				h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.Reset)))
+				response.Error(w, http.StatusTooManyRequests, "RATE_LIMIT_EXCEEDED", "Too many requests. Please try again later.")
+				return
 			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Allow counts a request against key and reports whether it is within
+// limit requests per window.
+func (rl *RateLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) RateLimitResult {
+	now := rl.now()
+	if rl.redis != nil {
+		res, err := rl.allowRedis(ctx, key, limit, window, now)
+		if err == nil {
+			return res
 		}
-		rl.mu.Unlock()
 	}
+	return rl.memory.allow(key, limit, window, now)
 }
 
-func (rl *rateLimiter) allow(ip string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+// slidingWindowScript keeps one sorted-set member per request, scored by
+// its time in milliseconds. It returns {allowed, remaining, reset_ms}.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
 
-	now := time.Now()
-	info, exists := rl.requests[ip]
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	redis.call('PEXPIRE', key, window)
+	count = count + 1
+	allowed = 1
+end
 
-	if !exists || now.After(info.resetAt) {
-		rl.requests[ip] = &clientInfo{
-			count:      1,
-			resetAt:    now.Add(rl.window),
-			lastAccess: now,
-		}
-		return true
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, limit - count, reset}
+`)
+
+func (rl *RateLimiter) allowRedis(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (RateLimitResult, error) {
+	// The member only has to be unique; the nanosecond timestamp plus a
+	// counter guards against two requests landing in the same instant.
+	member := strconv.FormatInt(now.UnixNano(), 10) + "-" + strconv.FormatUint(rl.memory.nextSeq(), 10)
+
+	vals, err := slidingWindowScript.Run(ctx, rl.redis, []string{key},
+		now.UnixMilli(), window.Milliseconds(), limit, member,
+	).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
 	}
+	return RateLimitResult{
+		Allowed:   vals[0] == 1,
+		Remaining: int(vals[1]),
+		Reset:     time.Duration(vals[2]) * time.Millisecond,
+	}, nil
+}
 
-	info.lastAccess = now
+// memoryWindows is the in-process fallback for RateLimiter.
+type memoryWindows struct {
+	mu      sync.Mutex
+	windows map[string][]time.Time
+	seq     uint64
+	// longest is the longest window any bucket has been counted over
+	longest time.Duration
+}
 
-	if info.count >= rl.limit {
-		return false
+func newMemoryWindows() *memoryWindows {
+	return &memoryWindows{windows: make(map[string][]time.Time)}
+}
+
+func (m *memoryWindows) nextSeq() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	return m.seq
+}
+
+func (m *memoryWindows) allow(key string, limit int, window time.Duration, now time.Time) RateLimitResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if window > m.longest {
+		m.longest = window
+	}
+	hits := dropBefore(m.windows[key], now.Add(-window))
+	res := RateLimitResult{}
+	if len(hits) < limit {
+		hits = append(hits, now)
+		res.Allowed = true
 	}
+	m.windows[key] = hits
 
-	info.count++
-	return true
+	res.Remaining = limit - len(hits)
+	res.Reset = window
+	if len(hits) > 0 {
+		res.Reset = hits[0].Add(window).Sub(now)
+	}
+	return res
 }
 
-func (rl *rateLimiter) Middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
+// sweep drops buckets with no requests left in their window. Windows are
+// not stored per bucket, so a bucket is only dropped once its newest request
+// is older than the longest window counted so far.
+func (m *memoryWindows) sweep(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-			ip = xff
-		} else if xri := r.Header.Get("X-Real-IP"); xri != "" {
-			ip = xri
+	for key, hits := range m.windows {
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) > m.longest {
+			delete(m.windows, key)
 		}
+	}
+}
 
-		if !rl.allow(ip) {
-			response.Error(w, http.StatusTooManyRequests, "RATE_LIMIT_EXCEEDED", "Too many requests. Please try again later.")
-			return
-		}
+// dropBefore removes the timestamps at or before cutoff from a sorted slice.
+func dropBefore(hits []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(hits) && !hits[i].After(cutoff) {
+		i++
+	}
+	return hits[i:]
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// KeyByIP counts requests per client IP, as resolved by the clientip
+// middleware.
+func KeyByIP(r *http.Request) string {
+	return "ip:" + clientip.FromRequest(r)
+}
 
-		next.ServeHTTP(w, r)
-	})
+// KeyByUser counts requests per authenticated user, falling back to the
+// client IP for anonymous requests. It reads the claims stored by the auth
+// middleware, so it must run after it.
+func KeyByUser(r *http.Request) string {
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+		return "user:" + strconv.Itoa(claims.UserID)
+	}
+	return KeyByIP(r)
+}
+
+// KeyByAPIKey counts requests per X-API-Key header, falling back to the
+// client IP when there is none. Only a hash of the key is used.
+func KeyByAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "apikey:" + hex.EncodeToString(sum[:8])
+	}
+	return KeyByIP(r)
 }
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/auth"
+)
+
+func newTestLimiter() (*RateLimiter, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	rl := &RateLimiter{memory: newMemoryWindows()}
+	rl.now = func() time.Time { return now }
+	return rl, &now
+}
+
+func TestRateLimiterSlidingWindow(t *testing.T) {
+	rl, now := newTestLimiter()
+	policy := RateLimitPolicy{Name: "test", Limit: 3, Window: time.Minute}
+	handler := rl.Limit(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/products", nil)
+		req.RemoteAddr = "203.0.113.7:51234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i, remaining := range []string{"2", "1", "0"} {
+		rec := send()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, rec.Code)
+		}
+		if got := rec.Header().Get("RateLimit-Remaining"); got != remaining {
+			t.Errorf("request %d: RateLimit-Remaining = %s, want %s", i+1, got, remaining)
+		}
+		if rec.Header().Get("RateLimit-Limit") != "3" || rec.Header().Get("RateLimit-Policy") != "3;w=60" {
+			t.Errorf("request %d: unexpected limit headers %v", i+1, rec.Header())
+		}
+		*now = now.Add(10 * time.Second)
+	}
+
+	// 30s after the first request: still full, a slot frees in 30s
+	rec := send()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %s, want 30", got)
+	}
+
+	// Once the first request leaves the window one more is allowed
+	*now = now.Add(30 * time.Second)
+	if rec := send(); rec.Code != http.StatusOK {
+		t.Errorf("expected request after the window slid to pass, got %d", rec.Code)
+	}
+	if rec := send(); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the window to be full again, got %d", rec.Code)
+	}
+}
+
+func TestRateLimiterSeparatesPoliciesAndKeys(t *testing.T) {
+	rl, _ := newTestLimiter()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	login := rl.Limit(RateLimitPolicy{Name: "login", Limit: 1, Window: time.Minute})(ok)
+	catalog := rl.Limit(RateLimitPolicy{Name: "catalog", Limit: 1, Window: time.Minute})(ok)
+
+	send := func(h http.Handler, addr string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if send(login, "203.0.113.7:1") != http.StatusOK {
+		t.Fatal("first login request should pass")
+	}
+	if send(login, "203.0.113.7:2") != http.StatusTooManyRequests {
+		t.Error("second login request from the same IP should be limited")
+	}
+	if send(login, "198.51.100.1:1") != http.StatusOK {
+		t.Error("another IP should have its own bucket")
+	}
+	if send(catalog, "203.0.113.7:1") != http.StatusOK {
+		t.Error("another policy should have its own bucket")
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+
+	if got := KeyByIP(req); got != "ip:203.0.113.7" {
+		t.Errorf("KeyByIP = %s", got)
+	}
+	if got := KeyByUser(req); got != "ip:203.0.113.7" {
+		t.Errorf("KeyByUser without claims = %s, want IP fallback", got)
+	}
+	if got := KeyByAPIKey(req); got != "ip:203.0.113.7" {
+		t.Errorf("KeyByAPIKey without a key = %s, want IP fallback", got)
+	}
+
+	authed := req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{UserID: 42}))
+	if got := KeyByUser(authed); got != "user:42" {
+		t.Errorf("KeyByUser = %s, want user:42", got)
+	}
+
+	req.Header.Set("X-API-Key", "sk_live_secret")
+	if got := KeyByAPIKey(req); got == "ip:203.0.113.7" || len(got) != len("apikey:")+16 {
+		t.Errorf("KeyByAPIKey = %s, want a hashed key", got)
+	}
+}
+
+func TestMemorySweepKeepsLongestWindow(t *testing.T) {
+	rl, now := newTestLimiter()
+	rl.Allow(context.Background(), "short", 5, time.Minute)
+	rl.Allow(context.Background(), "long", 5, 2*time.Hour)
+
+	*now = now.Add(90 * time.Minute)
+	rl.memory.sweep(*now)
+
+	if _, ok := rl.memory.windows["short"]; !ok {
+		t.Error("bucket swept before the longest window passed")
+	}
+	if res := rl.Allow(context.Background(), "long", 1, 2*time.Hour); res.Allowed {
+		t.Error("a 2h bucket lost its requests after 90 minutes")
+	}
+
+	*now = now.Add(3 * time.Hour)
+	rl.memory.sweep(*now)
+	if len(rl.memory.windows) != 0 {
+		t.Errorf("expected all buckets swept, %d left", len(rl.memory.windows))
+	}
+}
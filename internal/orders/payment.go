@@ -0,0 +1,112 @@
+package orders
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/stripe/stripe-go/v76"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/services"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/money"
+)
+
+// ApplyPaymentSuccess records a succeeded payment intent against an order.
+// The order is marked paid only if the intent matches its total, currency
+// and stored intent ID. Otherwise it is moved to payment_review, a payment
+// alert is raised, and the mismatches are returned.
+//
+// An order that has already moved on (e.g. paid by another intent) keeps its
+// status when a mismatched payment arrives; the alert alone tells an
+// operator about it. The same goes for a matching payment that arrives for
+// an order that can no longer be paid, such as a canceled one: the money was
+// captured, so an order_not_payable alert is raised and returned rather
+// than the event being dropped. The caller must commit tx either way.
+func ApplyPaymentSuccess(ctx context.Context, tx *sql.Tx, orderID int64, pi *stripe.PaymentIntent, actor string) ([]services.PaymentMismatch, error) {
+	var totalCents int64
+	var currency string
+	var storedIntentID sql.NullString
+	err := tx.QueryRowContext(ctx,
+		"SELECT total_cents, currency, stripe_payment_intent_id FROM orders WHERE id = $1 FOR UPDATE",
+		orderID,
+	).Scan(&totalCents, &currency, &storedIntentID)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lock order: %w", err)
+	}
+
+	mismatches := services.VerifyPaymentIntent(pi, money.New(totalCents, currency), storedIntentID.String)
+
+	if !storedIntentID.Valid {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE orders SET stripe_payment_intent_id = $1 WHERE id = $2",
+			pi.ID, orderID,
+		); err != nil {
+			return nil, fmt.Errorf("store payment intent: %w", err)
+		}
+	}
+
+	if len(mismatches) == 0 {
+		from, err := Transition(ctx, tx, orderID, Change{
+			To:            StatusPaid,
+			PaymentStatus: "succeeded",
+			Actor:         actor,
+			Reason:        fmt.Sprintf("payment_intent %s succeeded", pi.ID),
+		})
+		if !errors.Is(err, ErrInvalidTransition) {
+			return nil, err
+		}
+
+		// A redelivery for an order this intent already paid
+		if storedIntentID.String == pi.ID && from != StatusCanceled {
+			return nil, nil
+		}
+
+		notPayable := []services.PaymentMismatch{{
+			Kind:     services.MismatchOrderNotPayable,
+			Expected: "pending or awaiting_payment",
+			Actual:   string(from),
+		}}
+		if err := InsertPaymentAlert(ctx, tx, orderID, pi.ID, notPayable); err != nil {
+			return notPayable, err
+		}
+		return notPayable, nil
+	}
+
+	if err := InsertPaymentAlert(ctx, tx, orderID, pi.ID, mismatches); err != nil {
+		return mismatches, err
+	}
+
+	_, err = Transition(ctx, tx, orderID, Change{
+		To:            StatusPaymentReview,
+		PaymentStatus: "succeeded",
+		Actor:         actor,
+		Reason:        fmt.Sprintf("payment_intent %s does not match order", pi.ID),
+	})
+	if errors.Is(err, ErrInvalidTransition) {
+		return mismatches, nil
+	}
+	return mismatches, err
+}
+
+// InsertPaymentAlert records a payment that did not match its order. A
+// repeated alert for the same order and intent is ignored.
+func InsertPaymentAlert(ctx context.Context, tx *sql.Tx, orderID int64, paymentIntentID string, mismatches []services.PaymentMismatch) error {
+	details, err := json.Marshal(mismatches)
+	if err != nil {
+		return fmt.Errorf("encode mismatches: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO payment_alerts (order_id, payment_intent_id, mismatches)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (order_id, payment_intent_id) DO NOTHING
+	`, orderID, paymentIntentID, string(details)); err != nil {
+		return fmt.Errorf("insert payment alert: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package orders
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Status is the lifecycle state of an order.
+type Status string
+
+const (
+	StatusPending         Status = "pending"
+	StatusAwaitingPayment Status = "awaiting_payment"
+	StatusPaid            Status = "paid"
+	StatusFulfilled       Status = "fulfilled"
+	StatusShipped         Status = "shipped"
+	StatusDelivered       Status = "delivered"
+	StatusCanceled        Status = "canceled"
+	StatusRefunded        Status = "refunded"
+	// StatusPaymentReview holds an order whose payment did not match it,
+	// until an operator approves or refunds it.
+	StatusPaymentReview Status = "payment_review"
+)
+
+// Actors recorded in the status history for changes not made by a user.
+// Changes by users are recorded with audit.UserActor and audit.AdminActor.
+const (
+	ActorSystem     = "system"
+	ActorStripe     = "stripe"
+	ActorReconciler = "reconciler"
+)
+
+// ErrInvalidTransition is returned when a status change is not allowed
+// from the order's current status.
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+// ErrOrderNotFound is returned when the order row does not exist.
+var ErrOrderNotFound = errors.New("order not found")
+
+// TransitionError describes a rejected status change.
+type TransitionError struct {
+	From Status
+	To   Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot move order from %s to %s", e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// transitions lists the statuses each status may move to.
+var transitions = map[Status][]Status{
+	StatusPending:         {StatusAwaitingPayment, StatusPaid, StatusPaymentReview, StatusCanceled},
+	StatusAwaitingPayment: {StatusPaid, StatusPaymentReview, StatusCanceled},
+	StatusPaymentReview:   {StatusPaid, StatusRefunded},
+	StatusPaid:            {StatusFulfilled, StatusRefunded},
+	StatusFulfilled:       {StatusShipped, StatusRefunded},
+	StatusShipped:         {StatusDelivered, StatusRefunded},
+	StatusDelivered:       {StatusRefunded},
+}
+
+// Valid reports whether s is a known order status.
+func (s Status) Valid() bool {
+	switch s {
+	case StatusCanceled, StatusRefunded:
+		return true
+	}
+	_, ok := transitions[s]
+	return ok
+}
+
+// CanTransition reports whether an order in from may move to to.
+func CanTransition(from, to Status) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Change is a requested status change.
+type Change struct {
+	To Status
+	// PaymentStatus, when set, is written to orders.payment_status along
+	// with the status change.
+	PaymentStatus string
+	Actor         string
+	Reason        string
+}
+
+// Transition locks the order row, checks that the change is allowed and
+// applies it, recording the change in order_status_history. A change to the
+// status the order already has only updates the payment status and is not
+// recorded. It returns the status the order had before the change.
+func Transition(ctx context.Context, tx *sql.Tx, orderID int64, c Change) (Status, error) {
+	var current Status
+	err := tx.QueryRowContext(ctx,
+		"SELECT status FROM orders WHERE id = $1 FOR UPDATE", orderID,
+	).Scan(&current)
+	if err == sql.ErrNoRows {
+		return "", ErrOrderNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("lock order: %w", err)
+	}
+
+	if current != c.To && !CanTransition(current, c.To) {
+		return current, &TransitionError{From: current, To: c.To}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE orders
+		SET
+			status = $1,
+			payment_status = COALESCE(NULLIF($2, ''), payment_status),
+			updated_at = NOW()
+		WHERE id = $3
+	`, c.To, c.PaymentStatus, orderID); err != nil {
+		return current, fmt.Errorf("update order status: %w", err)
+	}
+
+	if current == c.To {
+		return current, nil
+	}
+
+	if err := recordHistory(ctx, tx, orderID, current, c); err != nil {
+		return current, err
+	}
+	return current, nil
+}
+
+// RecordCreated writes the initial history entry for a newly inserted order.
+func RecordCreated(ctx context.Context, tx *sql.Tx, orderID int64, actor string) error {
+	return recordHistory(ctx, tx, orderID, "", Change{To: StatusPending, Actor: actor, Reason: "order placed"})
+}
+
+func recordHistory(ctx context.Context, tx *sql.Tx, orderID int64, from Status, c Change) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO order_status_history (order_id, from_status, to_status, actor, reason)
+		VALUES ($1, NULLIF($2, ''), $3, $4, NULLIF($5, ''))
+	`, orderID, from, c.To, c.Actor, c.Reason)
+	if err != nil {
+		return fmt.Errorf("record status history: %w", err)
+	}
+	return nil
+}
+
+// HistoryEntry is one recorded status change.
+type HistoryEntry struct {
+	From      Status    `json:"from_status,omitempty"`
+	To        Status    `json:"to_status"`
+	Actor     string    `json:"actor"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// History returns the status changes of an order, oldest first.
+func History(ctx context.Context, db *sql.DB, orderID int64) ([]HistoryEntry, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT COALESCE(from_status, ''), to_status, actor, COALESCE(reason, ''), created_at
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY created_at, id
+	`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("query status history: %w", err)
+	}
+	defer rows.Close()
+
+	history := []HistoryEntry{}
+	for rows.Next() {
+		var h HistoryEntry
+		if err := rows.Scan(&h.From, &h.To, &h.Actor, &h.Reason, &h.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan status history: %w", err)
+		}
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
+
+// Cancel moves an order to canceled and returns its reserved stock to the
+// products it was taken from. Canceling an order that is already canceled is
+// a no-op, so stock is only ever restored once.
+func Cancel(ctx context.Context, tx *sql.Tx, orderID int64, actor, reason string) error {
+	from, err := Transition(ctx, tx, orderID, Change{
+		To:            StatusCanceled,
+		PaymentStatus: "canceled",
+		Actor:         actor,
+		Reason:        reason,
+	})
+	if err != nil {
+		return err
+	}
+	if from == StatusCanceled {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE orders SET canceled_at = NOW(), canceled_by = $1 WHERE id = $2",
+		actor, orderID,
+	); err != nil {
+		return fmt.Errorf("mark order canceled: %w", err)
+	}
+
+	items, err := lineQuantities(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+	return Restock(ctx, tx, items)
+}
+
+// StockLine is a quantity of one product to put back into stock.
+type StockLine struct {
+	ProductID int64
+	Quantity  int
+}
+
+// Restock adds each line's quantity back to its product. Rows are updated in
+// product id order, the same order checkout locks them in, so the two cannot
+// deadlock.
+func Restock(ctx context.Context, tx *sql.Tx, lines []StockLine) error {
+	sorted := make([]StockLine, len(lines))
+	copy(sorted, lines)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ProductID < sorted[j].ProductID })
+
+	for _, line := range sorted {
+		if line.Quantity <= 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE products SET stock = stock + $1 WHERE id = $2",
+			line.Quantity, line.ProductID,
+		); err != nil {
+			return fmt.Errorf("restock product %d: %w", line.ProductID, err)
+		}
+	}
+	return nil
+}
+
+func lineQuantities(ctx context.Context, tx *sql.Tx, orderID int64) ([]StockLine, error) {
+	rows, err := tx.QueryContext(ctx,
+		"SELECT product_id, quantity FROM order_items WHERE order_id = $1 AND product_id IS NOT NULL",
+		orderID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("load order items: %w", err)
+	}
+	defer rows.Close()
+
+	var lines []StockLine
+	for rows.Next() {
+		var line StockLine
+		if err := rows.Scan(&line.ProductID, &line.Quantity); err != nil {
+			return nil, fmt.Errorf("scan order item: %w", err)
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
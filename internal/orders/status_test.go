@@ -0,0 +1,69 @@
+package orders
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		name string
+		from Status
+		to   Status
+		want bool
+	}{
+		{name: "pending to awaiting payment", from: StatusPending, to: StatusAwaitingPayment, want: true},
+		{name: "awaiting payment to paid", from: StatusAwaitingPayment, to: StatusPaid, want: true},
+		{name: "awaiting payment to canceled", from: StatusAwaitingPayment, to: StatusCanceled, want: true},
+		{name: "paid to fulfilled", from: StatusPaid, to: StatusFulfilled, want: true},
+		{name: "fulfilled to shipped", from: StatusFulfilled, to: StatusShipped, want: true},
+		{name: "shipped to delivered", from: StatusShipped, to: StatusDelivered, want: true},
+		{name: "delivered to refunded", from: StatusDelivered, to: StatusRefunded, want: true},
+		{name: "awaiting payment to review", from: StatusAwaitingPayment, to: StatusPaymentReview, want: true},
+		{name: "review approved", from: StatusPaymentReview, to: StatusPaid, want: true},
+		{name: "review refunded", from: StatusPaymentReview, to: StatusRefunded, want: true},
+		{name: "paid cannot go to review", from: StatusPaid, to: StatusPaymentReview, want: false},
+		{name: "review cannot be canceled", from: StatusPaymentReview, to: StatusCanceled, want: false},
+		{name: "paid back to awaiting payment", from: StatusPaid, to: StatusAwaitingPayment, want: false},
+		{name: "paid to canceled", from: StatusPaid, to: StatusCanceled, want: false},
+		{name: "canceled is terminal", from: StatusCanceled, to: StatusPaid, want: false},
+		{name: "refunded is terminal", from: StatusRefunded, to: StatusPaid, want: false},
+		{name: "pending cannot skip to shipped", from: StatusPending, to: StatusShipped, want: false},
+		{name: "unknown status", from: Status("lost"), to: StatusPaid, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusValid(t *testing.T) {
+	for _, s := range []Status{
+		StatusPending, StatusAwaitingPayment, StatusPaid, StatusFulfilled,
+		StatusShipped, StatusDelivered, StatusCanceled, StatusRefunded, StatusPaymentReview,
+	} {
+		if !s.Valid() {
+			t.Errorf("expected %s to be valid", s)
+		}
+	}
+
+	if Status("on_hold").Valid() {
+		t.Error("expected unknown status to be invalid")
+	}
+}
+
+func TestTransitionErrorIs(t *testing.T) {
+	err := error(&TransitionError{From: StatusPaid, To: StatusAwaitingPayment})
+
+	if !errors.Is(err, ErrInvalidTransition) {
+		t.Error("expected TransitionError to match ErrInvalidTransition")
+	}
+
+	if err.Error() != "cannot move order from paid to awaiting_payment" {
+		t.Errorf("unexpected message: %s", err.Error())
+	}
+}
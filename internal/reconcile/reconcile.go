@@ -0,0 +1,215 @@
+// Package reconcile compares orders with the payment provider. It repairs
+// status transitions that were missed because a webhook never arrived and
+// reports every other discrepancy for an operator to look at.
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/stripe/stripe-go/v76"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/orders"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/services"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/money"
+)
+
+// Kinds of discrepancy found between an order and its payment intent.
+// Amount, currency and intent mismatches use the services.Mismatch* kinds.
+const (
+	KindMissingIntent      = "missing_intent"
+	KindLookupFailed       = "lookup_failed"
+	KindMissedPayment      = "missed_payment"
+	KindMissedCancellation = "missed_cancellation"
+	KindStatusMismatch     = "status_mismatch"
+)
+
+// Actions taken (or, on a dry run, that would be taken) for a discrepancy.
+const (
+	ActionMarkedPaid     = "marked_paid"
+	ActionHeldForReview  = "held_for_review"
+	ActionCanceled       = "canceled"
+	ActionNeedsAttention = "needs_attention"
+)
+
+// Order is the part of an order the reconciler compares with the provider.
+type Order struct {
+	ID              int64
+	Status          orders.Status
+	Total           money.Money
+	PaymentIntentID string
+}
+
+// Store loads orders to check and applies repairs. SQLStore is the
+// production implementation.
+type Store interface {
+	// Orders returns orders with a payment intent changed since the given
+	// time, and orders still waiting for payment created since then.
+	Orders(ctx context.Context, since time.Time) ([]Order, error)
+	// ApplyPaymentSuccess records a succeeded intent the webhook never
+	// delivered, holding the order for review if the payment does not match.
+	ApplyPaymentSuccess(ctx context.Context, orderID int64, pi *stripe.PaymentIntent) ([]services.PaymentMismatch, error)
+	// Cancel cancels an order whose intent was canceled at the provider.
+	Cancel(ctx context.Context, orderID int64, pi *stripe.PaymentIntent) error
+}
+
+// Options controls a reconciliation run.
+type Options struct {
+	// Since limits the run to orders touched after this time. The zero
+	// value checks every order.
+	Since time.Time
+	// DryRun reports what would be repaired without changing anything.
+	DryRun bool
+}
+
+// Discrepancy is one row of the report.
+type Discrepancy struct {
+	OrderID         int64  `json:"order_id"`
+	PaymentIntentID string `json:"payment_intent_id,omitempty"`
+	Kind            string `json:"kind"`
+	OrderStatus     string `json:"order_status"`
+	IntentStatus    string `json:"intent_status,omitempty"`
+	Expected        string `json:"expected,omitempty"`
+	Actual          string `json:"actual,omitempty"`
+	Action          string `json:"action"`
+	Fixed           bool   `json:"fixed"`
+	Error           string `json:"error,omitempty"`
+}
+
+// Report summarizes a reconciliation run.
+type Report struct {
+	StartedAt     time.Time     `json:"started_at"`
+	FinishedAt    time.Time     `json:"finished_at"`
+	Since         time.Time     `json:"since"`
+	DryRun        bool          `json:"dry_run"`
+	Checked       int           `json:"checked"`
+	Fixed         int           `json:"fixed"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// Reconciler walks orders and checks each against its payment intent.
+type Reconciler struct {
+	store    Store
+	payments *services.PaymentService
+	now      func() time.Time
+}
+
+func New(store Store, payments *services.PaymentService) *Reconciler {
+	return &Reconciler{store: store, payments: payments, now: time.Now}
+}
+
+// Run checks every candidate order and returns the report. A failure to
+// look up or repair one order is recorded in the report rather than
+// stopping the run.
+func (r *Reconciler) Run(ctx context.Context, opts Options) (*Report, error) {
+	report := &Report{
+		StartedAt:     r.now(),
+		Since:         opts.Since,
+		DryRun:        opts.DryRun,
+		Discrepancies: []Discrepancy{},
+	}
+
+	candidates, err := r.store.Orders(ctx, opts.Since)
+	if err != nil {
+		return nil, fmt.Errorf("load orders: %w", err)
+	}
+
+	for _, o := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		report.Checked++
+		for _, d := range r.check(ctx, o, opts.DryRun) {
+			if d.Fixed {
+				report.Fixed++
+			}
+			report.Discrepancies = append(report.Discrepancies, d)
+		}
+	}
+
+	report.FinishedAt = r.now()
+	return report, nil
+}
+
+// check compares one order with its intent and repairs it if allowed.
+func (r *Reconciler) check(ctx context.Context, o Order, dryRun bool) []Discrepancy {
+	base := Discrepancy{OrderID: o.ID, PaymentIntentID: o.PaymentIntentID, OrderStatus: string(o.Status)}
+
+	if o.PaymentIntentID == "" {
+		// A pending order without an intent is a customer who has not
+		// started paying yet; one awaiting payment should always have one.
+		if o.Status != orders.StatusAwaitingPayment {
+			return nil
+		}
+		base.Kind, base.Action = KindMissingIntent, ActionNeedsAttention
+		return []Discrepancy{base}
+	}
+
+	pi, err := r.payments.GetPaymentIntent(ctx, o.PaymentIntentID)
+	if err != nil {
+		base.Kind, base.Action, base.Error = KindLookupFailed, ActionNeedsAttention, err.Error()
+		if services.IsNotFound(err) {
+			base.Kind = KindMissingIntent
+		}
+		return []Discrepancy{base}
+	}
+	base.IntentStatus = string(pi.Status)
+
+	var found []Discrepancy
+	for _, m := range services.VerifyPaymentIntent(pi, o.Total, o.PaymentIntentID) {
+		d := base
+		d.Kind, d.Expected, d.Actual, d.Action = m.Kind, m.Expected, m.Actual, ActionNeedsAttention
+		found = append(found, d)
+	}
+
+	awaiting := o.Status == orders.StatusPending || o.Status == orders.StatusAwaitingPayment
+	d := base
+	switch {
+	case awaiting && pi.Status == stripe.PaymentIntentStatusSucceeded:
+		d.Kind, d.Action = KindMissedPayment, ActionMarkedPaid
+		if len(found) > 0 {
+			d.Action = ActionHeldForReview
+		}
+		if !dryRun {
+			_, err := r.store.ApplyPaymentSuccess(ctx, o.ID, pi)
+			d.Fixed, d.Error = err == nil, errorText(err)
+		}
+
+	case awaiting && pi.Status == stripe.PaymentIntentStatusCanceled:
+		d.Kind, d.Action = KindMissedCancellation, ActionCanceled
+		if !dryRun {
+			err := r.store.Cancel(ctx, o.ID, pi)
+			d.Fixed, d.Error = err == nil, errorText(err)
+		}
+
+	case o.Status == orders.StatusCanceled && pi.Status == stripe.PaymentIntentStatusSucceeded,
+		paidStatuses[o.Status] && pi.Status != stripe.PaymentIntentStatusSucceeded:
+		d.Kind, d.Action = KindStatusMismatch, ActionNeedsAttention
+		d.Expected = string(stripe.PaymentIntentStatusSucceeded)
+		if o.Status == orders.StatusCanceled {
+			d.Expected = string(stripe.PaymentIntentStatusCanceled)
+		}
+		d.Actual = string(pi.Status)
+
+	default:
+		return found
+	}
+
+	return append(found, d)
+}
+
+// paidStatuses are order statuses that require a succeeded intent.
+var paidStatuses = map[orders.Status]bool{
+	orders.StatusPaid:      true,
+	orders.StatusFulfilled: true,
+	orders.StatusShipped:   true,
+	orders.StatusDelivered: true,
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
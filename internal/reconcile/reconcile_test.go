@@ -0,0 +1,224 @@
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v76"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/orders"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/services"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/money"
+)
+
+// memStore is an in-memory Store that applies repairs the way the order
+// lifecycle would.
+type memStore struct {
+	orders map[int64]*Order
+}
+
+func newMemStore(list ...Order) *memStore {
+	s := &memStore{orders: map[int64]*Order{}}
+	for i := range list {
+		o := list[i]
+		s.orders[o.ID] = &o
+	}
+	return s
+}
+
+func (s *memStore) Orders(ctx context.Context, since time.Time) ([]Order, error) {
+	var result []Order
+	for id := int64(1); id <= int64(len(s.orders)); id++ {
+		result = append(result, *s.orders[id])
+	}
+	return result, nil
+}
+
+func (s *memStore) ApplyPaymentSuccess(ctx context.Context, orderID int64, pi *stripe.PaymentIntent) ([]services.PaymentMismatch, error) {
+	o := s.orders[orderID]
+	mismatches := services.VerifyPaymentIntent(pi, o.Total, o.PaymentIntentID)
+	if len(mismatches) > 0 {
+		o.Status = orders.StatusPaymentReview
+	} else {
+		o.Status = orders.StatusPaid
+	}
+	return mismatches, nil
+}
+
+func (s *memStore) Cancel(ctx context.Context, orderID int64, pi *stripe.PaymentIntent) error {
+	s.orders[orderID].Status = orders.StatusCanceled
+	return nil
+}
+
+type fixture struct {
+	fake  *services.FakeProvider
+	store *memStore
+}
+
+// newFixture sets up one order per reconciliation scenario, backed by
+// intents in the fake provider.
+func newFixture(t *testing.T) fixture {
+	t.Helper()
+	ctx := context.Background()
+	fake := services.NewFakeProvider("", "")
+
+	intent := func(amount int64) string {
+		pi, err := fake.CreatePaymentIntent(ctx, services.CreateIntentParams{AmountCents: amount, Currency: "usd"})
+		if err != nil {
+			t.Fatalf("failed to create intent: %v", err)
+		}
+		return pi.ID
+	}
+
+	usd := func(amount int64) money.Money { return money.New(amount, "USD") }
+
+	missedPayment := intent(1000)
+	fake.SucceedPaymentIntent(missedPayment)
+
+	shortPayment := intent(500)
+	fake.SucceedPaymentIntent(shortPayment)
+
+	missedCancel := intent(1000)
+	fake.CancelPaymentIntent(ctx, missedCancel)
+
+	unpaid := intent(1000)
+
+	inSync := intent(1000)
+	fake.SucceedPaymentIntent(inSync)
+
+	store := newMemStore(
+		Order{ID: 1, Status: orders.StatusAwaitingPayment, Total: usd(1000), PaymentIntentID: missedPayment},
+		Order{ID: 2, Status: orders.StatusAwaitingPayment, Total: usd(1000), PaymentIntentID: shortPayment},
+		Order{ID: 3, Status: orders.StatusAwaitingPayment, Total: usd(1000), PaymentIntentID: missedCancel},
+		Order{ID: 4, Status: orders.StatusPaid, Total: usd(1000), PaymentIntentID: unpaid},
+		Order{ID: 5, Status: orders.StatusAwaitingPayment, Total: usd(1000), PaymentIntentID: "pi_fake_999999"},
+		Order{ID: 6, Status: orders.StatusAwaitingPayment, Total: usd(1000)},
+		Order{ID: 7, Status: orders.StatusPending, Total: usd(1000)},
+		Order{ID: 8, Status: orders.StatusShipped, Total: usd(1000), PaymentIntentID: inSync},
+	)
+
+	return fixture{fake: fake, store: store}
+}
+
+func TestRunRepairsMissedWebhooks(t *testing.T) {
+	f := newFixture(t)
+	r := New(f.store, services.NewPaymentService(f.fake))
+
+	report, err := r.Run(context.Background(), Options{})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	if report.Checked != 8 {
+		t.Errorf("checked %d orders, want 8", report.Checked)
+	}
+	if report.Fixed != 3 {
+		t.Errorf("fixed %d orders, want 3", report.Fixed)
+	}
+
+	wantStatus := map[int64]orders.Status{
+		1: orders.StatusPaid,
+		2: orders.StatusPaymentReview,
+		3: orders.StatusCanceled,
+		4: orders.StatusPaid,
+		8: orders.StatusShipped,
+	}
+	for id, want := range wantStatus {
+		if got := f.store.orders[id].Status; got != want {
+			t.Errorf("order %d is %s, want %s", id, got, want)
+		}
+	}
+
+	type key struct {
+		order int64
+		kind  string
+	}
+	got := map[key]Discrepancy{}
+	for _, d := range report.Discrepancies {
+		got[key{d.OrderID, d.Kind}] = d
+	}
+
+	want := []struct {
+		order  int64
+		kind   string
+		action string
+		fixed  bool
+	}{
+		{1, KindMissedPayment, ActionMarkedPaid, true},
+		{2, services.MismatchAmount, ActionNeedsAttention, false},
+		{2, KindMissedPayment, ActionHeldForReview, true},
+		{3, KindMissedCancellation, ActionCanceled, true},
+		{4, KindStatusMismatch, ActionNeedsAttention, false},
+		{5, KindMissingIntent, ActionNeedsAttention, false},
+		{6, KindMissingIntent, ActionNeedsAttention, false},
+	}
+	for _, w := range want {
+		d, ok := got[key{w.order, w.kind}]
+		if !ok {
+			t.Errorf("missing %s discrepancy for order %d", w.kind, w.order)
+			continue
+		}
+		if d.Action != w.action || d.Fixed != w.fixed {
+			t.Errorf("order %d %s: action %s fixed %v, want %s %v", w.order, w.kind, d.Action, d.Fixed, w.action, w.fixed)
+		}
+	}
+	if len(report.Discrepancies) != len(want) {
+		t.Errorf("got %d discrepancies, want %d: %+v", len(report.Discrepancies), len(want), report.Discrepancies)
+	}
+}
+
+func TestRunDryRunChangesNothing(t *testing.T) {
+	f := newFixture(t)
+	r := New(f.store, services.NewPaymentService(f.fake))
+
+	report, err := r.Run(context.Background(), Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	if report.Fixed != 0 {
+		t.Errorf("dry run fixed %d orders", report.Fixed)
+	}
+	for _, id := range []int64{1, 2, 3} {
+		if got := f.store.orders[id].Status; got != orders.StatusAwaitingPayment {
+			t.Errorf("dry run changed order %d to %s", id, got)
+		}
+	}
+}
+
+func TestReportFormats(t *testing.T) {
+	f := newFixture(t)
+	r := New(f.store, services.NewPaymentService(f.fake))
+
+	report, err := r.Run(context.Background(), Options{})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("report is not valid csv: %v", err)
+	}
+	if len(records) != len(report.Discrepancies)+1 {
+		t.Errorf("got %d csv rows, want %d", len(records), len(report.Discrepancies)+1)
+	}
+	if strings.Join(records[0], ",") != strings.Join(csvHeader, ",") {
+		t.Errorf("unexpected header %v", records[0])
+	}
+
+	buf.Reset()
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatalf("write json: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"kind": "missed_payment"`) {
+		t.Errorf("json report is missing discrepancies: %s", buf.String())
+	}
+}
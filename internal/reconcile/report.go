@@ -0,0 +1,38 @@
+package reconcile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// WriteJSON writes the full report, including the run summary.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+var csvHeader = []string{
+	"order_id", "payment_intent_id", "kind", "order_status", "intent_status",
+	"expected", "actual", "action", "fixed", "error",
+}
+
+// WriteCSV writes one row per discrepancy.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, d := range r.Discrepancies {
+		if err := cw.Write([]string{
+			strconv.FormatInt(d.OrderID, 10), d.PaymentIntentID, d.Kind, d.OrderStatus, d.IntentStatus,
+			d.Expected, d.Actual, d.Action, strconv.FormatBool(d.Fixed), d.Error,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,81 @@
+package reconcile
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/stripe/stripe-go/v76"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/orders"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/services"
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/money"
+)
+
+// SQLStore reads and repairs orders in PostgreSQL. Repairs go through the
+// same order lifecycle functions as the webhook handler, with the
+// reconciler recorded as the actor.
+type SQLStore struct {
+	db *sql.DB
+}
+
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+func (s *SQLStore) Orders(ctx context.Context, since time.Time) ([]Order, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, status, total_cents, currency, COALESCE(stripe_payment_intent_id, '')
+		FROM orders
+		WHERE (stripe_payment_intent_id IS NOT NULL AND updated_at >= $1)
+			OR (status IN ('pending', 'awaiting_payment') AND created_at >= $1)
+		ORDER BY id
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Order
+	for rows.Next() {
+		var o Order
+		var totalCents int64
+		var currency string
+		if err := rows.Scan(&o.ID, &o.Status, &totalCents, &currency, &o.PaymentIntentID); err != nil {
+			return nil, err
+		}
+		o.Total = money.New(totalCents, currency)
+		result = append(result, o)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLStore) ApplyPaymentSuccess(ctx context.Context, orderID int64, pi *stripe.PaymentIntent) ([]services.PaymentMismatch, error) {
+	var mismatches []services.PaymentMismatch
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		mismatches, err = orders.ApplyPaymentSuccess(ctx, tx, orderID, pi, orders.ActorReconciler)
+		return err
+	})
+	return mismatches, err
+}
+
+func (s *SQLStore) Cancel(ctx context.Context, orderID int64, pi *stripe.PaymentIntent) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return orders.Cancel(ctx, tx, orderID, orders.ActorReconciler, fmt.Sprintf("payment_intent %s canceled", pi.ID))
+	})
+}
+
+func (s *SQLStore) inTx(ctx context.Context, apply func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := apply(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/webhook"
+)
+
+// DefaultFakeWebhookSecret signs fake webhooks when no secret is configured.
+const DefaultFakeWebhookSecret = "whsec_fake_local"
+
+// DefaultFakeRunID prefixes fake IDs when no run ID is given.
+const DefaultFakeRunID = "local"
+
+var (
+	ErrFakeIntentNotFound = errors.New("fake provider: payment intent not found")
+	ErrFakeInvalidState   = errors.New("fake provider: payment intent is not in a valid state for this action")
+	ErrFakeRefundTooLarge = errors.New("fake provider: refund exceeds the amount left on the payment intent")
+)
+
+// FakeProvider is an in-process PaymentProvider for local development and
+// tests. IDs are sequential within a provider, behind a run ID prefix so a
+// restarted server given a new run ID never repeats IDs a previous run
+// stored, and it signs its own webhooks with the same scheme Stripe uses, so
+// events it produces pass through the real webhook handler unchanged.
+type FakeProvider struct {
+	mu            sync.Mutex
+	webhookSecret string
+	now           func() time.Time
+	run           string
+	seq           int
+	intents       map[string]*stripe.PaymentIntent
+	refunds       map[string][]*stripe.Refund
+	idempotent    map[string]interface{}
+}
+
+// NewFakeProvider creates a fake provider whose IDs look like
+// "pi_fake_<runID>_000001". With the same run ID, the same calls always
+// produce the same IDs.
+func NewFakeProvider(webhookSecret, runID string) *FakeProvider {
+	if webhookSecret == "" {
+		webhookSecret = DefaultFakeWebhookSecret
+	}
+	if runID == "" {
+		runID = DefaultFakeRunID
+	}
+	return &FakeProvider{
+		webhookSecret: webhookSecret,
+		run:           runID,
+		now:           time.Now,
+		intents:       map[string]*stripe.PaymentIntent{},
+		refunds:       map[string][]*stripe.Refund{},
+		idempotent:    map[string]interface{}{},
+	}
+}
+
+func (p *FakeProvider) nextID(prefix string) string {
+	p.seq++
+	return fmt.Sprintf("%s_fake_%s_%06d", prefix, p.run, p.seq)
+}
+
+func (p *FakeProvider) CreatePaymentIntent(ctx context.Context, in CreateIntentParams) (*stripe.PaymentIntent, error) {
+	if in.AmountCents <= 0 || in.Currency == "" {
+		return nil, fmt.Errorf("fake provider: amount and currency are required")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if in.IdempotencyKey != "" {
+		if prior, ok := p.idempotent["pi:"+in.IdempotencyKey].(*stripe.PaymentIntent); ok {
+			return copyIntent(prior), nil
+		}
+	}
+
+	metadata := make(map[string]string, len(in.Metadata))
+	for k, v := range in.Metadata {
+		metadata[k] = v
+	}
+
+	id := p.nextID("pi")
+	pi := &stripe.PaymentIntent{
+		ID:           id,
+		Object:       "payment_intent",
+		Amount:       in.AmountCents,
+		Currency:     stripe.Currency(in.Currency),
+		ClientSecret: id + "_secret_fake",
+		Created:      p.now().Unix(),
+		Livemode:     false,
+		Metadata:     metadata,
+		Status:       stripe.PaymentIntentStatusRequiresPaymentMethod,
+	}
+	p.intents[id] = pi
+	if in.IdempotencyKey != "" {
+		p.idempotent["pi:"+in.IdempotencyKey] = pi
+	}
+	return copyIntent(pi), nil
+}
+
+func (p *FakeProvider) GetPaymentIntent(ctx context.Context, id string) (*stripe.PaymentIntent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pi, ok := p.intents[id]
+	if !ok {
+		return nil, ErrFakeIntentNotFound
+	}
+	return copyIntent(pi), nil
+}
+
+func (p *FakeProvider) UpdatePaymentIntent(ctx context.Context, id string, in UpdateIntentParams) (*stripe.PaymentIntent, error) {
+	if in.AmountCents <= 0 || in.Currency == "" {
+		return nil, fmt.Errorf("fake provider: amount and currency are required")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pi, ok := p.intents[id]
+	if !ok {
+		return nil, ErrFakeIntentNotFound
+	}
+	if pi.Status == stripe.PaymentIntentStatusSucceeded || pi.Status == stripe.PaymentIntentStatusCanceled {
+		return nil, ErrFakeInvalidState
+	}
+	pi.Amount = in.AmountCents
+	pi.Currency = stripe.Currency(in.Currency)
+	return copyIntent(pi), nil
+}
+
+func (p *FakeProvider) CancelPaymentIntent(ctx context.Context, id string) (*stripe.PaymentIntent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pi, ok := p.intents[id]
+	if !ok {
+		return nil, ErrFakeIntentNotFound
+	}
+	if pi.Status == stripe.PaymentIntentStatusSucceeded {
+		return nil, ErrFakeInvalidState
+	}
+	pi.Status = stripe.PaymentIntentStatusCanceled
+	pi.CanceledAt = p.now().Unix()
+	return copyIntent(pi), nil
+}
+
+func (p *FakeProvider) CreateRefund(ctx context.Context, in RefundParams) (*stripe.Refund, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if in.IdempotencyKey != "" {
+		if prior, ok := p.idempotent["re:"+in.IdempotencyKey].(*stripe.Refund); ok {
+			cp := *prior
+			return &cp, nil
+		}
+	}
+
+	pi, ok := p.intents[in.PaymentIntentID]
+	if !ok {
+		return nil, ErrFakeIntentNotFound
+	}
+	if pi.Status != stripe.PaymentIntentStatusSucceeded {
+		return nil, ErrFakeInvalidState
+	}
+
+	var refunded int64
+	for _, rf := range p.refunds[pi.ID] {
+		refunded += rf.Amount
+	}
+	amount := in.AmountCents
+	if amount == 0 {
+		amount = pi.Amount - refunded
+	}
+	if amount <= 0 || refunded+amount > pi.Amount {
+		return nil, ErrFakeRefundTooLarge
+	}
+
+	rf := &stripe.Refund{
+		ID:            p.nextID("re"),
+		Object:        "refund",
+		Amount:        amount,
+		Currency:      pi.Currency,
+		Created:       p.now().Unix(),
+		Metadata:      in.Metadata,
+		PaymentIntent: &stripe.PaymentIntent{ID: pi.ID},
+		Status:        stripe.RefundStatusSucceeded,
+	}
+	p.refunds[pi.ID] = append(p.refunds[pi.ID], rf)
+	if in.IdempotencyKey != "" {
+		p.idempotent["re:"+in.IdempotencyKey] = rf
+	}
+
+	cp := *rf
+	return &cp, nil
+}
+
+func (p *FakeProvider) ListRefunds(ctx context.Context, paymentIntentID string) ([]*stripe.Refund, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	refunds := make([]*stripe.Refund, 0, len(p.refunds[paymentIntentID]))
+	for _, rf := range p.refunds[paymentIntentID] {
+		cp := *rf
+		refunds = append(refunds, &cp)
+	}
+	return refunds, nil
+}
+
+func (p *FakeProvider) ConstructEvent(payload []byte, signatureHeader string) (stripe.Event, error) {
+	return webhook.ConstructEvent(payload, signatureHeader, p.webhookSecret)
+}
+
+// SucceedPaymentIntent simulates the customer completing payment.
+func (p *FakeProvider) SucceedPaymentIntent(id string) (*stripe.PaymentIntent, error) {
+	return p.settle(id, stripe.PaymentIntentStatusSucceeded)
+}
+
+// FailPaymentIntent simulates a declined payment attempt. The intent goes
+// back to waiting for a payment method, as it does on Stripe.
+func (p *FakeProvider) FailPaymentIntent(id string) (*stripe.PaymentIntent, error) {
+	return p.settle(id, stripe.PaymentIntentStatusRequiresPaymentMethod)
+}
+
+func (p *FakeProvider) settle(id string, status stripe.PaymentIntentStatus) (*stripe.PaymentIntent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pi, ok := p.intents[id]
+	if !ok {
+		return nil, ErrFakeIntentNotFound
+	}
+	if pi.Status == stripe.PaymentIntentStatusSucceeded || pi.Status == stripe.PaymentIntentStatusCanceled {
+		return nil, ErrFakeInvalidState
+	}
+	pi.Status = status
+	pi.AmountReceived = 0
+	if status == stripe.PaymentIntentStatusSucceeded {
+		pi.AmountReceived = pi.Amount
+	}
+	return copyIntent(pi), nil
+}
+
+// SignedEvent wraps object in an event of the given type and signs it with
+// the provider's webhook secret. It returns the request body and the value
+// for the Stripe-Signature header.
+func (p *FakeProvider) SignedEvent(eventType stripe.EventType, object interface{}) ([]byte, string, error) {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		return nil, "", fmt.Errorf("fake provider: encode event object: %w", err)
+	}
+
+	p.mu.Lock()
+	id := p.nextID("evt")
+	created := p.now()
+	p.mu.Unlock()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":          id,
+		"object":      "event",
+		"api_version": stripe.APIVersion,
+		"created":     created.Unix(),
+		"livemode":    false,
+		"type":        eventType,
+		"data":        map[string]json.RawMessage{"object": raw},
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("fake provider: encode event: %w", err)
+	}
+
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{
+		Payload:   payload,
+		Secret:    p.webhookSecret,
+		Timestamp: created,
+	})
+	return signed.Payload, signed.Header, nil
+}
+
+func copyIntent(pi *stripe.PaymentIntent) *stripe.PaymentIntent {
+	cp := *pi
+	return &cp
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stripe/stripe-go/v76"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/money"
+)
+
+func TestFakeProviderIDsFollowRunID(t *testing.T) {
+	object := map[string]string{"id": "pi_1"}
+	eventID := func(p *FakeProvider) string {
+		payload, _, err := p.SignedEvent("payment_intent.succeeded", object)
+		if err != nil {
+			t.Fatalf("failed to sign event: %v", err)
+		}
+		var event struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(payload, &event)
+		return event.ID
+	}
+
+	// The same run ID gives the same IDs, so tests can assert on them
+	if got := eventID(NewFakeProvider("", "")); got != "evt_fake_local_000001" {
+		t.Errorf("event ID = %s, want evt_fake_local_000001", got)
+	}
+
+	// A restarted server must not reuse event IDs already recorded as
+	// processed, or its webhooks would be skipped as duplicates
+	if a, b := eventID(NewFakeProvider("", "run1")), eventID(NewFakeProvider("", "run2")); a == b {
+		t.Errorf("both providers produced event %s", a)
+	}
+}
+
+func TestFakeProviderCheckoutFlow(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeProvider("whsec_test", "")
+
+	pi, err := fake.CreatePaymentIntent(ctx, CreateIntentParams{
+		AmountCents: 1999,
+		Currency:    "usd",
+		Metadata:    map[string]string{"order_id": "42"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create intent: %v", err)
+	}
+
+	if pi.ID != "pi_fake_local_000001" {
+		t.Errorf("expected sequential ID, got %s", pi.ID)
+	}
+	if pi.Status != stripe.PaymentIntentStatusRequiresPaymentMethod {
+		t.Errorf("expected requires_payment_method, got %s", pi.Status)
+	}
+
+	paid, err := fake.SucceedPaymentIntent(pi.ID)
+	if err != nil {
+		t.Fatalf("failed to settle intent: %v", err)
+	}
+
+	payload, header, err := fake.SignedEvent("payment_intent.succeeded", paid)
+	if err != nil {
+		t.Fatalf("failed to sign event: %v", err)
+	}
+
+	event, err := fake.ConstructEvent(payload, header)
+	if err != nil {
+		t.Fatalf("signed event did not verify: %v", err)
+	}
+
+	if event.Type != "payment_intent.succeeded" {
+		t.Errorf("unexpected event type %s", event.Type)
+	}
+
+	var got stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &got); err != nil {
+		t.Fatalf("failed to decode event object: %v", err)
+	}
+	if got.ID != pi.ID || got.Amount != 1999 || got.Metadata["order_id"] != "42" {
+		t.Errorf("event object does not match intent: %+v", got)
+	}
+	if got.Status != stripe.PaymentIntentStatusSucceeded {
+		t.Errorf("expected succeeded, got %s", got.Status)
+	}
+}
+
+func TestFakeProviderRejectsForeignSignature(t *testing.T) {
+	fake := NewFakeProvider("whsec_test", "")
+	other := NewFakeProvider("whsec_other", "")
+
+	payload, header, err := other.SignedEvent("payment_intent.succeeded", &stripe.PaymentIntent{ID: "pi_x"})
+	if err != nil {
+		t.Fatalf("failed to sign event: %v", err)
+	}
+
+	if _, err := fake.ConstructEvent(payload, header); err == nil {
+		t.Error("expected signature from another secret to be rejected")
+	}
+}
+
+func TestFakeProviderIdempotentCreate(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeProvider("", "")
+
+	params := CreateIntentParams{AmountCents: 500, Currency: "usd", IdempotencyKey: "order-1"}
+	first, err := fake.CreatePaymentIntent(ctx, params)
+	if err != nil {
+		t.Fatalf("failed to create intent: %v", err)
+	}
+	second, err := fake.CreatePaymentIntent(ctx, params)
+	if err != nil {
+		t.Fatalf("failed to create intent: %v", err)
+	}
+
+	if first.ID != second.ID {
+		t.Errorf("expected same intent for same key, got %s and %s", first.ID, second.ID)
+	}
+}
+
+func TestFakeProviderRefunds(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeProvider("", "")
+
+	pi, _ := fake.CreatePaymentIntent(ctx, CreateIntentParams{AmountCents: 1000, Currency: "usd"})
+
+	if _, err := fake.CreateRefund(ctx, RefundParams{PaymentIntentID: pi.ID, AmountCents: 100}); !errors.Is(err, ErrFakeInvalidState) {
+		t.Errorf("expected refund of unpaid intent to fail, got %v", err)
+	}
+
+	fake.SucceedPaymentIntent(pi.ID)
+
+	if _, err := fake.CreateRefund(ctx, RefundParams{PaymentIntentID: pi.ID, AmountCents: 600}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := fake.CreateRefund(ctx, RefundParams{PaymentIntentID: pi.ID, AmountCents: 500}); !errors.Is(err, ErrFakeRefundTooLarge) {
+		t.Errorf("expected over-refund to fail, got %v", err)
+	}
+
+	refunds, _ := fake.ListRefunds(ctx, pi.ID)
+	if len(refunds) != 1 || refunds[0].Amount != 600 {
+		t.Errorf("unexpected refunds: %+v", refunds)
+	}
+}
+
+func TestPaymentServiceCancel(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeProvider("", "")
+	svc := NewPaymentService(fake)
+
+	open, _ := fake.CreatePaymentIntent(ctx, CreateIntentParams{AmountCents: 1000, Currency: "usd"})
+	pi, err := svc.CancelPaymentIntent(ctx, open.ID)
+	if err != nil || pi.Status != stripe.PaymentIntentStatusCanceled {
+		t.Fatalf("expected cancel to succeed, got %v (%v)", pi, err)
+	}
+
+	if _, err := svc.CancelPaymentIntent(ctx, open.ID); err != nil {
+		t.Errorf("canceling twice should not fail: %v", err)
+	}
+
+	paid, _ := fake.CreatePaymentIntent(ctx, CreateIntentParams{AmountCents: 1000, Currency: "usd"})
+	fake.SucceedPaymentIntent(paid.ID)
+	if _, err := svc.CancelPaymentIntent(ctx, paid.ID); !errors.Is(err, ErrPaymentAlreadySucceeded) {
+		t.Errorf("expected ErrPaymentAlreadySucceeded, got %v", err)
+	}
+}
+
+func TestPaymentServiceSyncIntent(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeProvider("", "")
+	svc := NewPaymentService(fake)
+
+	pi, _ := svc.CreatePaymentIntent(ctx, money.New(1000, "USD"), "", nil)
+	if !IntentReusable(pi) {
+		t.Fatalf("expected new intent to be reusable, got %s", pi.Status)
+	}
+
+	same, err := svc.SyncPaymentIntent(ctx, pi, money.New(1000, "USD"))
+	if err != nil || same.ID != pi.ID || same.Amount != 1000 {
+		t.Errorf("unexpected result for unchanged total: %v (%v)", same, err)
+	}
+
+	updated, err := svc.SyncPaymentIntent(ctx, pi, money.New(1250, "USD"))
+	if err != nil || updated.ID != pi.ID || updated.Amount != 1250 {
+		t.Errorf("expected amount to be updated in place: %v (%v)", updated, err)
+	}
+
+	paid, _ := fake.SucceedPaymentIntent(pi.ID)
+	if IntentReusable(paid) {
+		t.Error("expected succeeded intent not to be reusable")
+	}
+	if _, err := svc.SyncPaymentIntent(ctx, paid, money.New(2000, "USD")); !errors.Is(err, ErrFakeInvalidState) {
+		t.Errorf("expected update of paid intent to fail, got %v", err)
+	}
+}
@@ -1,48 +1,106 @@
 package services
 
 import (
-"fmt"
+"context"
+"errors"
 
 "github.com/stripe/stripe-go/v76"
-"github.com/stripe/stripe-go/v76/paymentintent"
+
+"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/money"
 )
 
-type PaymentService struct{}
+type PaymentService struct {
+provider PaymentProvider
+}
 
-func NewPaymentService(stripeKey string) *PaymentService {
-stripe.Key = stripeKey
-return &PaymentService{}
+func NewPaymentService(provider PaymentProvider) *PaymentService {
+return &PaymentService{provider: provider}
 }
 
-// CreatePaymentIntent - Creates a Stripe payment intent
-// Returns client_secret that frontend uses to confirm payment
-func (s *PaymentService) CreatePaymentIntent(amountCents int64, currency string, metadata map[string]string) (*stripe.PaymentIntent, error) {
-params := &stripe.PaymentIntentParams{
-Amount:   stripe.Int64(amountCents),
-Currency: stripe.String(currency),
-AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
-Enabled: stripe.Bool(true),
-},
+// Provider - Returns the underlying payment provider
+func (s *PaymentService) Provider() PaymentProvider {
+return s.provider
 }
 
-// Add metadata (order ID, user ID, etc)
-for key, value := range metadata {
-params.AddMetadata(key, value)
+// CreatePaymentIntent - Creates a payment intent
+// Returns client_secret that frontend uses to confirm payment. A non-empty
+// idempotency key makes retries return the same intent.
+func (s *PaymentService) CreatePaymentIntent(ctx context.Context, amount money.Money, idempotencyKey string, metadata map[string]string) (*stripe.PaymentIntent, error) {
+return s.provider.CreatePaymentIntent(ctx, CreateIntentParams{
+AmountCents:    amount.Amount,
+Currency:       amount.ProviderCurrency(),
+Metadata:       metadata,
+IdempotencyKey: idempotencyKey,
+})
 }
 
-pi, err := paymentintent.New(params)
-if err != nil {
-return nil, fmt.Errorf("failed to create payment intent: %w", err)
+// GetPaymentIntent - Retrieves payment intent status
+func (s *PaymentService) GetPaymentIntent(ctx context.Context, paymentIntentID string) (*stripe.PaymentIntent, error) {
+return s.provider.GetPaymentIntent(ctx, paymentIntentID)
+}
+
+// IntentReusable - Reports whether the customer can still pay with an intent
+func IntentReusable(pi *stripe.PaymentIntent) bool {
+switch pi.Status {
+case stripe.PaymentIntentStatusRequiresPaymentMethod,
+stripe.PaymentIntentStatusRequiresConfirmation,
+stripe.PaymentIntentStatusRequiresAction:
+return true
+}
+return false
 }
 
+// SyncPaymentIntent - Updates an unpaid intent to charge amount
+// The intent is returned unchanged when it already matches
+func (s *PaymentService) SyncPaymentIntent(ctx context.Context, pi *stripe.PaymentIntent, amount money.Money) (*stripe.PaymentIntent, error) {
+if pi.Amount == amount.Amount && string(pi.Currency) == amount.ProviderCurrency() {
 return pi, nil
 }
+return s.provider.UpdatePaymentIntent(ctx, pi.ID, UpdateIntentParams{
+AmountCents: amount.Amount,
+Currency:    amount.ProviderCurrency(),
+})
+}
 
-// GetPaymentIntent - Retrieves payment intent status
-func (s *PaymentService) GetPaymentIntent(paymentIntentID string) (*stripe.PaymentIntent, error) {
-pi, err := paymentintent.Get(paymentIntentID, nil)
+// ErrPaymentAlreadySucceeded is returned when an intent can no longer be
+// canceled because the customer has already paid
+var ErrPaymentAlreadySucceeded = errors.New("payment intent has already succeeded")
+
+// CancelPaymentIntent - Cancels a payment intent that has not been paid
+// Canceling an intent that is already canceled is not an error
+func (s *PaymentService) CancelPaymentIntent(ctx context.Context, paymentIntentID string) (*stripe.PaymentIntent, error) {
+pi, err := s.provider.GetPaymentIntent(ctx, paymentIntentID)
 if err != nil {
-return nil, fmt.Errorf("failed to retrieve payment intent: %w", err)
+return nil, err
 }
+
+switch pi.Status {
+case stripe.PaymentIntentStatusCanceled:
 return pi, nil
+case stripe.PaymentIntentStatusSucceeded:
+return pi, ErrPaymentAlreadySucceeded
+}
+
+return s.provider.CancelPaymentIntent(ctx, paymentIntentID)
+}
+
+// CreateRefund - Refunds part or all of a succeeded payment intent
+// The idempotency key makes retries of the same refund safe
+func (s *PaymentService) CreateRefund(ctx context.Context, paymentIntentID string, amountCents int64, idempotencyKey string, metadata map[string]string) (*stripe.Refund, error) {
+return s.provider.CreateRefund(ctx, RefundParams{
+PaymentIntentID: paymentIntentID,
+AmountCents:     amountCents,
+Metadata:        metadata,
+IdempotencyKey:  idempotencyKey,
+})
+}
+
+// ListRefunds - Lists every refund made against a payment intent
+func (s *PaymentService) ListRefunds(ctx context.Context, paymentIntentID string) ([]*stripe.Refund, error) {
+return s.provider.ListRefunds(ctx, paymentIntentID)
+}
+
+// ConstructEvent - Verifies a webhook signature and decodes the event
+func (s *PaymentService) ConstructEvent(payload []byte, signatureHeader string) (stripe.Event, error) {
+return s.provider.ConstructEvent(payload, signatureHeader)
 }
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/client"
+	"github.com/stripe/stripe-go/v76/webhook"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/internal/tracing"
+)
+
+// StripeProvider talks to the Stripe API with its own client, so the key is
+// never written to the global stripe.Key.
+type StripeProvider struct {
+	api           *client.API
+	webhookSecret string
+}
+
+// stripeTimeout matches the timeout of Stripe's default HTTP client.
+const stripeTimeout = 80 * time.Second
+
+func NewStripeProvider(secretKey, webhookSecret string) *StripeProvider {
+	// API calls go through a traced transport so each one shows up as a
+	// span of the request that made it.
+	backends := stripe.NewBackendsWithConfig(&stripe.BackendConfig{
+		HTTPClient: &http.Client{
+			Timeout:   stripeTimeout,
+			Transport: tracing.Transport(http.DefaultTransport, "stripe"),
+		},
+	})
+	return &StripeProvider{
+		api:           client.New(secretKey, backends),
+		webhookSecret: webhookSecret,
+	}
+}
+
+func (p *StripeProvider) CreatePaymentIntent(ctx context.Context, in CreateIntentParams) (*stripe.PaymentIntent, error) {
+	params := &stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(in.AmountCents),
+		Currency: stripe.String(in.Currency),
+		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
+			Enabled: stripe.Bool(true),
+		},
+	}
+	params.Context = ctx
+	if in.IdempotencyKey != "" {
+		params.SetIdempotencyKey(in.IdempotencyKey)
+	}
+	for key, value := range in.Metadata {
+		params.AddMetadata(key, value)
+	}
+
+	pi, err := p.api.PaymentIntents.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("stripe: create payment intent: %w", err)
+	}
+	return pi, nil
+}
+
+func (p *StripeProvider) GetPaymentIntent(ctx context.Context, id string) (*stripe.PaymentIntent, error) {
+	params := &stripe.PaymentIntentParams{}
+	params.Context = ctx
+
+	pi, err := p.api.PaymentIntents.Get(id, params)
+	if err != nil {
+		return nil, fmt.Errorf("stripe: get payment intent: %w", err)
+	}
+	return pi, nil
+}
+
+func (p *StripeProvider) UpdatePaymentIntent(ctx context.Context, id string, in UpdateIntentParams) (*stripe.PaymentIntent, error) {
+	params := &stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(in.AmountCents),
+		Currency: stripe.String(in.Currency),
+	}
+	params.Context = ctx
+
+	pi, err := p.api.PaymentIntents.Update(id, params)
+	if err != nil {
+		return nil, fmt.Errorf("stripe: update payment intent: %w", err)
+	}
+	return pi, nil
+}
+
+func (p *StripeProvider) CancelPaymentIntent(ctx context.Context, id string) (*stripe.PaymentIntent, error) {
+	params := &stripe.PaymentIntentCancelParams{}
+	params.Context = ctx
+
+	pi, err := p.api.PaymentIntents.Cancel(id, params)
+	if err != nil {
+		return nil, fmt.Errorf("stripe: cancel payment intent: %w", err)
+	}
+	return pi, nil
+}
+
+func (p *StripeProvider) CreateRefund(ctx context.Context, in RefundParams) (*stripe.Refund, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(in.PaymentIntentID),
+		Amount:        stripe.Int64(in.AmountCents),
+	}
+	params.Context = ctx
+	if in.IdempotencyKey != "" {
+		params.SetIdempotencyKey(in.IdempotencyKey)
+	}
+	for key, value := range in.Metadata {
+		params.AddMetadata(key, value)
+	}
+
+	rf, err := p.api.Refunds.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("stripe: create refund: %w", err)
+	}
+	return rf, nil
+}
+
+func (p *StripeProvider) ListRefunds(ctx context.Context, paymentIntentID string) ([]*stripe.Refund, error) {
+	params := &stripe.RefundListParams{
+		PaymentIntent: stripe.String(paymentIntentID),
+	}
+	params.Context = ctx
+
+	var refunds []*stripe.Refund
+	iter := p.api.Refunds.List(params)
+	for iter.Next() {
+		refunds = append(refunds, iter.Refund())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("stripe: list refunds: %w", err)
+	}
+	return refunds, nil
+}
+
+func (p *StripeProvider) ConstructEvent(payload []byte, signatureHeader string) (stripe.Event, error) {
+	if p.webhookSecret == "" {
+		return stripe.Event{}, ErrWebhookSecretMissing
+	}
+	return webhook.ConstructEvent(payload, signatureHeader, p.webhookSecret)
+}
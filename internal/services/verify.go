@@ -0,0 +1,72 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stripe/stripe-go/v76"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/money"
+)
+
+// Kinds of discrepancy between a succeeded payment and the order it claims
+// to pay for.
+const (
+	MismatchAmount   = "amount_mismatch"
+	MismatchCurrency = "currency_mismatch"
+	MismatchIntent   = "intent_mismatch"
+	// MismatchOrderNotPayable is a matching payment for an order that can
+	// no longer be paid, e.g. one canceled before the payment went through.
+	MismatchOrderNotPayable = "order_not_payable"
+)
+
+// PaymentMismatch describes one way a payment intent disagrees with its order.
+type PaymentMismatch struct {
+	Kind     string `json:"kind"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+func (m PaymentMismatch) String() string {
+	return fmt.Sprintf("%s: expected %s, got %s", m.Kind, m.Expected, m.Actual)
+}
+
+// VerifyPaymentIntent compares a succeeded intent with the order total and
+// the intent ID stored on the order. An empty storedIntentID is not a
+// mismatch. It returns nil when the payment matches the order.
+func VerifyPaymentIntent(pi *stripe.PaymentIntent, total money.Money, storedIntentID string) []PaymentMismatch {
+	var mismatches []PaymentMismatch
+
+	currency := strings.ToUpper(string(pi.Currency))
+	if currency != total.Currency {
+		mismatches = append(mismatches, PaymentMismatch{
+			Kind:     MismatchCurrency,
+			Expected: total.Currency,
+			Actual:   currency,
+		})
+	}
+
+	// Stripe sets amount_received once funds are captured; prefer it when
+	// present since it is what the customer actually paid.
+	received := pi.Amount
+	if pi.AmountReceived != 0 {
+		received = pi.AmountReceived
+	}
+	if received != total.Amount {
+		mismatches = append(mismatches, PaymentMismatch{
+			Kind:     MismatchAmount,
+			Expected: fmt.Sprintf("%d", total.Amount),
+			Actual:   fmt.Sprintf("%d", received),
+		})
+	}
+
+	if storedIntentID != "" && storedIntentID != pi.ID {
+		mismatches = append(mismatches, PaymentMismatch{
+			Kind:     MismatchIntent,
+			Expected: storedIntentID,
+			Actual:   pi.ID,
+		})
+	}
+
+	return mismatches
+}
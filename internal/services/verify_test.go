@@ -0,0 +1,71 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stripe/stripe-go/v76"
+
+	"github.com/ibraheemcisse/ioc-labs-ecommerce/pkg/money"
+)
+
+func TestVerifyPaymentIntent(t *testing.T) {
+	total := money.New(1999, "USD")
+
+	tests := []struct {
+		name     string
+		pi       stripe.PaymentIntent
+		storedID string
+		want     []string
+	}{
+		{
+			name: "matching payment",
+			pi:   stripe.PaymentIntent{ID: "pi_1", Amount: 1999, AmountReceived: 1999, Currency: "usd"},
+		},
+		{
+			name:     "matching stored intent",
+			pi:       stripe.PaymentIntent{ID: "pi_1", Amount: 1999, Currency: "usd"},
+			storedID: "pi_1",
+		},
+		{
+			name: "short payment",
+			pi:   stripe.PaymentIntent{ID: "pi_1", Amount: 1999, AmountReceived: 999, Currency: "usd"},
+			want: []string{MismatchAmount},
+		},
+		{
+			name: "wrong amount on intent",
+			pi:   stripe.PaymentIntent{ID: "pi_1", Amount: 100, Currency: "usd"},
+			want: []string{MismatchAmount},
+		},
+		{
+			name: "wrong currency",
+			pi:   stripe.PaymentIntent{ID: "pi_1", Amount: 1999, Currency: "eur"},
+			want: []string{MismatchCurrency},
+		},
+		{
+			name:     "stale intent",
+			pi:       stripe.PaymentIntent{ID: "pi_old", Amount: 1999, Currency: "usd"},
+			storedID: "pi_new",
+			want:     []string{MismatchIntent},
+		},
+		{
+			name:     "everything wrong",
+			pi:       stripe.PaymentIntent{ID: "pi_old", Amount: 5, Currency: "jpy"},
+			storedID: "pi_new",
+			want:     []string{MismatchCurrency, MismatchAmount, MismatchIntent},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := VerifyPaymentIntent(&tt.pi, total, tt.storedID)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want kinds %v", got, tt.want)
+			}
+			for i, m := range got {
+				if m.Kind != tt.want[i] {
+					t.Errorf("mismatch %d is %s, want %s", i, m.Kind, tt.want[i])
+				}
+			}
+		})
+	}
+}
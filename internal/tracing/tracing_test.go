@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider that keeps finished spans in
+// memory for the duration of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return recorder
+}
+
+func TestMiddlewareNamesSpanAfterRoute(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var handlerTraceID string
+	r := mux.NewRouter()
+	r.Use(Route)
+	r.HandleFunc("/api/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerTraceID = trace.SpanContextFromContext(r.Context()).TraceID().String()
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/orders/7", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	Middleware(r).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "GET /api/orders/{id}" {
+		t.Errorf("span name = %q", span.Name())
+	}
+	if got := span.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID = %s, want the caller's trace", got)
+	}
+	if span.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("parent span = %s, want the caller's span", span.Parent().SpanID())
+	}
+	if handlerTraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("handler saw trace %s", handlerTraceID)
+	}
+}
+
+func TestMiddlewareSkipsMetrics(t *testing.T) {
+	recorder := recordSpans(t)
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if n := len(recorder.Ended()); n != 0 {
+		t.Errorf("expected scrapes not to be traced, got %d spans", n)
+	}
+}
+
+func TestRedisHook(t *testing.T) {
+	recorder := recordSpans(t)
+	hook := RedisHook{}
+	ctx := context.Background()
+
+	get := redis.NewStringCmd(ctx, "get", "auth:login:account:jane@example.com")
+	get.SetErr(redis.Nil)
+	spanCtx, _ := hook.BeforeProcess(ctx, get)
+	hook.AfterProcess(spanCtx, get)
+
+	set := redis.NewStatusCmd(ctx, "set", "k", "v")
+	set.SetErr(errors.New("connection refused"))
+	spanCtx, _ = hook.BeforeProcess(ctx, set)
+	hook.AfterProcess(spanCtx, set)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Name() != "redis get" || spans[0].Status().Code == codes.Error {
+		t.Errorf("a missing key should not fail the span: %s %v", spans[0].Name(), spans[0].Status())
+	}
+	if spans[1].Name() != "redis set" || spans[1].Status().Code != codes.Error {
+		t.Errorf("a command error should fail the span: %s %v", spans[1].Name(), spans[1].Status())
+	}
+	for _, attr := range spans[0].Attributes() {
+		if attr.Value.Emit() == "auth:login:account:jane@example.com" {
+			t.Error("command arguments must not be recorded")
+		}
+	}
+}
+
+func TestSetupExporters(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+	shutdown, err := Setup(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Setup(none) failed: %v", err)
+	}
+	shutdown(context.Background())
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "jaeger")
+	if _, err := Setup(context.Background(), "test"); err == nil {
+		t.Error("expected an unknown exporter to be rejected")
+	}
+}
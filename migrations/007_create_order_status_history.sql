@@ -0,0 +1,56 @@
+-- Order lifecycle: constrained statuses and an audit trail of every transition
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    from_status VARCHAR(50),
+    to_status VARCHAR(50) NOT NULL,
+    actor VARCHAR(100) NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_order_status_history_order_id ON order_status_history(order_id);
+
+-- Normalize statuses written before the lifecycle was enforced, so the
+-- constraint below can be added. Known spellings map to their lifecycle
+-- status; anything else is held in payment_review for an operator. Each
+-- change is recorded with the original value.
+WITH cleaned AS (
+    SELECT id, status AS legacy,
+        CASE LOWER(TRIM(COALESCE(status, 'pending')))
+            WHEN 'cancelled' THEN 'canceled'
+            WHEN 'complete' THEN 'delivered'
+            WHEN 'completed' THEN 'delivered'
+            WHEN 'processing' THEN 'paid'
+            WHEN 'failed' THEN 'awaiting_payment'
+            ELSE LOWER(TRIM(COALESCE(status, 'pending')))
+        END AS status
+    FROM orders
+), normalized AS (
+    SELECT id, legacy,
+        CASE WHEN status IN (
+            'pending', 'awaiting_payment', 'payment_review', 'paid', 'fulfilled', 'shipped', 'delivered', 'canceled', 'refunded'
+        ) THEN status ELSE 'payment_review' END AS status
+    FROM cleaned
+), history AS (
+    INSERT INTO order_status_history (order_id, from_status, to_status, actor, reason)
+    SELECT id, legacy, status, 'system', 'normalized legacy status'
+    FROM normalized
+    WHERE legacy IS DISTINCT FROM status
+)
+UPDATE orders o SET status = n.status
+FROM normalized n
+WHERE o.id = n.id AND o.status IS DISTINCT FROM n.status;
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check CHECK (status IN (
+    'pending', 'awaiting_payment', 'payment_review', 'paid', 'fulfilled', 'shipped', 'delivered', 'canceled', 'refunded'
+));
+
+-- Backfill a starting entry for orders placed before history was recorded
+INSERT INTO order_status_history (order_id, from_status, to_status, actor, reason, created_at)
+SELECT o.id, NULL, o.status, 'system', 'backfilled', o.created_at
+FROM orders o
+WHERE NOT EXISTS (SELECT 1 FROM order_status_history h WHERE h.order_id = o.id);
@@ -0,0 +1,2 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS canceled_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS canceled_by VARCHAR(100);
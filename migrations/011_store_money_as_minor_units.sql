@@ -0,0 +1,61 @@
+-- Store money as integer minor units (cents) with an ISO 4217 currency code.
+-- DECIMAL(10,2) values are exact, so multiplying by 100 never rounds.
+--
+-- Safe to run again: the backfills only run while the old DECIMAL columns
+-- still exist, and the constraint is only added once.
+
+-- Products
+ALTER TABLE products ADD COLUMN IF NOT EXISTS price_cents BIGINT;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_schema = current_schema() AND table_name = 'products' AND column_name = 'price') THEN
+        UPDATE products SET price_cents = ROUND(price * 100) WHERE price_cents IS NULL;
+    END IF;
+END $$;
+ALTER TABLE products ALTER COLUMN price_cents SET NOT NULL;
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint
+                   WHERE conrelid = 'products'::regclass AND conname = 'products_price_cents_check') THEN
+        ALTER TABLE products ADD CONSTRAINT products_price_cents_check CHECK (price_cents >= 0);
+    END IF;
+END $$;
+DROP INDEX IF EXISTS idx_products_price;
+ALTER TABLE products DROP COLUMN IF EXISTS price;
+CREATE INDEX IF NOT EXISTS idx_products_price_cents ON products(price_cents);
+
+-- Orders
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS total_cents BIGINT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_schema = current_schema() AND table_name = 'orders' AND column_name = 'total') THEN
+        UPDATE orders SET total_cents = ROUND(total * 100) WHERE total_cents IS NULL;
+    END IF;
+END $$;
+ALTER TABLE orders ALTER COLUMN total_cents SET NOT NULL;
+ALTER TABLE orders DROP COLUMN IF EXISTS total;
+
+-- Order items
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS price_cents BIGINT;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS subtotal_cents BIGINT;
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_schema = current_schema() AND table_name = 'order_items' AND column_name = 'price') THEN
+        UPDATE order_items
+        SET price_cents = ROUND(price * 100), subtotal_cents = ROUND(subtotal * 100)
+        WHERE price_cents IS NULL;
+    END IF;
+END $$;
+ALTER TABLE order_items ALTER COLUMN price_cents SET NOT NULL;
+ALTER TABLE order_items ALTER COLUMN subtotal_cents SET NOT NULL;
+ALTER TABLE order_items DROP COLUMN IF EXISTS price;
+ALTER TABLE order_items DROP COLUMN IF EXISTS subtotal;
+
+-- Refunds already use cents; normalize their currency codes to ISO upper case
+UPDATE refunds SET currency = UPPER(currency);
+ALTER TABLE refunds ALTER COLUMN currency SET DEFAULT 'USD';
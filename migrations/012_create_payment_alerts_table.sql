@@ -0,0 +1,17 @@
+-- Payments that do not match their order are held in payment_review (see
+-- the status constraint in 007) and raise an alert for an operator to resolve
+
+CREATE TABLE IF NOT EXISTS payment_alerts (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    payment_intent_id VARCHAR(255) NOT NULL,
+    mismatches JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open'
+        CHECK (status IN ('open', 'approved', 'dismissed')),
+    resolved_by VARCHAR(100),
+    resolution_note TEXT,
+    resolved_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (order_id, payment_intent_id)
+);
+CREATE INDEX IF NOT EXISTS idx_payment_alerts_status ON payment_alerts(status);
@@ -0,0 +1,6 @@
+-- Email verification. Accounts created before verification existed are
+-- treated as verified so they are not locked out of checkout.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+
+UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
@@ -0,0 +1,14 @@
+-- Security-relevant events (account lockouts, unlocks, ...). Rows are only
+-- ever inserted; user_id is kept NULL for events about unknown accounts.
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    event VARCHAR(100) NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    actor VARCHAR(100) NOT NULL,
+    ip VARCHAR(64),
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_event ON audit_log(event, created_at);
@@ -0,0 +1,12 @@
+-- The Idempotency-Key an admin refund was requested with, so a retry
+-- returns the refund the first attempt recorded. Webhook refunds have none.
+
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(200);
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint
+                   WHERE conrelid = 'refunds'::regclass AND conname = 'refunds_order_idempotency_key') THEN
+        ALTER TABLE refunds ADD CONSTRAINT refunds_order_idempotency_key UNIQUE (order_id, idempotency_key);
+    END IF;
+END $$;
@@ -0,0 +1,172 @@
+// Package money represents amounts as integer minor units (e.g. cents)
+// tagged with an ISO 4217 currency code.
+//
+// Rounding rules: Add and Mul are exact, and Mul panics with ErrOverflow
+// rather than wrap around. Scale, for rates such as tax and discounts, is
+// the only operation that rounds: it rounds half to even to the nearest
+// minor unit, so halves do not drift upward across many lines.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used for catalog prices that do not name a currency.
+const DefaultCurrency = "USD"
+
+var (
+	ErrCurrencyMismatch = errors.New("money: currency mismatch")
+	ErrInvalidCurrency  = errors.New("money: invalid currency code")
+	ErrOverflow         = errors.New("money: amount overflows int64")
+	ErrZeroDenominator  = errors.New("money: zero denominator")
+)
+
+// minorUnitExponents lists currencies whose minor unit is not 1/100.
+var minorUnitExponents = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"VND": 0,
+	"CLP": 0,
+	"BHD": 3,
+	"KWD": 3,
+	"OMR": 3,
+	"JOD": 3,
+	"TND": 3,
+}
+
+// Exponent returns the number of decimal places in the currency's minor unit.
+func Exponent(currency string) int {
+	if exp, ok := minorUnitExponents[strings.ToUpper(currency)]; ok {
+		return exp
+	}
+	return 2
+}
+
+// Money is an amount in minor units of Currency.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// New returns amount minor units of currency. The currency code is
+// normalized to upper case.
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// Zero returns a zero amount in currency.
+func Zero(currency string) Money {
+	return New(0, currency)
+}
+
+// Add returns m + other. Both must be in the same currency.
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Mul returns m multiplied by a whole quantity. It panics with ErrOverflow
+// if the result does not fit in an int64; callers bound quantities long
+// before that.
+func (m Money) Mul(quantity int) Money {
+	q := int64(quantity)
+	amount := m.Amount * q
+	if q != 0 && (amount/q != m.Amount || (q == -1 && m.Amount == math.MinInt64)) {
+		panic(fmt.Errorf("%w: %d * %d", ErrOverflow, m.Amount, quantity))
+	}
+	return Money{Amount: amount, Currency: m.Currency}
+}
+
+// Scale returns m multiplied by num/den, rounded half to even to the nearest
+// minor unit. A 8.25% tax on m is m.Scale(825, 10000).
+func (m Money) Scale(num, den int64) (Money, error) {
+	if den == 0 {
+		return Money{}, ErrZeroDenominator
+	}
+
+	n := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(num))
+	d := big.NewInt(den)
+	if d.Sign() < 0 {
+		n.Neg(n)
+		d.Neg(d)
+	}
+
+	// QuoRem truncates toward zero; the remainder decides the rounding
+	q, rem := new(big.Int).QuoRem(n, d, new(big.Int))
+	twice := rem.Abs(rem).Lsh(rem, 1)
+	if c := twice.Cmp(d); c > 0 || (c == 0 && q.Bit(0) == 1) {
+		if n.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+
+	if !q.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: q.Int64(), Currency: m.Currency}, nil
+}
+
+// Decimal renders the amount in major units with the currency's number of
+// decimal places, e.g. "19.99".
+func (m Money) Decimal() string {
+	exp := Exponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if exp == 0 {
+		return sign + strconv.FormatInt(amount, 10)
+	}
+
+	scale := int64(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/scale, exp, amount%scale)
+}
+
+// String renders the amount with its currency, e.g. "19.99 USD".
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// ProviderCurrency returns the currency code in the lower-case form payment
+// providers such as Stripe expect.
+func (m Money) ProviderCurrency() string {
+	return strings.ToLower(m.Currency)
+}
+
+type moneyJSON struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Decimal  string `json:"decimal"`
+}
+
+// MarshalJSON renders money as {"amount": 1999, "currency": "USD",
+// "decimal": "19.99"} so clients never have to do float arithmetic.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Amount, Currency: m.Currency, Decimal: m.Decimal()})
+}
+
+// UnmarshalJSON accepts the object form produced by MarshalJSON. The amount
+// field is authoritative; the decimal field is ignored.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var v moneyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v.Currency) != 3 {
+		return ErrInvalidCurrency
+	}
+	*m = New(v.Amount, v.Currency)
+	return nil
+}
@@ -0,0 +1,110 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestArithmetic(t *testing.T) {
+	price := New(1999, "usd")
+	subtotal := price.Mul(3)
+	if subtotal.Amount != 5997 || subtotal.Currency != "USD" {
+		t.Errorf("unexpected subtotal %v", subtotal)
+	}
+
+	total, err := subtotal.Add(New(1, "USD"))
+	if err != nil || total.Amount != 5998 {
+		t.Errorf("unexpected total %v (%v)", total, err)
+	}
+
+	if _, err := price.Add(New(100, "EUR")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("expected ErrCurrencyMismatch, got %v", err)
+	}
+}
+
+func TestMulOverflow(t *testing.T) {
+	defer func() {
+		err, _ := recover().(error)
+		if !errors.Is(err, ErrOverflow) {
+			t.Errorf("expected an ErrOverflow panic, got %v", err)
+		}
+	}()
+	New(math.MaxInt64/2+1, "USD").Mul(2)
+}
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   int64
+		num, den int64
+		want     int64
+	}{
+		{name: "exact", amount: 2000, num: 825, den: 10000, want: 165},
+		{name: "tax at half a cent", amount: 1000, num: 825, den: 10000, want: 82},
+		{name: "below half rounds down", amount: 1001, num: 1, den: 3, want: 334},
+		{name: "half rounds to even below", amount: 25, num: 1, den: 10, want: 2},
+		{name: "half rounds to even above", amount: 35, num: 1, den: 10, want: 4},
+		{name: "above half rounds up", amount: 26, num: 1, den: 10, want: 3},
+		{name: "negative half rounds to even", amount: -25, num: 1, den: 10, want: -2},
+		{name: "negative above half rounds away", amount: -26, num: 1, den: 10, want: -3},
+		{name: "negative denominator", amount: 35, num: 1, den: -10, want: -4},
+		{name: "intermediate beyond int64", amount: math.MaxInt64, num: 3, den: 4, want: 6917529027641081855},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.amount, "USD").Scale(tt.num, tt.den)
+			if err != nil || got.Amount != tt.want || got.Currency != "USD" {
+				t.Errorf("Scale(%d, %d/%d) = %v (%v), want %d", tt.amount, tt.num, tt.den, got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := New(1, "USD").Scale(1, 0); !errors.Is(err, ErrZeroDenominator) {
+		t.Errorf("expected ErrZeroDenominator, got %v", err)
+	}
+	if _, err := New(math.MaxInt64, "USD").Scale(2, 1); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expected ErrOverflow, got %v", err)
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{money: New(1999, "USD"), want: "19.99"},
+		{money: New(5, "USD"), want: "0.05"},
+		{money: New(-105, "USD"), want: "-1.05"},
+		{money: New(1500, "JPY"), want: "1500"},
+		{money: New(1235, "KWD"), want: "1.235"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.money.Decimal(); got != tt.want {
+			t.Errorf("Decimal(%d %s) = %s, want %s", tt.money.Amount, tt.money.Currency, got, tt.want)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	data, err := json.Marshal(New(1999, "usd"))
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+
+	want := `{"amount":1999,"currency":"USD","decimal":"19.99"}`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+
+	var m Money
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if m != New(1999, "USD") {
+		t.Errorf("round trip produced %v", m)
+	}
+}
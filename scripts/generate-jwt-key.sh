@@ -0,0 +1,18 @@
+#!/bin/bash
+set -e
+
+# Generates a new Ed25519 signing key named after today's date. Keep the
+# previous keys in the directory until every token they signed has expired.
+KEYS_DIR=${1:-${JWT_KEYS_DIR:-keys}}
+KID=$(date +%Y-%m-%d)
+
+mkdir -p "$KEYS_DIR"
+if [ -e "$KEYS_DIR/$KID.pem" ]; then
+    echo "❌ $KEYS_DIR/$KID.pem already exists"
+    exit 1
+fi
+
+openssl genpkey -algorithm ed25519 -out "$KEYS_DIR/$KID.pem"
+chmod 600 "$KEYS_DIR/$KID.pem"
+
+echo "🔑 Created signing key $KID in $KEYS_DIR"
@@ -2,58 +2,58 @@
 DELETE FROM products;
 
 -- Electronics (15 products)
-INSERT INTO products (name, description, price, category, stock, image_url) VALUES
-('iPhone 15 Pro', 'Latest iPhone with A17 chip and titanium design', 999.99, 'Electronics', 50, 'https://images.unsplash.com/photo-1678685888221-cda773a3dcdb?w=400'),
-('Samsung Galaxy S24 Ultra', 'Flagship Android phone with S Pen', 1199.99, 'Electronics', 45, 'https://images.unsplash.com/photo-1610945415295-d9bbf067e59c?w=400'),
-('MacBook Air M3', '13-inch laptop with M3 chip', 1299.99, 'Electronics', 30, 'https://images.unsplash.com/photo-1517336714731-489689fd1ca8?w=400'),
-('Dell XPS 15', 'High-performance Windows laptop', 1499.99, 'Electronics', 25, 'https://images.unsplash.com/photo-1593642632823-8f785ba67e45?w=400'),
-('iPad Pro 12.9', 'Tablet with M2 chip and Liquid Retina display', 1099.99, 'Electronics', 40, 'https://images.unsplash.com/photo-1544244015-0df4b3ffc6b0?w=400'),
-('Sony WH-1000XM5', 'Industry-leading noise-canceling headphones', 399.99, 'Electronics', 100, 'https://images.unsplash.com/photo-1546435770-a3e426bf472b?w=400'),
-('Apple Watch Series 9', 'Advanced smartwatch with health features', 429.99, 'Electronics', 80, 'https://images.unsplash.com/photo-1579586337278-3befd40fd17a?w=400'),
-('Bose QuietComfort Ultra', 'Premium wireless earbuds', 299.99, 'Electronics', 120, 'https://images.unsplash.com/photo-1590658165737-15a047b7a63e?w=400'),
-('Canon EOS R6 Mark II', 'Professional mirrorless camera', 2499.99, 'Electronics', 15, 'https://images.unsplash.com/photo-1606983340126-99ab4feaa64a?w=400'),
-('Sony A7 IV', 'Full-frame hybrid camera', 2599.99, 'Electronics', 12, 'https://images.unsplash.com/photo-1606983340575-27bf39ce1f43?w=400'),
-('Gaming Laptop RTX 4080', 'High-end gaming laptop with RTX graphics', 2199.99, 'Electronics', 20, 'https://images.unsplash.com/photo-1603481588273-2f908a9a7a1b?w=400'),
-('Mechanical Keyboard RGB', 'Premium mechanical gaming keyboard', 179.99, 'Electronics', 75, 'https://images.unsplash.com/photo-1595225476474-87563907a212?w=400'),
-('4K Monitor 32 inch', 'Professional 4K display', 699.99, 'Electronics', 40, 'https://images.unsplash.com/photo-1527443224154-c4a3942d3acf?w=400'),
-('Wireless Gaming Mouse', 'High-precision gaming mouse', 89.99, 'Electronics', 150, 'https://images.unsplash.com/photo-1527864550417-7fd91fc51a46?w=400'),
-('USB-C Docking Station', 'Multi-port USB-C hub', 149.99, 'Electronics', 90, 'https://images.unsplash.com/photo-1625948515291-69613efd103f?w=400'),
+INSERT INTO products (name, description, price_cents, category, stock, image_url) VALUES
+('iPhone 15 Pro', 'Latest iPhone with A17 chip and titanium design', 99999, 'Electronics', 50, 'https://images.unsplash.com/photo-1678685888221-cda773a3dcdb?w=400'),
+('Samsung Galaxy S24 Ultra', 'Flagship Android phone with S Pen', 119999, 'Electronics', 45, 'https://images.unsplash.com/photo-1610945415295-d9bbf067e59c?w=400'),
+('MacBook Air M3', '13-inch laptop with M3 chip', 129999, 'Electronics', 30, 'https://images.unsplash.com/photo-1517336714731-489689fd1ca8?w=400'),
+('Dell XPS 15', 'High-performance Windows laptop', 149999, 'Electronics', 25, 'https://images.unsplash.com/photo-1593642632823-8f785ba67e45?w=400'),
+('iPad Pro 12.9', 'Tablet with M2 chip and Liquid Retina display', 109999, 'Electronics', 40, 'https://images.unsplash.com/photo-1544244015-0df4b3ffc6b0?w=400'),
+('Sony WH-1000XM5', 'Industry-leading noise-canceling headphones', 39999, 'Electronics', 100, 'https://images.unsplash.com/photo-1546435770-a3e426bf472b?w=400'),
+('Apple Watch Series 9', 'Advanced smartwatch with health features', 42999, 'Electronics', 80, 'https://images.unsplash.com/photo-1579586337278-3befd40fd17a?w=400'),
+('Bose QuietComfort Ultra', 'Premium wireless earbuds', 29999, 'Electronics', 120, 'https://images.unsplash.com/photo-1590658165737-15a047b7a63e?w=400'),
+('Canon EOS R6 Mark II', 'Professional mirrorless camera', 249999, 'Electronics', 15, 'https://images.unsplash.com/photo-1606983340126-99ab4feaa64a?w=400'),
+('Sony A7 IV', 'Full-frame hybrid camera', 259999, 'Electronics', 12, 'https://images.unsplash.com/photo-1606983340575-27bf39ce1f43?w=400'),
+('Gaming Laptop RTX 4080', 'High-end gaming laptop with RTX graphics', 219999, 'Electronics', 20, 'https://images.unsplash.com/photo-1603481588273-2f908a9a7a1b?w=400'),
+('Mechanical Keyboard RGB', 'Premium mechanical gaming keyboard', 17999, 'Electronics', 75, 'https://images.unsplash.com/photo-1595225476474-87563907a212?w=400'),
+('4K Monitor 32 inch', 'Professional 4K display', 69999, 'Electronics', 40, 'https://images.unsplash.com/photo-1527443224154-c4a3942d3acf?w=400'),
+('Wireless Gaming Mouse', 'High-precision gaming mouse', 8999, 'Electronics', 150, 'https://images.unsplash.com/photo-1527864550417-7fd91fc51a46?w=400'),
+('USB-C Docking Station', 'Multi-port USB-C hub', 14999, 'Electronics', 90, 'https://images.unsplash.com/photo-1625948515291-69613efd103f?w=400'),
 
 -- Clothing (20 products)
-('Nike Air Max 270', 'Comfortable running shoes', 129.99, 'Clothing', 150, 'https://images.unsplash.com/photo-1542291026-7eec264c27ff?w=400'),
-('Adidas Ultraboost', 'Performance running sneakers', 180.00, 'Clothing', 120, 'https://images.unsplash.com/photo-1608231387042-66d1773070a5?w=400'),
-('Levi 501 Original Jeans', 'Classic straight-leg jeans', 69.99, 'Clothing', 200, 'https://images.unsplash.com/photo-1542272604-787c3835535d?w=400'),
-('North Face Parka', 'Waterproof winter jacket', 299.99, 'Clothing', 80, 'https://images.unsplash.com/photo-1551028719-00167b16eac5?w=400'),
-('Patagonia Fleece', 'Lightweight pullover fleece', 149.99, 'Clothing', 100, 'https://images.unsplash.com/photo-1578587018452-892bacefd3f2?w=400'),
-('Ray-Ban Aviator', 'Classic aviator sunglasses', 154.00, 'Clothing', 90, 'https://images.unsplash.com/photo-1511499767150-a48a237f0083?w=400'),
-('Wool Overcoat', 'Classic wool dress coat', 249.99, 'Clothing', 60, 'https://images.unsplash.com/photo-1539533018447-63fcce2678e3?w=400'),
-('Leather Jacket', 'Genuine leather moto jacket', 399.99, 'Clothing', 45, 'https://images.unsplash.com/photo-1551028719-00167b16eac5?w=400'),
-('Cashmere Sweater', 'Premium cashmere pullover', 189.99, 'Clothing', 70, 'https://images.unsplash.com/photo-1576566588028-4147f3842f27?w=400'),
-('Silk Dress Shirt', 'Formal dress shirt', 89.99, 'Clothing', 110, 'https://images.unsplash.com/photo-1602810319428-019690571b5b?w=400'),
-('Yoga Pants', 'High-waist athletic leggings', 59.99, 'Clothing', 180, 'https://images.unsplash.com/photo-1506629082955-511b1aa562c8?w=400'),
-('Running Shorts', 'Lightweight athletic shorts', 39.99, 'Clothing', 200, 'https://images.unsplash.com/photo-1591195853828-11db59a44f6b?w=400'),
-('Hoodie', 'Comfortable pullover hoodie', 59.99, 'Clothing', 220, 'https://images.unsplash.com/photo-1556821840-3a63f95609a7?w=400'),
-('Baseball Cap', 'Adjustable sports cap', 24.99, 'Clothing', 250, 'https://images.unsplash.com/photo-1588850561407-ed78c282e89b?w=400'),
-('Winter Beanie', 'Warm knit beanie', 19.99, 'Clothing', 300, 'https://images.unsplash.com/photo-1576871337622-98d48d1cf531?w=400'),
-('Dress Shoes', 'Leather oxford dress shoes', 129.99, 'Clothing', 85, 'https://images.unsplash.com/photo-1533867617858-e7b97e060509?w=400'),
-('Sneakers White', 'Classic white sneakers', 79.99, 'Clothing', 160, 'https://images.unsplash.com/photo-1595950653106-6c9ebd614d3a?w=400'),
-('Backpack', 'Durable travel backpack', 89.99, 'Clothing', 140, 'https://images.unsplash.com/photo-1553062407-98eeb64c6a62?w=400'),
-('Leather Wallet', 'Genuine leather bifold wallet', 49.99, 'Clothing', 200, 'https://images.unsplash.com/photo-1627123424574-724758594e93?w=400'),
-('Wristwatch', 'Stainless steel watch', 199.99, 'Clothing', 75, 'https://images.unsplash.com/photo-1524592094714-0f0654e20314?w=400'),
+('Nike Air Max 270', 'Comfortable running shoes', 12999, 'Clothing', 150, 'https://images.unsplash.com/photo-1542291026-7eec264c27ff?w=400'),
+('Adidas Ultraboost', 'Performance running sneakers', 18000, 'Clothing', 120, 'https://images.unsplash.com/photo-1608231387042-66d1773070a5?w=400'),
+('Levi 501 Original Jeans', 'Classic straight-leg jeans', 6999, 'Clothing', 200, 'https://images.unsplash.com/photo-1542272604-787c3835535d?w=400'),
+('North Face Parka', 'Waterproof winter jacket', 29999, 'Clothing', 80, 'https://images.unsplash.com/photo-1551028719-00167b16eac5?w=400'),
+('Patagonia Fleece', 'Lightweight pullover fleece', 14999, 'Clothing', 100, 'https://images.unsplash.com/photo-1578587018452-892bacefd3f2?w=400'),
+('Ray-Ban Aviator', 'Classic aviator sunglasses', 15400, 'Clothing', 90, 'https://images.unsplash.com/photo-1511499767150-a48a237f0083?w=400'),
+('Wool Overcoat', 'Classic wool dress coat', 24999, 'Clothing', 60, 'https://images.unsplash.com/photo-1539533018447-63fcce2678e3?w=400'),
+('Leather Jacket', 'Genuine leather moto jacket', 39999, 'Clothing', 45, 'https://images.unsplash.com/photo-1551028719-00167b16eac5?w=400'),
+('Cashmere Sweater', 'Premium cashmere pullover', 18999, 'Clothing', 70, 'https://images.unsplash.com/photo-1576566588028-4147f3842f27?w=400'),
+('Silk Dress Shirt', 'Formal dress shirt', 8999, 'Clothing', 110, 'https://images.unsplash.com/photo-1602810319428-019690571b5b?w=400'),
+('Yoga Pants', 'High-waist athletic leggings', 5999, 'Clothing', 180, 'https://images.unsplash.com/photo-1506629082955-511b1aa562c8?w=400'),
+('Running Shorts', 'Lightweight athletic shorts', 3999, 'Clothing', 200, 'https://images.unsplash.com/photo-1591195853828-11db59a44f6b?w=400'),
+('Hoodie', 'Comfortable pullover hoodie', 5999, 'Clothing', 220, 'https://images.unsplash.com/photo-1556821840-3a63f95609a7?w=400'),
+('Baseball Cap', 'Adjustable sports cap', 2499, 'Clothing', 250, 'https://images.unsplash.com/photo-1588850561407-ed78c282e89b?w=400'),
+('Winter Beanie', 'Warm knit beanie', 1999, 'Clothing', 300, 'https://images.unsplash.com/photo-1576871337622-98d48d1cf531?w=400'),
+('Dress Shoes', 'Leather oxford dress shoes', 12999, 'Clothing', 85, 'https://images.unsplash.com/photo-1533867617858-e7b97e060509?w=400'),
+('Sneakers White', 'Classic white sneakers', 7999, 'Clothing', 160, 'https://images.unsplash.com/photo-1595950653106-6c9ebd614d3a?w=400'),
+('Backpack', 'Durable travel backpack', 8999, 'Clothing', 140, 'https://images.unsplash.com/photo-1553062407-98eeb64c6a62?w=400'),
+('Leather Wallet', 'Genuine leather bifold wallet', 4999, 'Clothing', 200, 'https://images.unsplash.com/photo-1627123424574-724758594e93?w=400'),
+('Wristwatch', 'Stainless steel watch', 19999, 'Clothing', 75, 'https://images.unsplash.com/photo-1524592094714-0f0654e20314?w=400'),
 
 -- Home & Kitchen (15 products)
-('Dyson V15 Detect', 'Cordless vacuum with laser detection', 649.99, 'Home', 50, 'https://images.unsplash.com/photo-1558317374-067fb5f30001?w=400'),
-('Ninja Blender Pro', '1000W high-speed blender', 129.99, 'Home', 100, 'https://images.unsplash.com/photo-1585515320310-259814833e62?w=400'),
-('KitchenAid Stand Mixer', 'Professional 5-quart mixer', 379.99, 'Home', 60, 'https://images.unsplash.com/photo-1578643463396-0997cb5328c1?w=400'),
-('Instant Pot Duo', '7-in-1 multi-cooker', 99.99, 'Home', 150, 'https://images.unsplash.com/photo-1585515320310-259814833e62?w=400'),
-('Nespresso Machine', 'Automatic coffee maker', 199.99, 'Home', 80, 'https://images.unsplash.com/photo-1517668808822-9ebb02f2a0e6?w=400'),
-('Air Fryer XL', 'Large capacity air fryer', 129.99, 'Home', 120, 'https://images.unsplash.com/photo-1585515320310-259814833e62?w=400'),
-('Robot Vacuum', 'Smart mapping robot vacuum', 449.99, 'Home', 40, 'https://images.unsplash.com/photo-1563199103-141a3088b5ad?w=400'),
-('Memory Foam Mattress', 'Queen size cooling mattress', 699.99, 'Home', 30, 'https://images.unsplash.com/photo-1505693416388-ac5ce068fe85?w=400'),
-('Desk Lamp LED', 'Adjustable LED desk lamp', 49.99, 'Home', 150, 'https://images.unsplash.com/photo-1513506003901-1e6a229e2d15?w=400'),
-('Bath Towel Set', 'Luxury cotton towel set', 59.99, 'Home', 200, 'https://images.unsplash.com/photo-1620799140408-edc6dcb6d633?w=400'),
-('Throw Blanket', 'Soft fleece throw blanket', 39.99, 'Home', 180, 'https://images.unsplash.com/photo-1602143407151-7111542de6e8?w=400'),
-('Cookware Set', '10-piece non-stick cookware', 199.99, 'Home', 70, 'https://images.unsplash.com/photo-1584990347449-39b0e5bc255b?w=400'),
-('Knife Set', 'Professional chef knife set', 149.99, 'Home', 90, 'https://images.unsplash.com/photo-1593618998160-e34014e67546?w=400'),
-('Storage Containers', 'Glass food storage set', 44.99, 'Home', 160, 'https://images.unsplash.com/photo-1562843327-e185f95b0ca3?w=400'),
-('Table Lamp', 'Modern ceramic table lamp', 79.99, 'Home', 110, 'https://images.unsplash.com/photo-1507473885765-e6ed057f782c?w=400');
+('Dyson V15 Detect', 'Cordless vacuum with laser detection', 64999, 'Home', 50, 'https://images.unsplash.com/photo-1558317374-067fb5f30001?w=400'),
+('Ninja Blender Pro', '1000W high-speed blender', 12999, 'Home', 100, 'https://images.unsplash.com/photo-1585515320310-259814833e62?w=400'),
+('KitchenAid Stand Mixer', 'Professional 5-quart mixer', 37999, 'Home', 60, 'https://images.unsplash.com/photo-1578643463396-0997cb5328c1?w=400'),
+('Instant Pot Duo', '7-in-1 multi-cooker', 9999, 'Home', 150, 'https://images.unsplash.com/photo-1585515320310-259814833e62?w=400'),
+('Nespresso Machine', 'Automatic coffee maker', 19999, 'Home', 80, 'https://images.unsplash.com/photo-1517668808822-9ebb02f2a0e6?w=400'),
+('Air Fryer XL', 'Large capacity air fryer', 12999, 'Home', 120, 'https://images.unsplash.com/photo-1585515320310-259814833e62?w=400'),
+('Robot Vacuum', 'Smart mapping robot vacuum', 44999, 'Home', 40, 'https://images.unsplash.com/photo-1563199103-141a3088b5ad?w=400'),
+('Memory Foam Mattress', 'Queen size cooling mattress', 69999, 'Home', 30, 'https://images.unsplash.com/photo-1505693416388-ac5ce068fe85?w=400'),
+('Desk Lamp LED', 'Adjustable LED desk lamp', 4999, 'Home', 150, 'https://images.unsplash.com/photo-1513506003901-1e6a229e2d15?w=400'),
+('Bath Towel Set', 'Luxury cotton towel set', 5999, 'Home', 200, 'https://images.unsplash.com/photo-1620799140408-edc6dcb6d633?w=400'),
+('Throw Blanket', 'Soft fleece throw blanket', 3999, 'Home', 180, 'https://images.unsplash.com/photo-1602143407151-7111542de6e8?w=400'),
+('Cookware Set', '10-piece non-stick cookware', 19999, 'Home', 70, 'https://images.unsplash.com/photo-1584990347449-39b0e5bc255b?w=400'),
+('Knife Set', 'Professional chef knife set', 14999, 'Home', 90, 'https://images.unsplash.com/photo-1593618998160-e34014e67546?w=400'),
+('Storage Containers', 'Glass food storage set', 4499, 'Home', 160, 'https://images.unsplash.com/photo-1562843327-e185f95b0ca3?w=400'),
+('Table Lamp', 'Modern ceramic table lamp', 7999, 'Home', 110, 'https://images.unsplash.com/photo-1507473885765-e6ed057f782c?w=400');